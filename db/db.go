@@ -2,7 +2,10 @@ package db
 
 import (
 	"fmt"
+	"reflect"
+	"time"
 
+	"github.com/mitchellh/mapstructure"
 	"github.com/teejays/clog"
 	"github.com/teejays/gofiledb"
 
@@ -15,6 +18,21 @@ var isInitialized bool
 
 var UserCollection string = "user"
 var LikeCollection string = "like"
+var LikeCounterCollection string = "like_counter"
+var PassCollection string = "pass"
+var PhotoCollection string = "photo"
+var BlockCollection string = "block"
+var ReportCollection string = "report"
+var ConversationCollection string = "conversation"
+var MessageCollection string = "message"
+var NotificationCollection string = "notification"
+var NotificationPreferenceCollection string = "notification_preference"
+var OutboxCollection string = "outbox"
+var TwoFactorCollection string = "two_factor"
+var AuditCollection string = "audit"
+var SessionCollection string = "session"
+var APIKeyCollection string = "api_key"
+var OAuthIdentityCollection string = "oauth_identity"
 
 // InitDB initializes the database connection
 func InitDB() error {
@@ -49,6 +67,24 @@ func initClient(dir string) (*gofiledb.Client, error) {
 		return nil, fmt.Errorf("could not create the index 'ReceiverID' on '%s' collection: %v", LikeCollection, err)
 	}
 	
+	// Create the index on 'IsDeleted' field so we can list all the active users
+	err = cl.AddIndex(UserCollection, "IsDeleted")
+	if err != nil {
+		return nil, fmt.Errorf("could not create the index 'IsDeleted' on '%s' collection: %v", UserCollection, err)
+	}
+
+	// Create the index on 'Geohash' field so we can look up users by their location
+	err = cl.AddIndex(UserCollection, "Geohash")
+	if err != nil {
+		return nil, fmt.Errorf("could not create the index 'Geohash' on '%s' collection: %v", UserCollection, err)
+	}
+
+	// Create the index on 'ModerationStatus' field so the moderators can find the flagged profiles
+	err = cl.AddIndex(UserCollection, "ModerationStatus")
+	if err != nil {
+		return nil, fmt.Errorf("could not create the index 'ModerationStatus' on '%s' collection: %v", UserCollection, err)
+	}
+
 	// Create the like collections
 	err = cl.AddCollection(gofiledb.CollectionProps{Name: LikeCollection, EncodingType: gofiledb.ENCODING_JSON})
 	if err != nil {
@@ -65,6 +101,160 @@ func initClient(dir string) (*gofiledb.Client, error) {
 		return nil, fmt.Errorf("could not create the index 'ReceiverID' on '%s' collection: %v", LikeCollection, err)
 	}
 
+	// Create the like counter collection, which keeps track of the likes given by each user
+	err = cl.AddCollection(gofiledb.CollectionProps{Name: LikeCounterCollection, EncodingType: gofiledb.ENCODING_JSON})
+	if err != nil {
+		return nil, fmt.Errorf("could not create the gofiledb '%s' collection: %v", LikeCounterCollection, err)
+	}
+
+	// Create the pass collection, which stores the users that a user has passed on
+	err = cl.AddCollection(gofiledb.CollectionProps{Name: PassCollection, EncodingType: gofiledb.ENCODING_JSON})
+	if err != nil {
+		return nil, fmt.Errorf("could not create the gofiledb '%s' collection: %v", PassCollection, err)
+	}
+	err = cl.AddIndex(PassCollection, "GiverID")
+	if err != nil {
+		return nil, fmt.Errorf("could not create the index 'GiverID' on '%s' collection: %v", PassCollection, err)
+	}
+
+	// Create the photo collection, which stores the metadata of the images uploaded by the users
+	err = cl.AddCollection(gofiledb.CollectionProps{Name: PhotoCollection, EncodingType: gofiledb.ENCODING_JSON})
+	if err != nil {
+		return nil, fmt.Errorf("could not create the gofiledb '%s' collection: %v", PhotoCollection, err)
+	}
+	err = cl.AddIndex(PhotoCollection, "UserID")
+	if err != nil {
+		return nil, fmt.Errorf("could not create the index 'UserID' on '%s' collection: %v", PhotoCollection, err)
+	}
+
+	// Create the block collection, which stores the users that a user has blocked
+	err = cl.AddCollection(gofiledb.CollectionProps{Name: BlockCollection, EncodingType: gofiledb.ENCODING_JSON})
+	if err != nil {
+		return nil, fmt.Errorf("could not create the gofiledb '%s' collection: %v", BlockCollection, err)
+	}
+	err = cl.AddIndex(BlockCollection, "BlockerID")
+	if err != nil {
+		return nil, fmt.Errorf("could not create the index 'BlockerID' on '%s' collection: %v", BlockCollection, err)
+	}
+	err = cl.AddIndex(BlockCollection, "BlockedID")
+	if err != nil {
+		return nil, fmt.Errorf("could not create the index 'BlockedID' on '%s' collection: %v", BlockCollection, err)
+	}
+
+	// Create the report collection, which stores the reports that the users make against each other
+	err = cl.AddCollection(gofiledb.CollectionProps{Name: ReportCollection, EncodingType: gofiledb.ENCODING_JSON})
+	if err != nil {
+		return nil, fmt.Errorf("could not create the gofiledb '%s' collection: %v", ReportCollection, err)
+	}
+	for _, field := range []string{"ReporterID", "ReportedID", "Status"} {
+		err = cl.AddIndex(ReportCollection, field)
+		if err != nil {
+			return nil, fmt.Errorf("could not create the index '%s' on '%s' collection: %v", field, ReportCollection, err)
+		}
+	}
+
+	// Create the conversation collection, which stores the chats between matched users. The users of
+	// a conversation are stored in order (UserAID < UserBID) so that a pair has a single conversation.
+	err = cl.AddCollection(gofiledb.CollectionProps{Name: ConversationCollection, EncodingType: gofiledb.ENCODING_JSON})
+	if err != nil {
+		return nil, fmt.Errorf("could not create the gofiledb '%s' collection: %v", ConversationCollection, err)
+	}
+	for _, field := range []string{"UserAID", "UserBID"} {
+		err = cl.AddIndex(ConversationCollection, field)
+		if err != nil {
+			return nil, fmt.Errorf("could not create the index '%s' on '%s' collection: %v", field, ConversationCollection, err)
+		}
+	}
+
+	// Create the message collection, which stores the messages sent in the conversations
+	err = cl.AddCollection(gofiledb.CollectionProps{Name: MessageCollection, EncodingType: gofiledb.ENCODING_JSON})
+	if err != nil {
+		return nil, fmt.Errorf("could not create the gofiledb '%s' collection: %v", MessageCollection, err)
+	}
+	err = cl.AddIndex(MessageCollection, "ConversationID")
+	if err != nil {
+		return nil, fmt.Errorf("could not create the index 'ConversationID' on '%s' collection: %v", MessageCollection, err)
+	}
+
+	// Create the notification collection, which stores the inbox of every user
+	err = cl.AddCollection(gofiledb.CollectionProps{Name: NotificationCollection, EncodingType: gofiledb.ENCODING_JSON})
+	if err != nil {
+		return nil, fmt.Errorf("could not create the gofiledb '%s' collection: %v", NotificationCollection, err)
+	}
+	err = cl.AddIndex(NotificationCollection, "UserID")
+	if err != nil {
+		return nil, fmt.Errorf("could not create the index 'UserID' on '%s' collection: %v", NotificationCollection, err)
+	}
+
+	// Create the notification preference collection, which stores the preferences of each user by their ID
+	err = cl.AddCollection(gofiledb.CollectionProps{Name: NotificationPreferenceCollection, EncodingType: gofiledb.ENCODING_JSON})
+	if err != nil {
+		return nil, fmt.Errorf("could not create the gofiledb '%s' collection: %v", NotificationPreferenceCollection, err)
+	}
+
+	// Create the outbox collection, which stores the emails until they are sent
+	err = cl.AddCollection(gofiledb.CollectionProps{Name: OutboxCollection, EncodingType: gofiledb.ENCODING_JSON})
+	if err != nil {
+		return nil, fmt.Errorf("could not create the gofiledb '%s' collection: %v", OutboxCollection, err)
+	}
+	err = cl.AddIndex(OutboxCollection, "Status")
+	if err != nil {
+		return nil, fmt.Errorf("could not create the index 'Status' on '%s' collection: %v", OutboxCollection, err)
+	}
+
+	// Create the two factor collection, which stores the two-factor authentication settings of each user by
+	// their ID. It's kept apart from the users so that the secrets are never sent back with a user.
+	err = cl.AddCollection(gofiledb.CollectionProps{Name: TwoFactorCollection, EncodingType: gofiledb.ENCODING_JSON})
+	if err != nil {
+		return nil, fmt.Errorf("could not create the gofiledb '%s' collection: %v", TwoFactorCollection, err)
+	}
+
+	// Create the audit collection, which records the security events like account lockouts
+	err = cl.AddCollection(gofiledb.CollectionProps{Name: AuditCollection, EncodingType: gofiledb.ENCODING_JSON})
+	if err != nil {
+		return nil, fmt.Errorf("could not create the gofiledb '%s' collection: %v", AuditCollection, err)
+	}
+	for _, field := range []string{"Event", "UserID"} {
+		err = cl.AddIndex(AuditCollection, field)
+		if err != nil {
+			return nil, fmt.Errorf("could not create the index '%s' on '%s' collection: %v", field, AuditCollection, err)
+		}
+	}
+
+	// Create the session collection, which stores the devices that the users are logged in on
+	err = cl.AddCollection(gofiledb.CollectionProps{Name: SessionCollection, EncodingType: gofiledb.ENCODING_JSON})
+	if err != nil {
+		return nil, fmt.Errorf("could not create the gofiledb '%s' collection: %v", SessionCollection, err)
+	}
+	err = cl.AddIndex(SessionCollection, "UserID")
+	if err != nil {
+		return nil, fmt.Errorf("could not create the index 'UserID' on '%s' collection: %v", SessionCollection, err)
+	}
+
+	// Create the API key collection, which stores the keys used by the internal tools, hashed
+	err = cl.AddCollection(gofiledb.CollectionProps{Name: APIKeyCollection, EncodingType: gofiledb.ENCODING_JSON})
+	if err != nil {
+		return nil, fmt.Errorf("could not create the gofiledb '%s' collection: %v", APIKeyCollection, err)
+	}
+	for _, field := range []string{"Prefix", "IsRevoked"} {
+		err = cl.AddIndex(APIKeyCollection, field)
+		if err != nil {
+			return nil, fmt.Errorf("could not create the index '%s' on '%s' collection: %v", field, APIKeyCollection, err)
+		}
+	}
+
+	// Create the OAuth identity collection, which links the users to their accounts at the identity providers
+	err = cl.AddCollection(gofiledb.CollectionProps{Name: OAuthIdentityCollection, EncodingType: gofiledb.ENCODING_JSON})
+	if err != nil {
+		return nil, fmt.Errorf("could not create the gofiledb '%s' collection: %v", OAuthIdentityCollection, err)
+	}
+	for _, field := range []string{"Key", "UserID"} {
+		err = cl.AddIndex(OAuthIdentityCollection, field)
+		if err != nil {
+			return nil, fmt.Errorf("could not create the index '%s' on '%s' collection: %v", field, OAuthIdentityCollection, err)
+		}
+	}
+
 	return cl, nil
 }
 
@@ -90,6 +280,11 @@ func GetEntityByID(collection string, key pk.ID, addr interface{}) error {
 
 }
 
+// IsNotExist returns true if the error returned by the database means that the requested entity does not exist
+func IsNotExist(err error) bool {
+	return gofiledb.IsNotExist(err)
+}
+
 // SaveEntityByID saves an entity by it's ID in the persistent storage
 func SaveEntityByID(collection string, key pk.ID, entity interface{}) error {
 
@@ -130,3 +325,34 @@ func Query(collection string, query string) ([]interface{}, error) {
 
 	return resp.Result, nil
 }
+
+// DecodeQueryResult converts the result of a Query, which is a slice of maps, into the provided
+// addr, which should be a pointer to a slice of structs
+func DecodeQueryResult(result []interface{}, addr interface{}) error {
+
+	stringToDateTimeHook := func(
+		f reflect.Type,
+		t reflect.Type,
+		data interface{}) (interface{}, error) {
+		if t == reflect.TypeOf(time.Time{}) && f == reflect.TypeOf("") {
+			return time.Parse(time.RFC3339, data.(string))
+		}
+
+		return data, nil
+	}
+	config := mapstructure.DecoderConfig{
+		DecodeHook: stringToDateTimeHook,
+		Result:     addr,
+	}
+
+	decoder, err := mapstructure.NewDecoder(&config)
+	if err != nil {
+		return err
+	}
+	err = decoder.Decode(result)
+	if err != nil {
+		return fmt.Errorf("could not convert map to a struct: %v", err)
+	}
+
+	return nil
+}
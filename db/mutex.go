@@ -5,8 +5,23 @@ import (
 )
 
 var lockMap = map[string]*sync.RWMutex{
-	UserCollection: &sync.RWMutex{},
-	LikeCollection: &sync.RWMutex{},
+	UserCollection:                   &sync.RWMutex{},
+	LikeCollection:                   &sync.RWMutex{},
+	LikeCounterCollection:            &sync.RWMutex{},
+	PassCollection:                   &sync.RWMutex{},
+	PhotoCollection:                  &sync.RWMutex{},
+	BlockCollection:                  &sync.RWMutex{},
+	ReportCollection:                 &sync.RWMutex{},
+	ConversationCollection:           &sync.RWMutex{},
+	MessageCollection:                &sync.RWMutex{},
+	NotificationCollection:           &sync.RWMutex{},
+	NotificationPreferenceCollection: &sync.RWMutex{},
+	OutboxCollection:                 &sync.RWMutex{},
+	TwoFactorCollection:              &sync.RWMutex{},
+	AuditCollection:                  &sync.RWMutex{},
+	SessionCollection:                &sync.RWMutex{},
+	APIKeyCollection:                 &sync.RWMutex{},
+	OAuthIdentityCollection:          &sync.RWMutex{},
 }
 
 func lock(collection string) {
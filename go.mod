@@ -1,11 +1,10 @@
 module github.com/teejays/matchapi
 
-go 1.12
+go 1.13
 
 require (
 	github.com/gorilla/mux v1.7.2
 	github.com/mitchellh/mapstructure v1.1.2
 	github.com/teejays/clog v0.0.0-20181107215916-71000d459f17
-	github.com/teejays/go-jwt v0.0.0-20190622014119-237432539dd3
 	github.com/teejays/gofiledb v0.0.0-20190426053753-1547497065c6
 )
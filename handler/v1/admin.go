@@ -0,0 +1,468 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/db"
+	authLib "github.com/teejays/matchapi/lib/auth"
+	"github.com/teejays/matchapi/lib/pk"
+	"github.com/teejays/matchapi/lib/rest"
+	"github.com/teejays/matchapi/service/audit/v1"
+	"github.com/teejays/matchapi/service/report/v1"
+	"github.com/teejays/matchapi/service/stats/v1"
+	"github.com/teejays/matchapi/service/user/v1"
+)
+
+// The handlers in this file are registered under the /admin routes, which only let in the users
+// with the admin or moderator role
+
+// ReviewProfileRequest is the request used by the admins to review a flagged profile
+type ReviewProfileRequest struct {
+	Approved bool
+}
+
+// SuspendUserRequest is the request used by the admins to suspend a user
+type SuspendUserRequest struct {
+	Days int
+}
+
+// UpdateRolesRequest is the request used by the admins to change the roles of a user
+type UpdateRolesRequest struct {
+	Roles []string
+}
+
+// HandleGetOpenReports lists the reports that need to be acted on by an admin
+// Example Request: curl -v localhost:8080/admin/reports
+func HandleGetOpenReports(w http.ResponseWriter, r *http.Request) {
+
+	reports, err := report.GetOpenReports()
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+	if reports == nil {
+		reports = []report.Report{}
+	}
+
+	writeJSON(w, http.StatusOK, reports)
+}
+
+// HandleGetReport provides a report along with the reported profile and the history of both the users
+// Example Request: curl -v localhost:8080/admin/reports/{id}
+func HandleGetReport(w http.ResponseWriter, r *http.Request) {
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		rest.WriteError(w, http.StatusBadRequest, "The report ID in the path should be a number")
+		return
+	}
+
+	details, err := report.GetDetails(pk.ID(id))
+	if err == report.ErrReportDoesNotExist {
+		rest.WriteError(w, http.StatusNotFound, "The report does not exist")
+		return
+	}
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, details)
+}
+
+// HandlePostReportAction resolves a report by taking an action against the reported user
+// Example Request: curl -v -X "POST" localhost:8080/admin/reports/{id}/action -d '{"Action": "suspend", "Days": 7, "Note": "Spamming"}'
+func HandlePostReportAction(w http.ResponseWriter, r *http.Request) {
+	// Get the userID of the admin from the request
+	adminID, err := authLib.GetUserIdFromRequest(r)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		rest.WriteError(w, http.StatusBadRequest, "The report ID in the path should be a number")
+		return
+	}
+
+	// Read the HTTP request body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusBadRequest, "There was an error reading the request")
+		return
+	}
+
+	// Json unmarshal the request into the ActionRequest struct
+	var req report.ActionRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusBadRequest, "There was an error json unmarshaling the request")
+		return
+	}
+	if err := req.Validate(); err != nil {
+		rest.WriteErrorCode(w, http.StatusBadRequest, rest.CodeValidationFailed, fmt.Sprintf("There was an error validating the request: %v", err))
+		return
+	}
+
+	rpt, err := report.TakeAction(pk.ID(id), adminID, req)
+	if err == report.ErrReportDoesNotExist {
+		rest.WriteError(w, http.StatusNotFound, "The report does not exist")
+		return
+	}
+	if err == report.ErrReportAlreadyResolved {
+		rest.WriteError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err == report.ErrReportedHasMoreAccess {
+		rest.WriteError(w, http.StatusForbidden, fmt.Sprintf("Access denied: %v", err))
+		return
+	}
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, rpt)
+}
+
+// HandleGetFlaggedProfiles lists the profiles that have been flagged by the moderation filter
+// Example Request: curl -v localhost:8080/admin/moderation
+func HandleGetFlaggedProfiles(w http.ResponseWriter, r *http.Request) {
+
+	users, err := user.GetFlaggedUsers()
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	var views = []user.AdminUser{}
+	for i := range users {
+		views = append(views, users[i].AdminView())
+	}
+
+	writeJSON(w, http.StatusOK, views)
+}
+
+// HandleReviewFlaggedProfile approves a flagged profile, or removes the flagged text from it
+// Example Request: curl -v -X "POST" localhost:8080/admin/moderation/{id} -d '{"Approved": true}'
+func HandleReviewFlaggedProfile(w http.ResponseWriter, r *http.Request) {
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		rest.WriteError(w, http.StatusBadRequest, "The user ID in the path should be a number")
+		return
+	}
+
+	// Read the HTTP request body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusBadRequest, "There was an error reading the request")
+		return
+	}
+	var req ReviewProfileRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusBadRequest, "There was an error json unmarshaling the request")
+		return
+	}
+
+	usr, err := user.GetUserByID(pk.ID(id))
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusNotFound, "The user does not exist")
+		return
+	}
+	if !usr.IsFlagged() {
+		rest.WriteError(w, http.StatusConflict, "The profile is not waiting to be reviewed")
+		return
+	}
+
+	err = usr.ReviewModeration(req.Approved)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, usr.AdminView())
+}
+
+// HandleGetAdminUser provides everything about a user except the credentials
+// Example Request: curl -v localhost:8080/admin/users/{id}
+func HandleGetAdminUser(w http.ResponseWriter, r *http.Request) {
+	usr, ok := getUserFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, usr.AdminView())
+}
+
+// HandleSearchAdminUsers looks up users by their email
+// Example Request: curl -v "localhost:8080/admin/users?email=jon.doe@email.com"
+func HandleSearchAdminUsers(w http.ResponseWriter, r *http.Request) {
+	email := strings.TrimSpace(r.URL.Query().Get("email"))
+	if email == "" {
+		rest.WriteErrorCode(w, http.StatusBadRequest, rest.CodeValidationFailed, "There was an error validating the request: the email query param is required")
+		return
+	}
+
+	creds, err := user.GetUserCredsByEmail(email)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	var views = []user.AdminUser{}
+	for _, c := range creds {
+		usr, err := user.GetUserByID(c.ID)
+		if err != nil {
+			clog.Error(err.Error())
+			rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+			return
+		}
+		views = append(views, usr.AdminView())
+	}
+
+	writeJSON(w, http.StatusOK, views)
+}
+
+// HandleSuspendUser suspends a user for a number of days. Users with roles that the caller doesn't have, e.g.
+// the admins for a moderator, cannot be suspended, so that the moderators can't lock the admins out.
+// Example Request: curl -v -X "POST" localhost:8080/admin/users/{id}/suspension -d '{"Days": 7}'
+func HandleSuspendUser(w http.ResponseWriter, r *http.Request) {
+
+	// Get the payload from the request, which has the roles of the caller
+	payload, err := authLib.GetPayloadFromRequest(r)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
+		return
+	}
+
+	usr, ok := getUserFromPath(w, r)
+	if !ok {
+		return
+	}
+	if !authLib.HasAllRoles(payload.Roles, usr.Roles) {
+		rest.WriteError(w, http.StatusForbidden, "Access denied: the user has roles that you don't have")
+		return
+	}
+
+	// Read the HTTP request body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusBadRequest, "There was an error reading the request")
+		return
+	}
+	var req SuspendUserRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusBadRequest, "There was an error json unmarshaling the request")
+		return
+	}
+	if req.Days < 1 || req.Days > report.MaxSuspensionDays {
+		rest.WriteErrorCode(w, http.StatusBadRequest, rest.CodeValidationFailed, fmt.Sprintf("There was an error validating the request: invalid Days: should be between 1 and %d", report.MaxSuspensionDays))
+		return
+	}
+
+	err = usr.Suspend(time.Now().AddDate(0, 0, req.Days))
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+	recordAuditEntry(audit.Entry{
+		Event:  audit.EventUserSuspended,
+		UserID: usr.ID,
+		IP:     rest.ClientIP(r),
+		Detail: fmt.Sprintf("user suspended until %s by user %d", usr.SuspendedUntil.UTC().Format(time.RFC3339), payload.UserID),
+	})
+
+	writeJSON(w, http.StatusOK, usr.AdminView())
+}
+
+// HandleUnsuspendUser lifts the suspension of a user. Like for the suspensions, the caller needs every role
+// of the user, so that a moderator can't undo what an admin did to another admin.
+// Example Request: curl -v -X "DELETE" localhost:8080/admin/users/{id}/suspension
+func HandleUnsuspendUser(w http.ResponseWriter, r *http.Request) {
+
+	// Get the payload from the request, which has the roles of the caller
+	payload, err := authLib.GetPayloadFromRequest(r)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
+		return
+	}
+
+	usr, ok := getUserFromPath(w, r)
+	if !ok {
+		return
+	}
+	if !authLib.HasAllRoles(payload.Roles, usr.Roles) {
+		rest.WriteError(w, http.StatusForbidden, "Access denied: the user has roles that you don't have")
+		return
+	}
+
+	err = usr.Unsuspend()
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+	recordAuditEntry(audit.Entry{
+		Event:  audit.EventSuspensionLifted,
+		UserID: usr.ID,
+		IP:     rest.ClientIP(r),
+		Detail: fmt.Sprintf("suspension lifted by user %d", payload.UserID),
+	})
+
+	writeJSON(w, http.StatusOK, usr.AdminView())
+}
+
+// HandleUpdateUserRoles replaces the roles of a user. It should only be accessible to the admins.
+// Example Request: curl -v -X "PUT" localhost:8080/admin/users/{id}/roles -d '{"Roles": ["moderator"]}'
+func HandleUpdateUserRoles(w http.ResponseWriter, r *http.Request) {
+	usr, ok := getUserFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	// Read the HTTP request body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusBadRequest, "There was an error reading the request")
+		return
+	}
+	var req UpdateRolesRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusBadRequest, "There was an error json unmarshaling the request")
+		return
+	}
+	if err := authLib.ValidateRoles(req.Roles); err != nil {
+		rest.WriteErrorCode(w, http.StatusBadRequest, rest.CodeValidationFailed, fmt.Sprintf("There was an error validating the request: %v", err))
+		return
+	}
+
+	err = usr.SetRoles(req.Roles)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, usr.AdminView())
+}
+
+// HandleGetStats provides an overview of the users and the reports
+// Example Request: curl -v localhost:8080/admin/stats
+func HandleGetStats(w http.ResponseWriter, r *http.Request) {
+	s, err := stats.GetStats()
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s)
+}
+
+// HandleGetAuditLog lists the security events in the audit log, newest first. They can be filtered by the
+// user they are about, or by the event.
+// Example Request: curl -v "localhost:8080/admin/audit?event=account_locked"
+func HandleGetAuditLog(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	var entries []audit.Entry
+	var err error
+	switch {
+	case q.Get("user_id") != "":
+		id, cErr := strconv.Atoi(q.Get("user_id"))
+		if cErr != nil {
+			rest.WriteErrorCode(w, http.StatusBadRequest, rest.CodeValidationFailed, "There was an error validating the request: the user_id query param should be a number")
+			return
+		}
+		entries, err = audit.GetEntriesByUserID(pk.ID(id))
+	case q.Get("event") != "":
+		entries, err = audit.GetEntriesByEvent(q.Get("event"))
+	default:
+		for _, event := range audit.Events {
+			var e []audit.Entry
+			e, err = audit.GetEntriesByEvent(event)
+			if err != nil {
+				break
+			}
+			entries = append(entries, e...)
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Datetime.After(entries[j].Datetime)
+		})
+	}
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+	if entries == nil {
+		entries = []audit.Entry{}
+	}
+
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// getUserFromPath fetches the user whose ID is in the path. If that fails, it writes the error response
+// and returns false.
+func getUserFromPath(w http.ResponseWriter, r *http.Request) (*user.User, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		rest.WriteError(w, http.StatusBadRequest, "The user ID in the path should be a number")
+		return nil, false
+	}
+
+	usr, err := user.GetUserByID(pk.ID(id))
+	if db.IsNotExist(err) {
+		rest.WriteError(w, http.StatusNotFound, "The user does not exist")
+		return nil, false
+	}
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return nil, false
+	}
+
+	return usr, true
+}
+
+// recordAuditEntry saves the entry in the audit log. The action has already been taken by then, so a failure
+// is only logged.
+func recordAuditEntry(e audit.Entry) {
+	if _, err := audit.Record(e); err != nil {
+		clog.Errorf("could not record the audit entry: %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/teejays/clog"
+
+	authLib "github.com/teejays/matchapi/lib/auth"
+	"github.com/teejays/matchapi/lib/pk"
+	"github.com/teejays/matchapi/lib/rest"
+	"github.com/teejays/matchapi/service/apikey/v1"
+)
+
+// HandleCreateAPIKey creates an API key for the internal tools. The key is only shown in the response.
+// Example Request: curl -v -X "POST" localhost:8080/admin/api-keys -d '{"Name": "Reports dashboard", "Scopes": ["admin/reports:read"]}'
+func HandleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+
+	// Get the userID from the request
+	userID, err := authLib.GetUserIdFromRequest(r)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
+		return
+	}
+
+	// Read the body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+	var req apikey.NewAPIKeyRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteErrorCode(w, http.StatusBadRequest, rest.CodeValidationFailed, fmt.Sprintf("There was an error validating the request: %v", err))
+		return
+	}
+	err = req.Validate()
+	if err != nil {
+		rest.WriteErrorCode(w, http.StatusBadRequest, rest.CodeValidationFailed, fmt.Sprintf("There was an error validating the request: %v", err))
+		return
+	}
+
+	resp, err := apikey.New(req, userID, time.Now())
+	if err == apikey.ErrInvalidUser {
+		rest.WriteErrorCode(w, http.StatusBadRequest, rest.CodeValidationFailed, fmt.Sprintf("There was an error validating the request: %v", err))
+		return
+	}
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, resp)
+
+	clog.Info("Request succesfully processed")
+}
+
+// HandleGetAPIKeys lists the API keys that haven't been revoked, without the keys themselves
+// Example Request: curl -v localhost:8080/admin/api-keys
+func HandleGetAPIKeys(w http.ResponseWriter, r *http.Request) {
+
+	keys, err := apikey.GetAPIKeys()
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, keys)
+}
+
+// HandleDeleteAPIKey revokes an API key
+// Example Request: curl -v -X "DELETE" localhost:8080/admin/api-keys/{id}
+func HandleDeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+
+	// Get the userID from the request
+	userID, err := authLib.GetUserIdFromRequest(r)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		rest.WriteError(w, http.StatusBadRequest, "The API key ID in the path should be a number")
+		return
+	}
+
+	err = apikey.Revoke(pk.ID(id), userID)
+	if err == apikey.ErrAPIKeyNotFound {
+		rest.WriteError(w, http.StatusNotFound, "The API key does not exist")
+		return
+	}
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	clog.Info("Request succesfully processed")
+}
@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/teejays/clog"
+
+	authLib "github.com/teejays/matchapi/lib/auth"
+	"github.com/teejays/matchapi/lib/pk"
+	"github.com/teejays/matchapi/lib/rest"
+	"github.com/teejays/matchapi/service/block/v1"
+)
+
+// HandlePostBlock ...
+// Example Request: curl -v -X "POST" localhost:8080/v1/block -d '{"BlockedID": 3}'
+func HandlePostBlock(w http.ResponseWriter, r *http.Request) {
+
+	// Get the userID from the request
+	userID, err := authLib.GetUserIdFromRequest(r)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
+		return
+	}
+
+	// Read the HTTP request body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusBadRequest, "There was an error reading the request")
+		return
+	}
+
+	// Json unmarshal the request into the BasicBlock struct
+	var bblock block.BasicBlock
+	err = json.Unmarshal(body, &bblock)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusBadRequest, "There was an error json unmarshaling the request")
+		return
+	}
+
+	// Validate the request
+	if err := bblock.Validate(); err != nil {
+		clog.Error(err.Error())
+		rest.WriteErrorCode(w, http.StatusBadRequest, rest.CodeValidationFailed, fmt.Sprintf("There was an error validating the request: %v", err))
+		return
+	}
+
+	// Save the block
+	blk, err := block.NewBlock(userID, bblock)
+	if err == block.ErrCannotBlockSelf {
+		rest.WriteErrorCode(w, http.StatusBadRequest, rest.CodeValidationFailed, fmt.Sprintf("There was an error validating the request: %v", err))
+		return
+	}
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	// Json marshal the block so we can send it back
+	resp, err := json.Marshal(blk)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	// Write the block to the http response
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(resp)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+	}
+
+	clog.Info("Request succesfully processed")
+
+}
+
+// HandleDeleteBlock ...
+// Example Request: curl -v -X "DELETE" localhost:8080/v1/block/3
+func HandleDeleteBlock(w http.ResponseWriter, r *http.Request) {
+
+	// Get the userID from the request
+	userID, err := authLib.GetUserIdFromRequest(r)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
+		return
+	}
+
+	// Get the ID of the blocked user from the path
+	blockedID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		rest.WriteError(w, http.StatusBadRequest, "The user ID in the path should be a number")
+		return
+	}
+
+	// Remove the block
+	err = block.DeleteBlock(userID, pk.ID(blockedID))
+	if err == block.ErrBlockDoesNotExist {
+		rest.WriteError(w, http.StatusNotFound, "The user is not blocked")
+		return
+	}
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	clog.Info("Request succesfully processed")
+
+}
+
+// HandleGetBlocks ...
+// Example Request: curl -v localhost:8080/v1/block
+func HandleGetBlocks(w http.ResponseWriter, r *http.Request) {
+
+	// Get the userID from the request
+	userID, err := authLib.GetUserIdFromRequest(r)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
+		return
+	}
+
+	// Get the users blocked by the user
+	blocked, err := block.GetBlockedUsersByBlockerID(userID)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	// Json marshal the response
+	resp, err := json.Marshal(blocked)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	// Write the HTTP response
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(resp)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	clog.Info("Request succesfully processed")
+
+}
@@ -0,0 +1,15 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/teejays/matchapi/lib/auth"
+)
+
+// HandleGetJWKS publishes the public keys that the tokens are signed with, so that other services can
+// verify the tokens without the private keys. Clients should refetch the keys when they see a new key ID.
+// Example Request: curl -v localhost:8080/.well-known/jwks.json
+func HandleGetJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, http.StatusOK, auth.DefaultKeyring.JWKS())
+}
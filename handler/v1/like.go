@@ -20,7 +20,7 @@ func HandleGetIncomingLikes(w http.ResponseWriter, r *http.Request) {
 	userID, err := auth.GetUserIdFromRequest(r)
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, "Could not authenticate the auth", http.StatusUnauthorized)
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the auth")
 		return
 	}
 
@@ -32,7 +32,7 @@ func HandleGetIncomingLikes(w http.ResponseWriter, r *http.Request) {
 	likesV2, err := likeV2.GetIncomingLikesByUserID(userID)
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, rest.CleanAPIErrMessage, http.StatusInternalServerError)
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
 		return
 	}
 
@@ -49,7 +49,7 @@ func HandleGetIncomingLikes(w http.ResponseWriter, r *http.Request) {
 	resp, err := json.Marshal(likes)
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, rest.CleanAPIErrMessage, http.StatusInternalServerError)
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
 		return
 	}
 
@@ -58,7 +58,7 @@ func HandleGetIncomingLikes(w http.ResponseWriter, r *http.Request) {
 	_, err = w.Write(resp)
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, rest.CleanAPIErrMessage, http.StatusInternalServerError)
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
 		return
 	}
 
@@ -4,13 +4,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/teejays/clog"
 
 	"github.com/teejays/matchapi/lib/rest"
 	"github.com/teejays/matchapi/service/auth/v1"
+	"github.com/teejays/matchapi/service/user/v1"
 )
 
 type LoginRequest struct {
@@ -26,7 +30,7 @@ func HandleLogin(w http.ResponseWriter, r *http.Request) {
 	data, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, "There was an error reading the request", http.StatusBadRequest)
+		rest.WriteError(w, http.StatusBadRequest, "There was an error reading the request")
 		return
 	}
 
@@ -35,48 +39,46 @@ func HandleLogin(w http.ResponseWriter, r *http.Request) {
 	err = json.Unmarshal(data, &creds)
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, "There was an error json unmarshalling the request", http.StatusBadRequest)
+		rest.WriteError(w, http.StatusBadRequest, "There was an error json unmarshalling the request")
 		return
 	}
 
 	// Validate the request
-	if strings.TrimSpace(creds.Email) == "" {
-		errMessage := fmt.Sprintf("There was an error validating the request: %s", "email cannot be empty")
-		clog.Error(errMessage)
-		http.Error(w, errMessage, http.StatusBadRequest)
-		return
-	}
-	if strings.TrimSpace(creds.Password) == "" {
-		errMessage := fmt.Sprintf("There was an error validating the request: %s", "password cannot be empty")
-		clog.Error(errMessage)
-		http.Error(w, errMessage, http.StatusBadRequest)
+	if err := creds.Validate(); err != nil {
+		clog.Error(err.Error())
+		rest.WriteValidationError(w, err)
 		return
 	}
 
 	// Find the user with these creds?
-	token, err := auth.Login(creds)
+	creds.IP = rest.ClientIP(r)
+	creds.UserAgent = r.UserAgent()
+	respJSON, err := auth.Login(creds)
+	if tErr, ok := err.(*auth.ThrottleError); ok {
+		writeThrottleError(w, tErr)
+		return
+	}
 	if err == auth.ErrInvalidEmail || err == auth.ErrInvalidPassword {
 		clog.Error(err.Error())
-		http.Error(w, "Invalid Credentials", http.StatusUnauthorized)
+		rest.WriteErrorCode(w, http.StatusUnauthorized, rest.CodeInvalidCredentials, "Invalid Credentials")
 		return
 	}
-	if err != nil {
+	if user.IsAccessError(err) {
 		clog.Error(err.Error())
-		http.Error(w, rest.CleanAPIErrMessage, http.StatusInternalServerError)
+		rest.WriteError(w, http.StatusForbidden, fmt.Sprintf("Access denied: %v", err))
 		return
 	}
-
-	type loginResponse struct {
-		Token string
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
 	}
-	var respJSON loginResponse
-	respJSON.Token = token
 
 	// Json marshal the response
 	resp, err := json.Marshal(respJSON)
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, rest.CleanAPIErrMessage, http.StatusInternalServerError)
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
 		return
 	}
 
@@ -86,7 +88,72 @@ func HandleLogin(w http.ResponseWriter, r *http.Request) {
 	_, err = w.Write(resp)
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, rest.CleanAPIErrMessage, http.StatusInternalServerError)
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+}
+
+// HandleLoginTwoFactor is the second step of the login for users with two-factor authentication. It
+// exchanges the challenge token from HandleLogin and a code for a JWT token.
+// Example Request: curl -v -X "POST" localhost:8080/v1/login/2fa -d '{"ChallengeToken": "<token>", "Code": "123456"}'
+func HandleLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	// Read the HTTP request body
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusBadRequest, "There was an error reading the request")
+		return
+	}
+
+	var req auth.TwoFactorLoginRequest
+	err = json.Unmarshal(data, &req)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusBadRequest, "There was an error json unmarshalling the request")
+		return
+	}
+
+	if strings.TrimSpace(req.Code) == "" {
+		errMessage := fmt.Sprintf("There was an error validating the request: %s", "code cannot be empty")
+		clog.Error(errMessage)
+		rest.WriteErrorCode(w, http.StatusBadRequest, rest.CodeValidationFailed, errMessage)
+		return
+	}
+
+	req.IP = rest.ClientIP(r)
+	req.UserAgent = r.UserAgent()
+	token, err := auth.LoginTwoFactor(req)
+	if tErr, ok := err.(*auth.ThrottleError); ok {
+		writeThrottleError(w, tErr)
+		return
+	}
+	if err == auth.ErrInvalidChallengeToken || err == auth.ErrInvalidTwoFactorCode {
+		clog.Error(err.Error())
+		rest.WriteErrorCode(w, http.StatusUnauthorized, rest.CodeInvalidCredentials, fmt.Sprintf("Invalid Credentials: %v", err))
+		return
+	}
+	if user.IsAccessError(err) {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusForbidden, fmt.Sprintf("Access denied: %v", err))
+		return
+	}
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
 		return
 	}
+
+	writeJSON(w, http.StatusOK, auth.LoginResponse{Token: token})
+
+	clog.Info("Request succesfully processed")
+}
+
+// writeThrottleError responds with a 429, and tells the client when it can try again
+func writeThrottleError(w http.ResponseWriter, err *auth.ThrottleError) {
+	clog.Error(err.Error())
+	retryAfter := math.Ceil(time.Until(err.RetryAt).Seconds())
+	w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter)))
+	rest.WriteError(w, http.StatusTooManyRequests, fmt.Sprintf("Too many requests: %v", err))
 }
@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/teejays/clog"
+
+	authLib "github.com/teejays/matchapi/lib/auth"
+	"github.com/teejays/matchapi/lib/pk"
+	"github.com/teejays/matchapi/lib/rest"
+	"github.com/teejays/matchapi/service/notification/v1"
+)
+
+// HandleGetNotifications provides the notifications of the user, most recent first. Passing `unread=true`
+// leaves out the notifications that have been read.
+// Example Request: curl -v "localhost:8080/v1/notifications?unread=true"
+func HandleGetNotifications(w http.ResponseWriter, r *http.Request) {
+
+	// Get the userID from the request
+	userID, err := authLib.GetUserIdFromRequest(r)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
+		return
+	}
+
+	unreadOnly := r.URL.Query().Get("unread") == "true"
+
+	inbox, err := notification.GetInbox(userID, unreadOnly)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, inbox)
+}
+
+// HandleMarkNotificationRead marks a notification as read
+// Example Request: curl -v -X "PUT" localhost:8080/v1/notifications/{id}/read
+func HandleMarkNotificationRead(w http.ResponseWriter, r *http.Request) {
+
+	// Get the userID from the request
+	userID, err := authLib.GetUserIdFromRequest(r)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		rest.WriteErrorCode(w, http.StatusBadRequest, rest.CodeValidationFailed, "There was an error validating the request: invalid notification id")
+		return
+	}
+
+	n, err := notification.MarkAsRead(userID, pk.ID(id))
+	if err == notification.ErrNotificationNotFound {
+		rest.WriteError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, n)
+}
+
+// HandleMarkAllNotificationsRead marks all the notifications of the user as read
+// Example Request: curl -v -X "PUT" localhost:8080/v1/notifications/read
+func HandleMarkAllNotificationsRead(w http.ResponseWriter, r *http.Request) {
+
+	// Get the userID from the request
+	userID, err := authLib.GetUserIdFromRequest(r)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
+		return
+	}
+
+	err = notification.MarkAllAsRead(userID)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	clog.Info("Request succesfully processed")
+}
+
+// HandleGetNotificationPreferences provides the notification preferences of the user
+// Example Request: curl -v localhost:8080/v1/notifications/preferences
+func HandleGetNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+
+	// Get the userID from the request
+	userID, err := authLib.GetUserIdFromRequest(r)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
+		return
+	}
+
+	prefs, err := notification.GetPreferences(userID)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, prefs)
+}
+
+// HandleUpdateNotificationPreferences replaces the notification preferences of the user
+// Example Request: curl -v -X "PUT" localhost:8080/v1/notifications/preferences -d '{"Likes": {"Inbox": true, "Email": false, "Push": true}, "Matches": {"Inbox": true, "Email": true, "Push": true}}'
+func HandleUpdateNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+
+	// Get the userID from the request
+	userID, err := authLib.GetUserIdFromRequest(r)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
+		return
+	}
+
+	// Read the HTTP request body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusBadRequest, "There was an error reading the request")
+		return
+	}
+
+	// Json unmarshal the request into the Preferences struct
+	var prefs notification.Preferences
+	err = json.Unmarshal(body, &prefs)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusBadRequest, "There was an error json unmarshaling the request")
+		return
+	}
+
+	prefs, err = notification.UpdatePreferences(userID, prefs)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, prefs)
+}
@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/lib/oauth"
+	"github.com/teejays/matchapi/lib/rest"
+	"github.com/teejays/matchapi/service/auth/v1"
+	"github.com/teejays/matchapi/service/user/v1"
+)
+
+// HandleGetOAuthProviders lists the identity providers that users can sign in with
+// Example Request: curl -v localhost:8080/v1/oauth
+func HandleGetOAuthProviders(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, oauth.Providers())
+}
+
+// HandleBeginOAuthLogin starts a sign in with an identity provider. The client should send the user to
+// the URL in the response, and keep the state to check it against the one that the provider sends back.
+// Example Request: curl -v -X "POST" localhost:8080/v1/oauth/{provider}/authorize
+func HandleBeginOAuthLogin(w http.ResponseWriter, r *http.Request) {
+
+	start, err := auth.BeginOAuthLogin(mux.Vars(r)["provider"], rest.ClientIP(r))
+	if err == oauth.ErrUnknownProvider {
+		rest.WriteError(w, http.StatusNotFound, "The identity provider is not supported")
+		return
+	}
+	if err == auth.ErrTooManyOAuthLogins {
+		clog.Error(err.Error())
+		w.Header().Set("Retry-After", strconv.Itoa(int(auth.OAuthStateTTL.Seconds())))
+		rest.WriteError(w, http.StatusTooManyRequests, "Too many sign ins have been started; please try again later")
+		return
+	}
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, start)
+
+	clog.Info("Request succesfully processed")
+}
+
+// HandleOAuthLogin finishes a sign in with an identity provider. It responds like HandleLogin.
+// Example Request: curl -v -X "POST" localhost:8080/v1/oauth/{provider}/login -d '{"State": "<state>", "Code": "<code>"}'
+func HandleOAuthLogin(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	// Read the HTTP request body
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusBadRequest, "There was an error reading the request")
+		return
+	}
+
+	var req auth.OAuthLoginRequest
+	err = json.Unmarshal(data, &req)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusBadRequest, "There was an error json unmarshalling the request")
+		return
+	}
+
+	if strings.TrimSpace(req.State) == "" || strings.TrimSpace(req.Code) == "" {
+		errMessage := fmt.Sprintf("There was an error validating the request: %s", "state and code cannot be empty")
+		clog.Error(errMessage)
+		rest.WriteErrorCode(w, http.StatusBadRequest, rest.CodeValidationFailed, errMessage)
+		return
+	}
+
+	req.IP = rest.ClientIP(r)
+	req.UserAgent = r.UserAgent()
+	resp, err := auth.LoginOAuth(mux.Vars(r)["provider"], req)
+	if err == oauth.ErrUnknownProvider {
+		rest.WriteError(w, http.StatusNotFound, "The identity provider is not supported")
+		return
+	}
+	if err == auth.ErrInvalidOAuthState || err == auth.ErrOAuthFailed || err == auth.ErrOAuthAccountNotFound || err == auth.ErrOAuthEmailNotVerified {
+		clog.Error(err.Error())
+		rest.WriteErrorCode(w, http.StatusUnauthorized, rest.CodeInvalidCredentials, fmt.Sprintf("Invalid Credentials: %v", err))
+		return
+	}
+	if user.IsAccessError(err) {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusForbidden, fmt.Sprintf("Access denied: %v", err))
+		return
+	}
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+
+	clog.Info("Request succesfully processed")
+}
@@ -0,0 +1,255 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/teejays/clog"
+
+	authLib "github.com/teejays/matchapi/lib/auth"
+	"github.com/teejays/matchapi/lib/blob"
+	"github.com/teejays/matchapi/lib/pk"
+	"github.com/teejays/matchapi/lib/rest"
+	"github.com/teejays/matchapi/service/photo/v1"
+	"github.com/teejays/matchapi/service/user/v1"
+)
+
+// ProfileImage represents an image of the user along with the signed URLs that can be used to fetch it
+type ProfileImage struct {
+	Key          string
+	URL          string
+	ThumbnailURL string
+}
+
+// ReorderImagesRequest is the request used to change the order of the profile images
+type ReorderImagesRequest struct {
+	Keys []string
+}
+
+// HandleGetUserImages ...
+// Example Request: curl -v localhost:8080/v1/user/images
+func HandleGetUserImages(w http.ResponseWriter, r *http.Request) {
+
+	// Get the userID from the request
+	userID, err := authLib.GetUserIdFromRequest(r)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
+		return
+	}
+
+	writeUserImages(w, userID, http.StatusOK)
+}
+
+// HandlePostUserImage ...
+// Example Request: curl -v -X "POST" localhost:8080/v1/user/images -F "image=@photo.jpg"
+func HandlePostUserImage(w http.ResponseWriter, r *http.Request) {
+
+	// Get the userID from the request
+	userID, err := authLib.GetUserIdFromRequest(r)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
+		return
+	}
+
+	// Limit the size of the request body, leaving some room for the multipart headers
+	r.Body = http.MaxBytesReader(w, r.Body, photo.MaxImageSize+(1<<20))
+
+	// Read the image from the multipart form
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusBadRequest, "There was an error reading the image from the request; the image should be sent in the 'image' field of a multipart form, and should be smaller than the size limit")
+		return
+	}
+	defer file.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(file, photo.MaxImageSize+1))
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusBadRequest, "There was an error reading the image from the request")
+		return
+	}
+
+	// Upload the image
+	_, err = photo.Upload(userID, data)
+	if err == photo.ErrImageTooLarge {
+		rest.WriteError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("There was an error validating the image: %v", err))
+		return
+	}
+	if err == photo.ErrUnsupportedContentType {
+		rest.WriteError(w, http.StatusUnsupportedMediaType, fmt.Sprintf("There was an error validating the image: %v", err))
+		return
+	}
+	if err == photo.ErrTooManyImages {
+		rest.WriteError(w, http.StatusBadRequest, fmt.Sprintf("There was an error validating the image: %v", err))
+		return
+	}
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	writeUserImages(w, userID, http.StatusCreated)
+}
+
+// HandleReorderUserImages ...
+// Example Request: curl -v -X "PUT" localhost:8080/v1/user/images -d '{"Keys": ["b21c...", "a9f0..."]}'
+func HandleReorderUserImages(w http.ResponseWriter, r *http.Request) {
+
+	// Get the userID from the request
+	userID, err := authLib.GetUserIdFromRequest(r)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
+		return
+	}
+
+	// Read the HTTP request body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusBadRequest, "There was an error reading the request")
+		return
+	}
+
+	// Json unmarshal the request
+	var req ReorderImagesRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusBadRequest, "There was an error json unmarshaling the request")
+		return
+	}
+
+	// Reorder the images
+	err = photo.Reorder(userID, req.Keys)
+	if err == photo.ErrInvalidOrder {
+		rest.WriteErrorCode(w, http.StatusBadRequest, rest.CodeValidationFailed, fmt.Sprintf("There was an error validating the request: %v", err))
+		return
+	}
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	writeUserImages(w, userID, http.StatusOK)
+}
+
+// HandleDeleteUserImage ...
+// Example Request: curl -v -X "DELETE" localhost:8080/v1/user/images/{key}
+func HandleDeleteUserImage(w http.ResponseWriter, r *http.Request) {
+
+	// Get the userID from the request
+	userID, err := authLib.GetUserIdFromRequest(r)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
+		return
+	}
+
+	// Delete the image
+	err = photo.Delete(userID, mux.Vars(r)["key"])
+	if err == user.ErrEntityDoesNotExist {
+		rest.WriteError(w, http.StatusNotFound, "The image does not exist")
+		return
+	}
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	writeUserImages(w, userID, http.StatusOK)
+}
+
+// HandleGetImage serves an image using the signed URL generated for it. It does not require authentication
+// since the signature, which expires, proves that the URL was given out by us.
+// Example Request: curl -v "localhost:8080/v1/image/{key}?expires=...&signature=..."
+func HandleGetImage(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+
+	// Verify the signature of the URL
+	err := blob.VerifySignedURL(key, r.URL.Query())
+	if err != nil {
+		clog.Warnf("invalid signed image url for key %s: %v", key, err)
+		rest.WriteError(w, http.StatusForbidden, "The image URL is invalid or has expired")
+		return
+	}
+
+	// Get the image
+	rc, err := photo.Open(key)
+	if err == blob.ErrNotExist || err == blob.ErrInvalidKey {
+		rest.WriteError(w, http.StatusNotFound, "The image does not exist")
+		return
+	}
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+	defer rc.Close()
+
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	// Write the HTTP response; the images never change for a key so they can be cached until the URL expires
+	w.Header().Set("Content-Type", http.DetectContentType(data))
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	_, err = w.Write(data)
+	if err != nil {
+		clog.Error(err.Error())
+		return
+	}
+
+	clog.Info("Request succesfully processed")
+}
+
+// writeUserImages writes the current images of the user, with their signed URLs, to the http response
+func writeUserImages(w http.ResponseWriter, userID pk.ID, status int) {
+	usr, err := user.GetUserByID(userID)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	var images = []ProfileImage{}
+	for _, key := range usr.Images {
+		images = append(images, ProfileImage{
+			Key:          key,
+			URL:          blob.SignedURL(key),
+			ThumbnailURL: blob.SignedURL(user.ThumbnailKey(key)),
+		})
+	}
+
+	// Json marshal the response
+	resp, err := json.Marshal(images)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	// Write the HTTP response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err = w.Write(resp)
+	if err != nil {
+		clog.Error(err.Error())
+		return
+	}
+
+	clog.Info("Request succesfully processed")
+}
@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/teejays/clog"
+
+	authLib "github.com/teejays/matchapi/lib/auth"
+	"github.com/teejays/matchapi/lib/rest"
+	"github.com/teejays/matchapi/service/report/v1"
+)
+
+// HandlePostReport ...
+// Example Request: curl -v -X "POST" localhost:8080/v1/report -d '{"ReportedID": 3, "Reason": "spam", "Details": "Keeps sending links"}'
+func HandlePostReport(w http.ResponseWriter, r *http.Request) {
+
+	// Get the userID from the request
+	userID, err := authLib.GetUserIdFromRequest(r)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
+		return
+	}
+
+	// Read the HTTP request body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusBadRequest, "There was an error reading the request")
+		return
+	}
+
+	// Json unmarshal the request into the BasicReport struct
+	var breport report.BasicReport
+	err = json.Unmarshal(body, &breport)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusBadRequest, "There was an error json unmarshaling the request")
+		return
+	}
+
+	// Validate the request
+	if err := breport.Validate(); err != nil {
+		clog.Error(err.Error())
+		rest.WriteErrorCode(w, http.StatusBadRequest, rest.CodeValidationFailed, fmt.Sprintf("There was an error validating the request: %v", err))
+		return
+	}
+
+	// Save the report
+	rpt, err := report.NewReport(userID, breport)
+	if err == report.ErrCannotReportSelf {
+		rest.WriteErrorCode(w, http.StatusBadRequest, rest.CodeValidationFailed, fmt.Sprintf("There was an error validating the request: %v", err))
+		return
+	}
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, rpt)
+}
+
+// writeJSON json marshals the data and writes it to the http response with the status code
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+
+	// Json marshal the response
+	resp, err := json.Marshal(data)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	// Write the HTTP response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err = w.Write(resp)
+	if err != nil {
+		clog.Error(err.Error())
+		return
+	}
+
+	clog.Info("Request succesfully processed")
+}
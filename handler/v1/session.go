@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/teejays/clog"
+
+	authLib "github.com/teejays/matchapi/lib/auth"
+	"github.com/teejays/matchapi/lib/pk"
+	"github.com/teejays/matchapi/lib/rest"
+	"github.com/teejays/matchapi/service/session/v1"
+)
+
+// HandleGetSessions lists the devices that the user is logged in on, the most recently used first
+// Example Request: curl -v localhost:8080/v1/sessions
+func HandleGetSessions(w http.ResponseWriter, r *http.Request) {
+
+	// Get the payload from the request, which has the current session
+	payload, err := authLib.GetPayloadFromRequest(r)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
+		return
+	}
+
+	sessions, err := session.GetSessionsByUserID(payload.UserID, payload.SessionID)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, sessions)
+
+	clog.Info("Request succesfully processed")
+}
+
+// HandleDeleteSession logs the user out of one of their sessions. Revoking the current session logs out.
+// Example Request: curl -v -X "DELETE" localhost:8080/v1/sessions/{id}
+func HandleDeleteSession(w http.ResponseWriter, r *http.Request) {
+
+	// Get the userID from the request
+	userID, err := authLib.GetUserIdFromRequest(r)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
+		return
+	}
+
+	// Get the ID of the session from the path
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		rest.WriteError(w, http.StatusBadRequest, "The session ID in the path should be a number")
+		return
+	}
+
+	err = session.Revoke(userID, pk.ID(id))
+	if err == session.ErrSessionNotFound {
+		rest.WriteError(w, http.StatusNotFound, "The session does not exist")
+		return
+	}
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	clog.Info("Request succesfully processed")
+}
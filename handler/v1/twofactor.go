@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/teejays/clog"
+
+	authLib "github.com/teejays/matchapi/lib/auth"
+	"github.com/teejays/matchapi/lib/pk"
+	"github.com/teejays/matchapi/lib/rest"
+	"github.com/teejays/matchapi/service/auth/v1"
+	"github.com/teejays/matchapi/service/user/v1"
+)
+
+// TwoFactorCodeRequest is the body of the requests that need a two-factor code
+type TwoFactorCodeRequest struct {
+	Code string
+}
+
+// HandleBeginTwoFactorEnrollment generates a new two-factor secret for the authenticated user. The response
+// has the otpauth URI for the authenticator app and the recovery codes, which are only shown once.
+// Example Request: curl -v -X "POST" localhost:8080/v1/user/2fa
+func HandleBeginTwoFactorEnrollment(w http.ResponseWriter, r *http.Request) {
+
+	// Get the userID from the request
+	userID, err := authLib.GetUserIdFromRequest(r)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
+		return
+	}
+
+	usr, err := user.GetUserByID(userID)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	enrollment, err := auth.BeginTwoFactorEnrollment(usr)
+	if err == auth.ErrTwoFactorAlreadyEnabled {
+		clog.Error(err.Error())
+		rest.WriteErrorCode(w, http.StatusBadRequest, rest.CodeValidationFailed, fmt.Sprintf("There was an error validating the request: %v", err))
+		return
+	}
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, enrollment)
+
+	clog.Info("Request succesfully processed")
+}
+
+// HandleConfirmTwoFactorEnrollment enables two-factor authentication once the user has entered a code from
+// their authenticator app
+// Example Request: curl -v -X "POST" localhost:8080/v1/user/2fa/confirm -d '{"Code": "123456"}'
+func HandleConfirmTwoFactorEnrollment(w http.ResponseWriter, r *http.Request) {
+	handleTwoFactorCode(w, r, auth.ConfirmTwoFactorEnrollment)
+}
+
+// HandleDisableTwoFactor disables two-factor authentication. It needs a code from the authenticator app or a
+// recovery code.
+// Example Request: curl -v -X "DELETE" localhost:8080/v1/user/2fa -d '{"Code": "123456"}'
+func HandleDisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	handleTwoFactorCode(w, r, auth.DisableTwoFactor)
+}
+
+// handleTwoFactorCode reads the code from the request and passes it to fn, along with the ID of the
+// authenticated user
+func handleTwoFactorCode(w http.ResponseWriter, r *http.Request, fn func(userID pk.ID, code string) error) {
+
+	// Get the userID from the request
+	userID, err := authLib.GetUserIdFromRequest(r)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
+		return
+	}
+
+	// Read the HTTP request body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusBadRequest, "There was an error reading the request")
+		return
+	}
+	var req TwoFactorCodeRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusBadRequest, "There was an error json unmarshaling the request")
+		return
+	}
+
+	err = fn(userID, req.Code)
+	if err == auth.ErrInvalidTwoFactorCode || err == auth.ErrTwoFactorAlreadyEnabled ||
+		err == auth.ErrTwoFactorNotEnabled || err == auth.ErrTwoFactorNotEnrolled {
+		clog.Error(err.Error())
+		rest.WriteErrorCode(w, http.StatusBadRequest, rest.CodeValidationFailed, fmt.Sprintf("There was an error validating the request: %v", err))
+		return
+	}
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	clog.Info("Request succesfully processed")
+}
@@ -2,16 +2,21 @@ package handler
 
 import (
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
 
+	"github.com/gorilla/mux"
 	"github.com/teejays/clog"
 
+	"github.com/teejays/matchapi/db"
 	authLib "github.com/teejays/matchapi/lib/auth"
+	"github.com/teejays/matchapi/lib/pk"
 	"github.com/teejays/matchapi/lib/rest"
+	"github.com/teejays/matchapi/lib/validate"
 	"github.com/teejays/matchapi/service/auth/v1"
+	"github.com/teejays/matchapi/service/block/v1"
 	"github.com/teejays/matchapi/service/user/v1"
 )
 
@@ -23,7 +28,7 @@ func HandleGetUser(w http.ResponseWriter, r *http.Request) {
 	userID, err := authLib.GetUserIdFromRequest(r)
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, "Could not authenticate the user", http.StatusUnauthorized)
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
 		return
 	}
 
@@ -33,17 +38,17 @@ func HandleGetUser(w http.ResponseWriter, r *http.Request) {
 	usr, err := user.GetUserByID(userID)
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, rest.CleanAPIErrMessage, http.StatusInternalServerError)
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
 		return
 	}
 
 	clog.Debugf("UserID fetched: %v", usr)
 
 	// Json marshal the response
-	resp, err := json.Marshal(usr.Profile)
+	resp, err := json.Marshal(usr.Profile.WithImageURLs())
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, rest.CleanAPIErrMessage, http.StatusInternalServerError)
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
 		return
 	}
 
@@ -52,7 +57,78 @@ func HandleGetUser(w http.ResponseWriter, r *http.Request) {
 	_, err = w.Write(resp)
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, rest.CleanAPIErrMessage, http.StatusInternalServerError)
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	clog.Info("Request succesfully processed")
+
+}
+
+// HandleGetUserByID returns the shareable profile of another user. Users that have blocked each other, in
+// either direction, cannot see each other's profiles; they get the same response as for a user that doesn't exist.
+// Example Request: curl -v localhost:8080/v1/user/3
+func HandleGetUserByID(w http.ResponseWriter, r *http.Request) {
+
+	// Get the userID from the request
+	userID, err := authLib.GetUserIdFromRequest(r)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
+		return
+	}
+
+	// Get the ID of the requested user from the path
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		rest.WriteError(w, http.StatusBadRequest, "The user ID in the path should be a number")
+		return
+	}
+
+	// Check that the users haven't blocked each other
+	blocked, err := block.IsBlocked(userID, pk.ID(id))
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	// Get the requested user
+	usr, err := user.GetUserByID(pk.ID(id))
+	if blocked || db.IsNotExist(err) || (err == nil && usr.IsDeleted) {
+		rest.WriteError(w, http.StatusNotFound, "The user does not exist")
+		return
+	}
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	// The viewer is needed to calculate the distance
+	viewer, err := user.GetUserByID(userID)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	usr.RecordProfileView(viewer)
+
+	// Json marshal the response
+	resp, err := json.Marshal(usr.ShareWith(viewer))
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	// Write the HTTP response
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(resp)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
 		return
 	}
 
@@ -67,15 +143,24 @@ type CreateUserRequest struct {
 	Password string
 }
 
+// Validate returns all the fields of the request that are not valid
+func (req CreateUserRequest) Validate() error {
+	var v validate.Validator
+	v.Merge("", req.Profile.Validate())
+	v.Check(!req.Birthdate.IsZero(), "Birthdate", validate.CodeRequired, "birthdate is required")
+	v.Merge("", IsValidPassword(req.Password))
+	return v.Err()
+}
+
 // HandleCreateUser ...
-// Example Request: curl -X "POST" localhost:8080/v1/user -d '{"FirstName":"Tom","LastName":"Harry", "Email": "tom.harry@email.com", "Gender": 3}'
+// Example Request: curl -X "POST" localhost:8080/v1/user -d '{"FirstName":"Tom","LastName":"Harry", "Email": "tom.harry@email.com", "Gender": 3, "Birthdate": "1990-01-01T00:00:00Z"}'
 func HandleCreateUser(w http.ResponseWriter, r *http.Request) {
 
 	// Read the HTTP request body
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, "There was an error reading the request", http.StatusBadRequest)
+		rest.WriteError(w, http.StatusBadRequest, "There was an error reading the request")
 		return
 	}
 
@@ -84,30 +169,22 @@ func HandleCreateUser(w http.ResponseWriter, r *http.Request) {
 	err = json.Unmarshal(body, &req)
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, "There was an error json unmarshaling the request", http.StatusBadRequest)
+		rest.WriteError(w, http.StatusBadRequest, "There was an error json unmarshaling the request")
 		return
 	}
 
-	// Validate that the profile is has all required info
-	err = req.Profile.Validate()
+	// Validate that the profile is has all required info, and that the password is good enough
+	err = req.Validate()
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, fmt.Sprintf("There was an error validating the request: %v", err), http.StatusBadRequest)
-		return
-	}
-
-	// Get the password hash after validating it
-	err = IsValidPassword(req.Password)
-	if err != nil {
-		clog.Error(err.Error())
-		http.Error(w, fmt.Sprintf("The password is invalid: %v", err), http.StatusBadRequest)
+		rest.WriteValidationError(w, err)
 		return
 	}
 
 	passwordHash, err := authLib.GetHash(req.Password, auth.PasswordSecretKey)
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, rest.CleanAPIErrMessage, http.StatusInternalServerError)
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
 		return
 	}
 
@@ -118,22 +195,30 @@ func HandleCreateUser(w http.ResponseWriter, r *http.Request) {
 
 	// Update the profile of the given user
 	usr, err := user.NewUser(newUserReq)
-	if err == user.ErrEmailAlreadyExist {
+	if err == user.ErrEmailAlreadyExist || err == user.ErrBirthdateRequired {
 		clog.Error(err.Error())
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		rest.WriteError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, rest.CleanAPIErrMessage, http.StatusInternalServerError)
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
 		return
 	}
 
+	// Send the email to verify the account. The account is created even if it can't be sent, since the
+	// user can ask for it again.
+	if created, err := user.GetUserByID(usr.ID); err != nil {
+		clog.Error(err.Error())
+	} else if err := sendVerificationEmail(created); err != nil {
+		clog.Errorf("could not send the verification email to user %d: %v", usr.ID, err)
+	}
+
 	// Json marshal the updated profile so we can send it back
 	resp, err := json.Marshal(usr)
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, rest.CleanAPIErrMessage, http.StatusInternalServerError)
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
 		return
 	}
 
@@ -142,7 +227,7 @@ func HandleCreateUser(w http.ResponseWriter, r *http.Request) {
 	_, err = w.Write(resp)
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, rest.CleanAPIErrMessage, http.StatusInternalServerError)
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
 	}
 
 	clog.Info("Request succesfully processed")
@@ -157,7 +242,7 @@ func HandleUpdateUserProfile(w http.ResponseWriter, r *http.Request) {
 	userID, err := authLib.GetUserIdFromRequest(r)
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, "Could not authenticate the user", http.StatusUnauthorized)
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
 		return
 	}
 
@@ -167,7 +252,7 @@ func HandleUpdateUserProfile(w http.ResponseWriter, r *http.Request) {
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, "There was an error reading the request", http.StatusBadRequest)
+		rest.WriteError(w, http.StatusBadRequest, "There was an error reading the request")
 		return
 	}
 
@@ -176,7 +261,7 @@ func HandleUpdateUserProfile(w http.ResponseWriter, r *http.Request) {
 	err = json.Unmarshal(body, &profile)
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, "There was an error json unmarshaling the request", http.StatusBadRequest)
+		rest.WriteError(w, http.StatusBadRequest, "There was an error json unmarshaling the request")
 		return
 	}
 
@@ -184,31 +269,45 @@ func HandleUpdateUserProfile(w http.ResponseWriter, r *http.Request) {
 	err = profile.Validate()
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, fmt.Sprintf("There was an error validating the request: %v", err), http.StatusBadRequest)
+		rest.WriteValidationError(w, err)
 		return
 	}
 
 	// Get the user object
-	user, err := user.GetUserByID(userID)
+	usr, err := user.GetUserByID(userID)
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, rest.CleanAPIErrMessage, http.StatusInternalServerError)
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
 		return
 	}
 
 	// Update the user object
-	err = user.UpdateProfile(profile)
+	oldEmail := usr.Email
+	err = usr.UpdateProfile(profile)
+	if err == user.ErrEmailAlreadyExist {
+		clog.Error(err.Error())
+		rest.WriteValidationError(w, validate.Errors{{Field: "Email", Code: validate.CodeDuplicate, Message: err.Error()}})
+		return
+	}
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, rest.CleanAPIErrMessage, http.StatusInternalServerError)
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
 		return
 	}
 
-	// Json marshal the updated profile so we can send it back
-	resp, err := json.Marshal(user)
+	// A new email needs to be verified again
+	if !strings.EqualFold(oldEmail, usr.Email) {
+		if err := sendVerificationEmail(usr); err != nil {
+			clog.Errorf("could not send the verification email to user %d: %v", usr.ID, err)
+		}
+	}
+
+	// Json marshal the updated profile so we can send it back, like HandleGetUser does, without the
+	// credentials and the moderation data of the user
+	resp, err := json.Marshal(usr.Profile.WithImageURLs())
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, rest.CleanAPIErrMessage, http.StatusInternalServerError)
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
 		return
 	}
 
@@ -217,26 +316,95 @@ func HandleUpdateUserProfile(w http.ResponseWriter, r *http.Request) {
 	_, err = w.Write(resp)
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, rest.CleanAPIErrMessage, http.StatusInternalServerError)
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
 	}
 
 	clog.Info("Request succesfully processed")
 
 }
 
-// IsValidPassword validates that the password is good enough to be used
-func IsValidPassword(password string) error {
+// HandleUpdateUserPreferences ...
+// Example Request: curl -v -X "PUT" localhost:8080/v1/user/preferences -d '{"Genders":[2], "MinAge": 25, "MaxAge": 35, "MaxDistance": 50, "Dealbreakers": ["age"]}'
+func HandleUpdateUserPreferences(w http.ResponseWriter, r *http.Request) {
 
-	// password is not empty?
-	if strings.TrimSpace(password) == "" {
-		return fmt.Errorf("no password provided")
+	// Get the userID from the request
+	userID, err := authLib.GetUserIdFromRequest(r)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
+		return
 	}
 
-	// password is not too short
+	// Read the HTTP request body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusBadRequest, "There was an error reading the request")
+		return
+	}
+
+	// Json unmarshal the request into the user.Preferences
+	var preferences user.Preferences
+	err = json.Unmarshal(body, &preferences)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusBadRequest, "There was an error json unmarshaling the request")
+		return
+	}
+
+	// Validate that the preferences make sense
+	err = preferences.Validate()
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteValidationError(w, err)
+		return
+	}
+
+	// Get the user object
+	usr, err := user.GetUserByID(userID)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	// Update the user object
+	err = usr.UpdatePreferences(preferences)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	// Json marshal the updated preferences so we can send it back
+	resp, err := json.Marshal(usr.Preferences)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	// Write the updated preferences to the http response
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(resp)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+	}
+
+	clog.Info("Request succesfully processed")
+
+}
+
+// IsValidPassword validates that the password is good enough to be used
+func IsValidPassword(password string) error {
+	var v validate.Validator
+
+	// password is not empty, and not too short
 	minLength := 6
-	if len(password) < minLength {
-		return fmt.Errorf("password is too short, needs a minimum of %d characters", minLength)
+	if v.Required("Password", password, "no password provided") {
+		v.Check(len(password) >= minLength, "Password", validate.CodeTooShort, "password is too short, needs a minimum of %d characters", minLength)
 	}
 
-	return nil
+	return v.Err()
 }
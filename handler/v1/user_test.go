@@ -8,12 +8,15 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 	"github.com/teejays/clog"
 
 	"github.com/teejays/matchapi/db"
+	authLib "github.com/teejays/matchapi/lib/auth"
+	"github.com/teejays/matchapi/lib/rest"
 	"github.com/teejays/matchapi/service/user/v1"
 )
 
@@ -131,8 +134,18 @@ func TestHandleCreatUser(t *testing.T) {
 			expectedCode: http.StatusBadRequest,
 		},
 		{
-			name:         "passing a valid user data should create a user and return it",
+			name:         "passing a user data without a birthdate should return an error",
 			body:         strings.NewReader(`{"FirstName":"Jon","LastName":"Harry", "Email": "jon.harry@email.com", "Gender": 3}`),
+			expectedCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "passing a user under the minimum age should return an error",
+			body:         strings.NewReader(`{"FirstName":"Jon","LastName":"Harry", "Email": "jon.harry@email.com", "Gender": 3, "Birthdate": "` + time.Now().AddDate(-10, 0, 0).Format(time.RFC3339) + `"}`),
+			expectedCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "passing a valid user data should create a user and return it",
+			body:         strings.NewReader(`{"FirstName":"Jon","LastName":"Harry", "Email": "jon.harry@email.com", "Gender": 3, "Birthdate": "1990-01-01T00:00:00Z", "Password": "secret123"}`),
 			expectedCode: http.StatusOK,
 		},
 	}
@@ -173,3 +186,65 @@ func TestHandleCreatUser(t *testing.T) {
 	}
 
 }
+
+func TestHandleUpdateUserProfile(t *testing.T) {
+
+	// Initialize the mock DB client
+	err := db.InitMockClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.DestoryMockClient()
+
+	// Populate the User mock data in DB, with the private data that shouldn't be sent back
+	err = user.HelperPopulateMockData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := user.GetUserByID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.PasswordHash = []byte("hash")
+	u.Roles = []string{authLib.RoleModerator}
+	u.Images = []string{"0a1b2c3d"}
+	err = db.SaveEntityByID(db.UserCollection, u.ID, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := authLib.NewPayload(u.ID, u.Email, u.Roles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := authLib.NewToken(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPut, "/v1/user", strings.NewReader(`{"FirstName":"Johnny","LastName":"Doe", "Email": "john.doe@email.com", "Gender": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	var w = httptest.NewRecorder()
+
+	rest.AuthenticateMiddleware(http.HandlerFunc(HandleUpdateUserProfile)).ServeHTTP(w, req)
+	if !assert.Equal(t, http.StatusOK, w.Code, w.Body.String()) {
+		return
+	}
+
+	// Only the profile is sent back, with signed URLs for the images
+	var resp map[string]interface{}
+	err = json.Unmarshal(w.Body.Bytes(), &resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "Johnny", resp["FirstName"])
+	for _, field := range []string{"ID", "PasswordHash", "Roles", "VerificationNonce", "Warnings", "ModerationStatus", "ModerationFlags"} {
+		assert.NotContains(t, resp, field)
+	}
+	if images, ok := resp["Images"].([]interface{}); assert.True(t, ok) && assert.Len(t, images, 1) {
+		assert.NotEqual(t, "0a1b2c3d", images[0])
+		assert.Contains(t, images[0], "0a1b2c3d")
+	}
+}
@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/teejays/clog"
+
+	authLib "github.com/teejays/matchapi/lib/auth"
+	"github.com/teejays/matchapi/lib/rest"
+	"github.com/teejays/matchapi/service/mail/v1"
+	"github.com/teejays/matchapi/service/user/v1"
+)
+
+// VerifyEmailRequest is the body of the request to verify an email
+type VerifyEmailRequest struct {
+	Token string
+}
+
+// HandleVerifyEmail verifies the email of a user using the token that was sent to them. It doesn't need
+// authentication, since the link could be opened on another device.
+// Example Request: curl -v -X "POST" localhost:8080/v1/user/verify -d '{"Token": "<token>"}'
+func HandleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+
+	// Read the HTTP request body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusBadRequest, "There was an error reading the request")
+		return
+	}
+	var req VerifyEmailRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusBadRequest, "There was an error json unmarshaling the request")
+		return
+	}
+
+	_, err = user.VerifyEmail(req.Token)
+	if err == user.ErrInvalidVerificationToken || err == user.ErrVerificationTokenExpired {
+		clog.Error(err.Error())
+		rest.WriteErrorCode(w, http.StatusBadRequest, rest.CodeValidationFailed, fmt.Sprintf("There was an error validating the request: %v", err))
+		return
+	}
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	clog.Info("Request succesfully processed")
+}
+
+// HandleResendVerificationEmail sends a new verification email to the user. The previous links stop working.
+// Users have to wait a few minutes between the emails.
+// Example Request: curl -v -X "POST" localhost:8080/v1/user/verify/resend
+func HandleResendVerificationEmail(w http.ResponseWriter, r *http.Request) {
+
+	// Get the userID from the request
+	userID, err := authLib.GetUserIdFromRequest(r)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
+		return
+	}
+
+	usr, token, err := user.ResendVerificationToken(userID)
+	if err == user.ErrEmailAlreadyVerified {
+		rest.WriteErrorCode(w, http.StatusBadRequest, rest.CodeValidationFailed, fmt.Sprintf("There was an error validating the request: %v", err))
+		return
+	}
+	if err == user.ErrVerificationRecentlySent {
+		w.Header().Set("Retry-After", strconv.Itoa(int(user.VerificationResendInterval.Seconds())))
+		rest.WriteError(w, http.StatusTooManyRequests, "A verification email has been sent recently; please check your inbox or try again later")
+		return
+	}
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	err = queueVerificationEmail(usr, token)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+
+	clog.Info("Request succesfully processed")
+}
+
+// sendVerificationEmail issues a new verification token for the user and queues the email with the link
+func sendVerificationEmail(u *user.User) error {
+	token, err := u.NewVerificationToken()
+	if err != nil {
+		return err
+	}
+	return queueVerificationEmail(u, token)
+}
+
+// queueVerificationEmail queues the email with the link to verify the user's email with the token
+func queueVerificationEmail(u *user.User, token string) error {
+	_, err := mail.Enqueue(u.Email, mail.TemplateVerification, mail.LinkData{Name: u.FirstName, Link: user.VerificationLink(token)})
+	return err
+}
@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/lib/auth"
+	"github.com/teejays/matchapi/lib/rest"
+	"github.com/teejays/matchapi/service/discover/v1"
+	"github.com/teejays/matchapi/service/user/v1"
+)
+
+// HandleGetDiscover ...
+// Example Request: curl -v "localhost:8080/v2/discover?page=1&page_size=20"
+func HandleGetDiscover(w http.ResponseWriter, r *http.Request) {
+
+	// Get the userID from the request
+	userID, err := auth.GetUserIdFromRequest(r)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
+		return
+	}
+
+	// Build the discover request from the query params
+	req, err := getDiscoverRequest(r)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteErrorCode(w, http.StatusBadRequest, rest.CodeValidationFailed, fmt.Sprintf("There was an error validating the request: %v", err))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		clog.Error(err.Error())
+		rest.WriteErrorCode(w, http.StatusBadRequest, rest.CodeValidationFailed, fmt.Sprintf("There was an error validating the request: %v", err))
+		return
+	}
+
+	// Get the candidates for the user
+	page, err := discover.GetCandidates(userID, req)
+	if err == user.ErrEmailNotVerified {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusForbidden, fmt.Sprintf("Access denied: %v", err))
+		return
+	}
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	// Json marshal the response
+	resp, err := json.Marshal(page)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	// Write the HTTP response
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(resp)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	clog.Info("Request succesfully processed")
+
+}
+
+// getDiscoverRequest reads the `page`, `page_size`, `min_age`, `max_age` and `seed` query params. If no
+// seed is provided, it is derived from the auth token so that the pages stay stable for the session.
+func getDiscoverRequest(r *http.Request) (discover.Request, error) {
+	var req = discover.Request{
+		Page:     1,
+		PageSize: discover.DefaultPageSize,
+	}
+	var err error
+
+	q := r.URL.Query()
+	if v := q.Get("page"); v != "" {
+		req.Page, err = strconv.Atoi(v)
+		if err != nil {
+			return req, fmt.Errorf("page should be a number")
+		}
+	}
+	if v := q.Get("page_size"); v != "" {
+		req.PageSize, err = strconv.Atoi(v)
+		if err != nil {
+			return req, fmt.Errorf("page_size should be a number")
+		}
+	}
+	if v := q.Get("min_age"); v != "" {
+		req.MinAge, err = strconv.Atoi(v)
+		if err != nil {
+			return req, fmt.Errorf("min_age should be a number")
+		}
+	}
+	if v := q.Get("max_age"); v != "" {
+		req.MaxAge, err = strconv.Atoi(v)
+		if err != nil {
+			return req, fmt.Errorf("max_age should be a number")
+		}
+	}
+	if v := q.Get("seed"); v != "" {
+		req.Seed, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return req, fmt.Errorf("seed should be a number")
+		}
+		return req, nil
+	}
+
+	token, err := auth.GetTokenFromRequest(r)
+	if err != nil {
+		return req, err
+	}
+	h := fnv.New64a()
+	h.Write([]byte(token))
+	req.Seed = int64(h.Sum64())
+
+	return req, nil
+}
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/lib/auth"
+	"github.com/teejays/matchapi/lib/pubsub"
+	"github.com/teejays/matchapi/lib/rest"
+)
+
+// EventsHeartbeatInterval is how often a comment is sent on an idle event stream, so that proxies keep
+// the connection open and disconnected clients are detected
+var EventsHeartbeatInterval = 15 * time.Second
+
+// HandleGetEvents streams the events for the user (likes received, matches, messages and profile views)
+// as Server-Sent Events, until the client disconnects. The user is checked again on every heartbeat, so
+// that the stream is closed when the session is revoked or the user is suspended.
+// Example Request: curl -v -N localhost:8080/v2/events
+func HandleGetEvents(w http.ResponseWriter, r *http.Request) {
+
+	// Get the payload from the request, which is checked again on every heartbeat
+	payload, err := auth.GetPayloadFromRequest(r)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
+		return
+	}
+	userID := payload.UserID
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		clog.Error("the response writer does not support streaming")
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	sub := pubsub.Subscribe(userID)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	clog.Infof("Events: user %d connected", userID)
+
+	ticker := time.NewTicker(EventsHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				clog.Error(err.Error())
+				continue
+			}
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+			if err != nil {
+				clog.Infof("Events: user %d disconnected: %v", userID, err)
+				return
+			}
+		case <-ticker.C:
+			if err := auth.ValidatePayload(payload); err != nil {
+				clog.Infof("Events: closing the stream of user %d: %v", userID, err)
+				return
+			}
+			_, err = fmt.Fprint(w, ": ping\n\n")
+			if err != nil {
+				clog.Infof("Events: user %d disconnected: %v", userID, err)
+				return
+			}
+		case <-r.Context().Done():
+			clog.Infof("Events: user %d disconnected", userID)
+			return
+		}
+		flusher.Flush()
+	}
+}
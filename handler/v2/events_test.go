@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/lib/auth"
+	"github.com/teejays/matchapi/lib/pk"
+	"github.com/teejays/matchapi/lib/rest"
+)
+
+// revokedUserID is the user whose token is treated as revoked, like after a logout or a suspension
+var revokedUserID int64
+
+func init() {
+	clog.LogLevel = 7
+
+	auth.AddPayloadValidator(func(payload auth.TokenPayload) error {
+		if int64(payload.UserID) == atomic.LoadInt64(&revokedUserID) {
+			return auth.ErrTokenRevoked
+		}
+		return nil
+	})
+}
+
+func newEventsRequest(t *testing.T, ctx context.Context, url string, userID pk.ID) *http.Request {
+	payload, err := auth.NewPayload(userID, "jon.doe@email.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := auth.NewToken(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req.WithContext(ctx)
+}
+
+func TestHandleGetEvents(t *testing.T) {
+	defer func(d time.Duration) { EventsHeartbeatInterval = d }(EventsHeartbeatInterval)
+	EventsHeartbeatInterval = time.Hour
+	atomic.StoreInt64(&revokedUserID, 0)
+
+	h := rest.AddContextMiddleware(rest.AuthenticateMiddleware(http.HandlerFunc(HandleGetEvents)))
+
+	// The stream ends as soon as the client goes away
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), newEventsRequest(t, ctx, "/v2/events", 1))
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the stream did not end after the client went away")
+	}
+
+	// The stream is closed when the token is revoked
+	EventsHeartbeatInterval = 20 * time.Millisecond
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	resp, err := http.DefaultClient.Do(newEventsRequest(t, context.Background(), server.URL, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	closed := make(chan struct{})
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+		}
+		close(closed)
+	}()
+	atomic.StoreInt64(&revokedUserID, 2)
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("the stream was not closed after the token was revoked")
+	}
+}
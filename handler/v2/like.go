@@ -4,13 +4,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/teejays/clog"
 
 	"github.com/teejays/matchapi/lib/auth"
 	"github.com/teejays/matchapi/lib/rest"
+	"github.com/teejays/matchapi/lib/validate"
 	"github.com/teejays/matchapi/service/like/v2"
+	"github.com/teejays/matchapi/service/user/v1"
 )
 
 // HandleGetIncomingLikes ...
@@ -21,7 +26,7 @@ func HandleGetIncomingLikes(w http.ResponseWriter, r *http.Request) {
 	userID, err := auth.GetUserIdFromRequest(r)
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, "Could not authenticate the user", http.StatusUnauthorized)
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
 		return
 	}
 
@@ -33,7 +38,7 @@ func HandleGetIncomingLikes(w http.ResponseWriter, r *http.Request) {
 	likes, err := like.GetIncomingLikesByUserID(userID)
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, rest.CleanAPIErrMessage, http.StatusInternalServerError)
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
 		return
 	}
 
@@ -43,7 +48,7 @@ func HandleGetIncomingLikes(w http.ResponseWriter, r *http.Request) {
 	resp, err := json.Marshal(likes)
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, rest.CleanAPIErrMessage, http.StatusInternalServerError)
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
 		return
 	}
 
@@ -52,7 +57,7 @@ func HandleGetIncomingLikes(w http.ResponseWriter, r *http.Request) {
 	_, err = w.Write(resp)
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, rest.CleanAPIErrMessage, http.StatusInternalServerError)
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
 		return
 	}
 
@@ -62,13 +67,14 @@ func HandleGetIncomingLikes(w http.ResponseWriter, r *http.Request) {
 
 // HandlePostLike ...
 // Example Request: curl -v -X "POST" localhost:8080/{userid}/v2/like -d '{"ReceiverID": 3}'
+// Super likes can be sent by passing the Kind: curl -v -X "POST" localhost:8080/v2/like -d '{"ReceiverID": 3, "Kind": 1}'
 func HandlePostLike(w http.ResponseWriter, r *http.Request) {
 
 	// Get the userID from the request
 	userID, err := auth.GetUserIdFromRequest(r)
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, "Could not authenticate the user", http.StatusUnauthorized)
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
 		return
 	}
 
@@ -76,7 +82,7 @@ func HandlePostLike(w http.ResponseWriter, r *http.Request) {
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, "There was an error reading the request", http.StatusBadRequest)
+		rest.WriteError(w, http.StatusBadRequest, "There was an error reading the request")
 		return
 	}
 
@@ -85,30 +91,54 @@ func HandlePostLike(w http.ResponseWriter, r *http.Request) {
 	err = json.Unmarshal(body, &blike)
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, "There was an error json unmarshaling the request", http.StatusBadRequest)
+		rest.WriteError(w, http.StatusBadRequest, "There was an error json unmarshaling the request")
 		return
 	}
 
 	// Validate that the profile is has all required info
 	if err := blike.Validate(); err != nil {
 		clog.Error(err.Error())
-		http.Error(w, fmt.Sprintf("There was an error validating the request: %v", err), http.StatusBadRequest)
+		writeLikeValidationError(w, err)
 		return
 	}
 
 	// Update the profile of the given user
-	like, err := like.NewLike(userID, blike)
+	newLike, err := like.NewLike(userID, blike)
+	if err == like.ErrReceiverNotFound {
+		clog.Error(err.Error())
+		writeLikeValidationError(w, err)
+		return
+	}
+	if err == user.ErrEmailNotVerified {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusForbidden, fmt.Sprintf("Access denied: %v", err))
+		return
+	}
+	if qErr, ok := err.(*like.SuperLikeQuotaError); ok {
+		clog.Error(err.Error())
+		retryAfter := math.Ceil(time.Until(qErr.ResetAt).Seconds())
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter)))
+		rest.WriteError(w, http.StatusTooManyRequests, fmt.Sprintf("You have used all of your super likes for today. More super likes will be available at %s", qErr.ResetAt.Format(time.RFC3339)))
+		return
+	}
+	if rErr, ok := err.(*like.RateLimitError); ok {
+		clog.Error(err.Error())
+		retryAfter := math.Ceil(time.Until(rErr.ResetAt).Seconds())
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter)))
+		rest.WriteError(w, http.StatusTooManyRequests, fmt.Sprintf("You have reached the limit of %d likes. More likes will be available at %s", rErr.Limit, rErr.ResetAt.Format(time.RFC3339)))
+		return
+	}
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, rest.CleanAPIErrMessage, http.StatusInternalServerError)
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
 		return
 	}
 
 	// Json marshal the updated profile so we can send it back
-	resp, err := json.Marshal(like)
+	resp, err := json.Marshal(newLike)
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, rest.CleanAPIErrMessage, http.StatusInternalServerError)
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
 		return
 	}
 
@@ -117,9 +147,59 @@ func HandlePostLike(w http.ResponseWriter, r *http.Request) {
 	_, err = w.Write(resp)
 	if err != nil {
 		clog.Error(err.Error())
-		http.Error(w, rest.CleanAPIErrMessage, http.StatusInternalServerError)
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+	}
+
+	clog.Info("Request succesfully processed")
+
+}
+
+// HandleGetLikeQuota ...
+// Example Request: curl -v localhost:8080/v2/like/quota
+func HandleGetLikeQuota(w http.ResponseWriter, r *http.Request) {
+
+	// Get the userID from the request
+	userID, err := auth.GetUserIdFromRequest(r)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
+		return
+	}
+
+	// Get the current like budget of the user
+	quota, err := like.GetQuotaByUserID(userID)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	// Json marshal the response
+	resp, err := json.Marshal(quota)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	// Write the HTTP response
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(resp)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
 	}
 
 	clog.Info("Request succesfully processed")
 
 }
+
+// writeLikeValidationError responds with the fields of the like that are not valid. A receiver that
+// doesn't exist is reported against the ReceiverID field.
+func writeLikeValidationError(w http.ResponseWriter, err error) {
+	if err == like.ErrReceiverNotFound {
+		err = validate.Errors{{Field: "ReceiverID", Code: validate.CodeNotFound, Message: err.Error()}}
+	}
+	rest.WriteValidationError(w, err)
+}
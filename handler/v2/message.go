@@ -0,0 +1,265 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/lib/auth"
+	"github.com/teejays/matchapi/lib/pk"
+	"github.com/teejays/matchapi/lib/rest"
+	"github.com/teejays/matchapi/service/message/v1"
+)
+
+// StartConversationRequest is the body of the request to start a conversation with a matched user
+type StartConversationRequest struct {
+	UserID pk.ID
+}
+
+// HandleGetConversations lists the conversations of the user, with the latest activity first
+// Example Request: curl -v localhost:8080/v2/conversation
+func HandleGetConversations(w http.ResponseWriter, r *http.Request) {
+
+	// Get the userID from the request
+	userID, err := auth.GetUserIdFromRequest(r)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
+		return
+	}
+
+	convs, err := message.GetConversationsByUserID(userID)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, convs)
+}
+
+// HandlePostConversation returns the conversation with a matched user, starting it if needed
+// Example Request: curl -v -X "POST" localhost:8080/v2/conversation -d '{"UserID": 3}'
+func HandlePostConversation(w http.ResponseWriter, r *http.Request) {
+
+	// Get the userID from the request
+	userID, err := auth.GetUserIdFromRequest(r)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
+		return
+	}
+
+	// Read the HTTP request body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusBadRequest, "There was an error reading the request")
+		return
+	}
+	var req StartConversationRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusBadRequest, "There was an error json unmarshaling the request")
+		return
+	}
+	if req.UserID < 1 {
+		rest.WriteErrorCode(w, http.StatusBadRequest, rest.CodeValidationFailed, "There was an error validating the request: invalid UserID: should be greater than 0")
+		return
+	}
+
+	conv, err := message.GetOrCreateConversation(userID, req.UserID)
+	if err == message.ErrCannotMessageSelf {
+		rest.WriteErrorCode(w, http.StatusBadRequest, rest.CodeValidationFailed, fmt.Sprintf("There was an error validating the request: %v", err))
+		return
+	}
+	if err == message.ErrNotMatched {
+		rest.WriteError(w, http.StatusForbidden, fmt.Sprintf("Access denied: %v", err))
+		return
+	}
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, conv)
+}
+
+// HandleGetMessages returns a page of the messages in a conversation, most recent first
+// Example Request: curl -v "localhost:8080/v2/conversation/{id}/message?page=1&page_size=50"
+func HandleGetMessages(w http.ResponseWriter, r *http.Request) {
+
+	// Get the userID from the request
+	userID, err := auth.GetUserIdFromRequest(r)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
+		return
+	}
+
+	conversationID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		rest.WriteErrorCode(w, http.StatusBadRequest, rest.CodeValidationFailed, "There was an error validating the request: invalid conversation id")
+		return
+	}
+
+	// Build the page request from the query params
+	var req = message.Request{
+		Page:     1,
+		PageSize: message.DefaultPageSize,
+	}
+	q := r.URL.Query()
+	if v := q.Get("page"); v != "" {
+		req.Page, err = strconv.Atoi(v)
+		if err != nil {
+			rest.WriteErrorCode(w, http.StatusBadRequest, rest.CodeValidationFailed, "There was an error validating the request: page should be a number")
+			return
+		}
+	}
+	if v := q.Get("page_size"); v != "" {
+		req.PageSize, err = strconv.Atoi(v)
+		if err != nil {
+			rest.WriteErrorCode(w, http.StatusBadRequest, rest.CodeValidationFailed, "There was an error validating the request: page_size should be a number")
+			return
+		}
+	}
+	if err := req.Validate(); err != nil {
+		rest.WriteErrorCode(w, http.StatusBadRequest, rest.CodeValidationFailed, fmt.Sprintf("There was an error validating the request: %v", err))
+		return
+	}
+
+	page, err := message.GetMessages(userID, pk.ID(conversationID), req)
+	if err == message.ErrConversationNotFound {
+		rest.WriteError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, page)
+}
+
+// HandlePostMessage sends a message in a conversation
+// Example Request: curl -v -X "POST" localhost:8080/v2/conversation/{id}/message -d '{"Text": "Hi!"}'
+func HandlePostMessage(w http.ResponseWriter, r *http.Request) {
+
+	// Get the userID from the request
+	userID, err := auth.GetUserIdFromRequest(r)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
+		return
+	}
+
+	conversationID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		rest.WriteErrorCode(w, http.StatusBadRequest, rest.CodeValidationFailed, "There was an error validating the request: invalid conversation id")
+		return
+	}
+
+	// Read the HTTP request body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusBadRequest, "There was an error reading the request")
+		return
+	}
+
+	// Json unmarshal the request into the BasicMessage struct
+	var bmessage message.BasicMessage
+	err = json.Unmarshal(body, &bmessage)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusBadRequest, "There was an error json unmarshaling the request")
+		return
+	}
+
+	// Validate the request
+	if err := bmessage.Validate(); err != nil {
+		clog.Error(err.Error())
+		rest.WriteErrorCode(w, http.StatusBadRequest, rest.CodeValidationFailed, fmt.Sprintf("There was an error validating the request: %v", err))
+		return
+	}
+
+	msg, err := message.NewMessage(userID, pk.ID(conversationID), bmessage)
+	if err == message.ErrConversationNotFound {
+		rest.WriteError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err == message.ErrNotMatched {
+		rest.WriteError(w, http.StatusForbidden, fmt.Sprintf("Access denied: %v", err))
+		return
+	}
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, msg)
+}
+
+// HandleMarkConversationRead marks all the messages in a conversation as read by the user
+// Example Request: curl -v -X "PUT" localhost:8080/v2/conversation/{id}/read
+func HandleMarkConversationRead(w http.ResponseWriter, r *http.Request) {
+
+	// Get the userID from the request
+	userID, err := auth.GetUserIdFromRequest(r)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
+		return
+	}
+
+	conversationID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		rest.WriteErrorCode(w, http.StatusBadRequest, rest.CodeValidationFailed, "There was an error validating the request: invalid conversation id")
+		return
+	}
+
+	conv, err := message.MarkAsRead(userID, pk.ID(conversationID))
+	if err == message.ErrConversationNotFound {
+		rest.WriteError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, conv)
+}
+
+// writeJSON json marshals the data and writes it to the http response with the status code
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+
+	// Json marshal the response
+	resp, err := json.Marshal(data)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	// Write the HTTP response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err = w.Write(resp)
+	if err != nil {
+		clog.Error(err.Error())
+		return
+	}
+
+	clog.Info("Request succesfully processed")
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/lib/auth"
+	"github.com/teejays/matchapi/lib/rest"
+	"github.com/teejays/matchapi/service/like/v2"
+)
+
+// HandlePostPass ...
+// Example Request: curl -v -X "POST" localhost:8080/v2/pass -d '{"ReceiverID": 3}'
+func HandlePostPass(w http.ResponseWriter, r *http.Request) {
+
+	// Get the userID from the request
+	userID, err := auth.GetUserIdFromRequest(r)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusUnauthorized, "Could not authenticate the user")
+		return
+	}
+
+	// Read the HTTP request body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusBadRequest, "There was an error reading the request")
+		return
+	}
+
+	// Json unmarshal the request into the BasicPass struct
+	var bpass like.BasicPass
+	err = json.Unmarshal(body, &bpass)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusBadRequest, "There was an error json unmarshaling the request")
+		return
+	}
+
+	// Validate the request
+	if err := bpass.Validate(); err != nil {
+		clog.Error(err.Error())
+		rest.WriteErrorCode(w, http.StatusBadRequest, rest.CodeValidationFailed, fmt.Sprintf("There was an error validating the request: %v", err))
+		return
+	}
+
+	// Save the pass
+	pass, err := like.NewPass(userID, bpass)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	// Json marshal the pass so we can send it back
+	resp, err := json.Marshal(pass)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+		return
+	}
+
+	// Write the pass to the http response
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(resp)
+	if err != nil {
+		clog.Error(err.Error())
+		rest.WriteError(w, http.StatusInternalServerError, rest.CleanAPIErrMessage)
+	}
+
+	clog.Info("Request succesfully processed")
+
+}
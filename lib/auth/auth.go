@@ -3,15 +3,15 @@ package auth
 import (
 	"context"
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/teejays/clog"
 
-	"github.com/teejays/go-jwt"
 	"github.com/teejays/matchapi/lib/pk"
 )
 
@@ -23,14 +23,80 @@ const ctxKeyForIsAuthenticated = contextKey("is_authenticated")
 const ctxKeyForPayload = contextKey("jwt_payload")
 const ctxKeyForToken = contextKey("jwt_token")
 
+// Roles give users access to the parts of the API that regular users can't access
+const (
+	// RoleAdmin has access to everything, including managing the roles of other users
+	RoleAdmin = "admin"
+	// RoleModerator has access to the moderation tools: reports, flagged profiles and suspensions
+	RoleModerator = "moderator"
+)
+
+// Roles are all the known roles
+var Roles = []string{RoleAdmin, RoleModerator}
+
 // TokenPayload is the payload type that goes in the JWT token
 type TokenPayload struct {
 	UserID pk.ID
 	Email  string
+	Roles  []string
+	// SessionID is the session that the token belongs to. Revoking the session revokes the token.
+	SessionID pk.ID `json:",omitempty"`
+	// APIKeyID is set when the request is authenticated with an API key instead of a token. The
+	// request is then limited to the Scopes of the key.
+	APIKeyID pk.ID    `json:",omitempty"`
+	Scopes   []string `json:",omitempty"`
+}
+
+// IsAPIKey returns true if the payload comes from an API key
+func (p TokenPayload) IsAPIKey() bool {
+	return p.APIKeyID != 0
+}
+
+// HasRole returns true if the payload grants the role
+func (p TokenPayload) HasRole(role string) bool {
+	return HasRole(p.Roles, role)
+}
+
+// HasRole returns true if the list of roles grants the role. Admins are granted every role.
+func HasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role || r == RoleAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAllRoles returns true if the list of roles grants every one of the other roles. It is used to make
+// sure that users can only act on users who don't have more access than they do.
+func HasAllRoles(roles []string, others []string) bool {
+	for _, o := range others {
+		if !HasRole(roles, o) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateRoles returns an error if any of the roles is not known
+func ValidateRoles(roles []string) error {
+	for _, r := range roles {
+		var known bool
+		for _, k := range Roles {
+			if r == k {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("role '%s' is invalid; possible values are %s", r, strings.Join(Roles, ", "))
+		}
+	}
+	return nil
 }
 
 // NewPayload creates a new payload for the JWT token
-func NewPayload(userID pk.ID, email string) (TokenPayload, error) {
+func NewPayload(userID pk.ID, email string, roles []string) (TokenPayload, error) {
 	var payload TokenPayload
 
 	// Validate the params before making the payload
@@ -41,14 +107,73 @@ func NewPayload(userID pk.ID, email string) (TokenPayload, error) {
 		return payload, fmt.Errorf("cannot create a paylaod with empty email")
 	}
 
+	if err := ValidateRoles(roles); err != nil {
+		return payload, err
+	}
+
 	payload = TokenPayload{
 		UserID: userID,
 		Email:  email,
+		Roles:  roles,
 	}
 
 	return payload, nil
 }
 
+// PayloadValidator is a function that can deny access to an authenticated user, e.g. because the
+// account has been suspended. It is called with the payload of every authenticated request.
+type PayloadValidator func(payload TokenPayload) error
+
+var payloadValidators []PayloadValidator
+
+// AddPayloadValidator registers a PayloadValidator. It lets the services enforce their rules on every
+// request without this package having to depend on them.
+func AddPayloadValidator(v PayloadValidator) {
+	payloadValidators = append(payloadValidators, v)
+}
+
+// APIKeyVerifier checks an API key, and returns the payload that the requests made with it are
+// authenticated with
+type APIKeyVerifier func(key string) (TokenPayload, error)
+
+var apiKeyVerifier APIKeyVerifier
+
+// SetAPIKeyVerifier lets AuthenticateRequest accept API keys, in the `Authorization: ApiKey <key>`
+// header, as well as the Bearer tokens
+func SetAPIKeyVerifier(v APIKeyVerifier) {
+	apiKeyVerifier = v
+}
+
+// ErrTokenRevoked can be returned by a PayloadValidator when the token itself is no longer valid, e.g.
+// because the user has logged out. Unlike the other errors, the request is treated as unauthenticated.
+var ErrTokenRevoked = fmt.Errorf("the token has been revoked; please log in again")
+
+// AccessDeniedError is returned by AuthenticateRequest when the token is valid, but one of the
+// PayloadValidators has denied access to the user
+type AccessDeniedError struct {
+	Err error
+}
+
+func (e *AccessDeniedError) Error() string {
+	return e.Err.Error()
+}
+
+// ValidatePayload runs the PayloadValidators on the payload. It returns ErrTokenRevoked if the token is no
+// longer valid, or an AccessDeniedError if one of the validators has denied access. Long-lived requests,
+// e.g. the event streams, can call it again to find out if the user is still allowed in.
+func ValidatePayload(payload TokenPayload) error {
+	for _, v := range payloadValidators {
+		err := v(payload)
+		if err == ErrTokenRevoked {
+			return err
+		}
+		if err != nil {
+			return &AccessDeniedError{Err: err}
+		}
+	}
+	return nil
+}
+
 // AuthenticateRequest should implement the authentication logic. It should should at the auth token
 // and figure out what user context. Currently, this is not implemented and it only relies on
 // and explicitly passed userID in the route.
@@ -58,33 +183,36 @@ func AuthenticateRequest(r *http.Request) (*http.Request, error) {
 	val := r.Header.Get("Authorization")
 	clog.Debugf("Authenticate Header: %v", val)
 	// In JWT, we're looking for the Bearer type token
-	// This means that the val should be like: Bearer <token>
+	// This means that the val should be like: Bearer <token>, or ApiKey <key> for the API keys
 	// - split by the space
 	valParts := strings.Split(val, " ")
 	if len(valParts) != 2 {
 		return r, fmt.Errorf("Authorization header has an invalid form: it's not `Authorization:Bearer <token>")
 	}
-	if valParts[0] != "Bearer" {
-		return r, fmt.Errorf("Authorization header has an invalid form: it's not `Authorization:Bearer <token>")
-	}
 
 	token := valParts[1]
 
-	// TODO: What if JWT client isn't initialized?
-	if !jwt.IsClientInitialized() {
-		err := InitJWTClient()
-		if err != nil {
-			return r, err
-		}
+	var payload TokenPayload
+	var err error
+	switch {
+	case valParts[0] == "Bearer":
+		payload, err = VerifyToken(token)
+	case valParts[0] == "ApiKey" && apiKeyVerifier != nil:
+		payload, err = apiKeyVerifier(token)
+	default:
+		return r, fmt.Errorf("Authorization header has an invalid form: it's not `Authorization:Bearer <token>")
 	}
-	cl, err := jwt.GetClient()
 	if err != nil {
 		return r, err
 	}
 
-	var payload TokenPayload
-	err = cl.VerifyAndDecode(token, &payload)
-	if err != nil {
+	// API keys can only be used on the routes that they have a scope for
+	if payload.IsAPIKey() && !HasScope(payload.Scopes, r) {
+		return r, &AccessDeniedError{Err: fmt.Errorf("the API key does not have the scope %s", RequestScope(r))}
+	}
+
+	// The token is valid, but the user might not be allowed in anymore
+	if err := ValidatePayload(payload); err != nil {
 		return r, err
 	}
 
@@ -139,6 +267,20 @@ func GetPayloadFromRequest(r *http.Request) (TokenPayload, error) {
 	return payload, nil
 }
 
+// GetTokenFromRequest returns the JWT token that was used to authenticate the request
+func GetTokenFromRequest(r *http.Request) (string, error) {
+	if !IsRequestAuthenticated(r) {
+		return "", ErrNotAuthenticated
+	}
+
+	token, ok := r.Context().Value(ctxKeyForToken).(string)
+	if !ok {
+		return "", ErrNotAuthenticated
+	}
+
+	return token, nil
+}
+
 func GetUserIdFromRequest(r *http.Request) (pk.ID, error) {
 	var userID pk.ID
 	payload, err := GetPayloadFromRequest(r)
@@ -150,6 +292,16 @@ func GetUserIdFromRequest(r *http.Request) (pk.ID, error) {
 
 }
 
+// NewSecretKey returns a random secret, to sign or hash with when no secret is configured. It is meant to
+// initialize the package level keys, so it panics if no random bytes can be read.
+func NewSecretKey() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("could not generate a secret key: %v", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
 // GetHash returns the hash of the message
 func GetHash(message, secret string) ([]byte, error) {
 	h, err := hash([]byte(message), []byte(secret))
@@ -171,7 +323,3 @@ func IsEqualHash(h1, h2 []byte) bool {
 	clog.Debugf("H1: %v\nH2: %v", h1, h2)
 	return hmac.Equal(h1, h2)
 }
-
-func InitJWTClient() error {
-	return jwt.InitClient(JWTSecretKey, time.Hour*48)
-}
@@ -0,0 +1,431 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/teejays/clog"
+)
+
+// The algorithms that the tokens can be signed with
+const (
+	// AlgorithmHS256 is HMAC with SHA-256. The same secret signs and verifies, so it cannot be shared.
+	AlgorithmHS256 = "HS256"
+	// AlgorithmES256 is ECDSA with the P-256 curve and SHA-256
+	AlgorithmES256 = "ES256"
+	// AlgorithmEdDSA is Ed25519
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// Algorithms are all the supported algorithms
+var Algorithms = []string{AlgorithmHS256, AlgorithmES256, AlgorithmEdDSA}
+
+// Key is a key that signs and verifies the tokens
+type Key struct {
+	ID        string
+	Algorithm string
+	CreatedAt time.Time
+	// RetiredAt is when the key stopped signing new tokens. It is kept to verify the tokens that it has
+	// signed until they expire.
+	RetiredAt time.Time
+
+	secret []byte
+	signer crypto.Signer
+}
+
+// NewKey generates a new random key for the algorithm
+func NewKey(algorithm string, t time.Time) (*Key, error) {
+	k := &Key{Algorithm: algorithm, CreatedAt: t}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	k.ID = fmt.Sprintf("%x", id)
+
+	var err error
+	switch algorithm {
+	case AlgorithmHS256:
+		k.secret = make([]byte, 32)
+		_, err = rand.Read(k.secret)
+	case AlgorithmES256:
+		k.signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgorithmEdDSA:
+		_, k.signer, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("algorithm '%s' is not supported; possible values are %s", algorithm, strings.Join(Algorithms, ", "))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// NewHMACKey creates an HS256 key with the provided ID and secret
+func NewHMACKey(id string, secret []byte, t time.Time) *Key {
+	return &Key{ID: id, Algorithm: AlgorithmHS256, CreatedAt: t, secret: secret}
+}
+
+func (k *Key) sign(message []byte) ([]byte, error) {
+	switch k.Algorithm {
+	case AlgorithmHS256:
+		h := hmac.New(sha256.New, k.secret)
+		h.Write(message)
+		return h.Sum(nil), nil
+
+	case AlgorithmES256:
+		digest := sha256.Sum256(message)
+		r, s, err := ecdsa.Sign(rand.Reader, k.signer.(*ecdsa.PrivateKey), digest[:])
+		if err != nil {
+			return nil, err
+		}
+		// JWS signatures are the fixed size r and s, rather than the ASN.1 encoding (RFC 7518, section 3.4)
+		return append(padded(r, 32), padded(s, 32)...), nil
+
+	case AlgorithmEdDSA:
+		return ed25519.Sign(k.signer.(ed25519.PrivateKey), message), nil
+	}
+	return nil, fmt.Errorf("algorithm '%s' is not supported", k.Algorithm)
+}
+
+func (k *Key) verify(message, sig []byte) bool {
+	switch k.Algorithm {
+	case AlgorithmHS256:
+		h := hmac.New(sha256.New, k.secret)
+		h.Write(message)
+		return hmac.Equal(h.Sum(nil), sig)
+
+	case AlgorithmES256:
+		if len(sig) != 64 {
+			return false
+		}
+		digest := sha256.Sum256(message)
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(&k.signer.(*ecdsa.PrivateKey).PublicKey, digest[:], r, s)
+
+	case AlgorithmEdDSA:
+		return ed25519.Verify(k.signer.Public().(ed25519.PublicKey), message, sig)
+	}
+	return false
+}
+
+// padded returns the big-endian bytes of n, left padded with zeros to the size
+func padded(n *big.Int, size int) []byte {
+	b := n.Bytes()
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
+
+// JWK is the public part of a key, in the JSON Web Key format (RFC 7517)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	Y         string `json:"y,omitempty"`
+}
+
+// JWKSet is a set of public keys, as published at the JWKS endpoint
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWK returns the public key in the JWK format. HMAC keys have no public part, so it returns false for them.
+func (k *Key) JWK() (JWK, bool) {
+	jwk := JWK{KeyID: k.ID, Algorithm: k.Algorithm, Use: "sig"}
+
+	switch k.Algorithm {
+	case AlgorithmES256:
+		pub := k.signer.Public().(*ecdsa.PublicKey)
+		jwk.KeyType, jwk.Curve = "EC", "P-256"
+		jwk.X, jwk.Y = b64.EncodeToString(padded(pub.X, 32)), b64.EncodeToString(padded(pub.Y, 32))
+		return jwk, true
+
+	case AlgorithmEdDSA:
+		jwk.KeyType, jwk.Curve = "OKP", "Ed25519"
+		jwk.X = b64.EncodeToString(k.signer.Public().(ed25519.PublicKey))
+		return jwk, true
+	}
+	return jwk, false
+}
+
+// Keyring holds the signing keys. The active key signs the new tokens, while all the keys can verify them.
+type Keyring struct {
+	lock   sync.RWMutex
+	keys   []*Key
+	active string
+	// path is the file that the keyring is saved to when it changes, if any
+	path string
+}
+
+// DefaultKeyring is the keyring used by NewToken and VerifyToken. If no key has been added to it, an HS256
+// key is created from the JWTSecretKey.
+var DefaultKeyring = &Keyring{}
+
+// Add adds the key to the keyring and makes it the active key
+func (kr *Keyring) Add(k *Key) {
+	kr.lock.Lock()
+	defer kr.lock.Unlock()
+
+	kr.keys = append(kr.keys, k)
+	kr.active = k.ID
+}
+
+// Key returns the key with the ID, or nil if the keyring doesn't have it
+func (kr *Keyring) Key(id string) *Key {
+	kr.lock.RLock()
+	defer kr.lock.RUnlock()
+
+	for _, k := range kr.keys {
+		if k.ID == id {
+			return k
+		}
+	}
+	return nil
+}
+
+// Active returns the key that signs the new tokens, or nil if there is none
+func (kr *Keyring) Active() *Key {
+	return kr.Key(kr.activeID())
+}
+
+func (kr *Keyring) activeID() string {
+	kr.lock.RLock()
+	defer kr.lock.RUnlock()
+	return kr.active
+}
+
+func (kr *Keyring) activeKey(t time.Time) (*Key, error) {
+	if k := kr.Active(); k != nil {
+		return k, nil
+	}
+
+	kr.lock.Lock()
+	defer kr.lock.Unlock()
+	if kr.active == "" {
+		clog.Warnf("Auth | Keyring: no signing key configured, using an HS256 key from the JWTSecretKey")
+		k := NewHMACKey("default", []byte(JWTSecretKey), t)
+		kr.keys = append(kr.keys, k)
+		kr.active = k.ID
+	}
+	for _, k := range kr.keys {
+		if k.ID == kr.active {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("the active key %s is not in the keyring", kr.active)
+}
+
+// Rotate generates a new key for the algorithm and makes it the active key. The previous keys are retired:
+// they can verify the tokens that they have signed, until Prune removes them.
+func (kr *Keyring) Rotate(algorithm string, t time.Time) (*Key, error) {
+	k, err := NewKey(algorithm, t)
+	if err != nil {
+		return nil, err
+	}
+
+	kr.lock.Lock()
+	for _, old := range kr.keys {
+		if old.RetiredAt.IsZero() {
+			old.RetiredAt = t
+		}
+	}
+	kr.keys = append(kr.keys, k)
+	kr.active = k.ID
+	kr.lock.Unlock()
+
+	clog.Infof("Auth | Keyring: rotated the signing key; the active key is now %s (%s)", k.ID, k.Algorithm)
+
+	return k, kr.save()
+}
+
+// Prune removes the retired keys whose tokens have all expired at t
+func (kr *Keyring) Prune(t time.Time) error {
+	kr.lock.Lock()
+	var keys []*Key
+	var removed int
+	for _, k := range kr.keys {
+		if !k.RetiredAt.IsZero() && !t.Before(k.RetiredAt.Add(TokenLifespan)) {
+			removed++
+			continue
+		}
+		keys = append(keys, k)
+	}
+	kr.keys = keys
+	kr.lock.Unlock()
+
+	if removed == 0 {
+		return nil
+	}
+	clog.Infof("Auth | Keyring: removed %d expired keys", removed)
+	return kr.save()
+}
+
+// RotateIfDue rotates the keys if the active key is older than the interval, or doesn't use the algorithm,
+// and prunes the expired keys. It returns true if the keys have been rotated.
+func (kr *Keyring) RotateIfDue(algorithm string, interval time.Duration, t time.Time) (bool, error) {
+	var rotated bool
+
+	k := kr.Active()
+	if k == nil || k.Algorithm != algorithm || (interval > 0 && !t.Before(k.CreatedAt.Add(interval))) {
+		if _, err := kr.Rotate(algorithm, t); err != nil {
+			return false, err
+		}
+		rotated = true
+	}
+
+	return rotated, kr.Prune(t)
+}
+
+// RotationCheckInterval is how often StartRotation checks whether the keys are due for a rotation
+var RotationCheckInterval = time.Minute
+
+// StartRotation rotates the keys every interval, in the background, until the returned function is called
+func (kr *Keyring) StartRotation(algorithm string, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	ticker := time.NewTicker(RotationCheckInterval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case t := <-ticker.C:
+				if _, err := kr.RotateIfDue(algorithm, interval, t); err != nil {
+					clog.Errorf("Auth | Keyring: could not rotate the keys: %v", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+// JWKS returns the public keys of the keyring
+func (kr *Keyring) JWKS() JWKSet {
+	kr.lock.RLock()
+	defer kr.lock.RUnlock()
+
+	set := JWKSet{Keys: []JWK{}}
+	for _, k := range kr.keys {
+		if jwk, ok := k.JWK(); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	return set
+}
+
+// storedKey is how a key is saved in the keyring file
+type storedKey struct {
+	ID         string
+	Algorithm  string
+	CreatedAt  time.Time
+	RetiredAt  time.Time
+	Secret     []byte `json:",omitempty"`
+	PrivateKey []byte `json:",omitempty"`
+}
+
+type storedKeyring struct {
+	Active string
+	Keys   []storedKey
+}
+
+// LoadKeyring reads the keyring from the file, which is created when the keyring is first saved. The
+// keyring is saved back to the file whenever it changes, so that the tokens survive restarts.
+func LoadKeyring(path string) (*Keyring, error) {
+	kr := &Keyring{path: path}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return kr, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var s storedKeyring
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("could not decode the keyring at %s: %v", path, err)
+	}
+	for _, sk := range s.Keys {
+		k := &Key{ID: sk.ID, Algorithm: sk.Algorithm, CreatedAt: sk.CreatedAt, RetiredAt: sk.RetiredAt, secret: sk.Secret}
+		if len(sk.PrivateKey) > 0 {
+			priv, err := x509.ParsePKCS8PrivateKey(sk.PrivateKey)
+			if err != nil {
+				return nil, fmt.Errorf("could not decode key %s: %v", sk.ID, err)
+			}
+			signer, ok := priv.(crypto.Signer)
+			if !ok {
+				return nil, fmt.Errorf("key %s is not a signing key", sk.ID)
+			}
+			k.signer = signer
+		}
+		kr.keys = append(kr.keys, k)
+	}
+	kr.active = s.Active
+
+	return kr, nil
+}
+
+// save writes the keyring to its file, if it has one. The file holds the private keys, so only the owner
+// can read it.
+func (kr *Keyring) save() error {
+	kr.lock.RLock()
+	defer kr.lock.RUnlock()
+
+	if kr.path == "" {
+		return nil
+	}
+
+	s := storedKeyring{Active: kr.active}
+	for _, k := range kr.keys {
+		sk := storedKey{ID: k.ID, Algorithm: k.Algorithm, CreatedAt: k.CreatedAt, RetiredAt: k.RetiredAt, Secret: k.secret}
+		if k.signer != nil {
+			der, err := x509.MarshalPKCS8PrivateKey(k.signer)
+			if err != nil {
+				return err
+			}
+			sk.PrivateKey = der
+		}
+		s.Keys = append(s.Keys, sk)
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(kr.path), 0700); err != nil {
+		return err
+	}
+	// Write to a temporary file first, so that a crash doesn't leave a partial keyring behind
+	tmp := kr.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, kr.path)
+}
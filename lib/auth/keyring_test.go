@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+)
+
+func init() {
+	clog.LogLevel = 7
+}
+
+var testPayload = TokenPayload{UserID: 1, Email: "jon.doe@email.com", Roles: []string{RoleModerator}}
+
+func TestKeyringSignAndVerify(t *testing.T) {
+	now := time.Now()
+
+	for _, alg := range Algorithms {
+		t.Run(alg, func(t *testing.T) {
+			kr := &Keyring{}
+			k, err := kr.Rotate(alg, now)
+			assert.NoError(t, err)
+
+			token, err := kr.Sign(testPayload, now)
+			assert.NoError(t, err)
+
+			payload, err := kr.Verify(token, now)
+			assert.NoError(t, err)
+			assert.Equal(t, testPayload, payload)
+
+			// The header should name the key and the algorithm
+			var h header
+			assert.NoError(t, decodeSegment(strings.Split(token, ".")[0], &h))
+			assert.Equal(t, header{Algorithm: alg, Type: "JWT", KeyID: k.ID}, h)
+
+			// Expired tokens are rejected
+			_, err = kr.Verify(token, now.Add(TokenLifespan))
+			assert.Equal(t, ErrTokenExpired, err)
+
+			// Tampered tokens are rejected
+			parts := strings.Split(token, ".")
+			other, err := kr.Sign(TokenPayload{UserID: 2, Email: "jane@email.com"}, now)
+			assert.NoError(t, err)
+			_, err = kr.Verify(parts[0]+"."+strings.Split(other, ".")[1]+"."+parts[2], now)
+			assert.Error(t, err)
+
+			// Tokens signed by other keyrings are rejected
+			_, err = (&Keyring{}).Verify(token, now)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestKeyringAlgorithmConfusion(t *testing.T) {
+	now := time.Now()
+	kr := &Keyring{}
+	k, err := kr.Rotate(AlgorithmES256, now)
+	assert.NoError(t, err)
+
+	// A token that claims HS256 for an ES256 key should be rejected, whatever its signature
+	h, err := encodeSegment(header{Algorithm: AlgorithmHS256, Type: "JWT", KeyID: k.ID})
+	assert.NoError(t, err)
+	c, err := encodeSegment(claims{TokenPayload: testPayload, ExpiresAt: now.Add(time.Hour).Unix()})
+	assert.NoError(t, err)
+	_, err = kr.Verify(h+"."+c+"."+b64.EncodeToString([]byte("signature")), now)
+	assert.Error(t, err)
+}
+
+func TestKeyringRotation(t *testing.T) {
+	now := time.Now()
+	kr := &Keyring{}
+
+	// The keyring should get a key with the right algorithm straight away
+	rotated, err := kr.RotateIfDue(AlgorithmEdDSA, 24*time.Hour, now)
+	assert.NoError(t, err)
+	assert.True(t, rotated)
+	first := kr.Active()
+	oldToken, err := kr.Sign(testPayload, now)
+	assert.NoError(t, err)
+
+	rotated, err = kr.RotateIfDue(AlgorithmEdDSA, 24*time.Hour, now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.False(t, rotated)
+
+	// Once the key is due, a new key signs the tokens, but the old one can still verify its tokens
+	now = now.Add(24 * time.Hour)
+	rotated, err = kr.RotateIfDue(AlgorithmEdDSA, 24*time.Hour, now)
+	assert.NoError(t, err)
+	assert.True(t, rotated)
+	assert.NotEqual(t, first.ID, kr.Active().ID)
+	assert.Equal(t, now, first.RetiredAt)
+
+	_, err = kr.Verify(oldToken, now)
+	assert.NoError(t, err)
+	assert.Len(t, kr.JWKS().Keys, 2)
+
+	// Changing the algorithm rotates the key too
+	rotated, err = kr.RotateIfDue(AlgorithmES256, 24*time.Hour, now)
+	assert.NoError(t, err)
+	assert.True(t, rotated)
+	assert.Equal(t, AlgorithmES256, kr.Active().Algorithm)
+
+	// The retired keys are removed once their tokens have expired
+	assert.NoError(t, kr.Prune(now.Add(TokenLifespan)))
+	assert.Nil(t, kr.Key(first.ID))
+	assert.Len(t, kr.JWKS().Keys, 1)
+	assert.NotNil(t, kr.Active())
+}
+
+func TestKeyringSaveAndLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys", "jwt_keys.json")
+
+	now := time.Now()
+	kr, err := LoadKeyring(path)
+	assert.NoError(t, err)
+	assert.Nil(t, kr.Active())
+
+	var tokens []string
+	for _, alg := range Algorithms {
+		_, err := kr.Rotate(alg, now)
+		assert.NoError(t, err)
+		token, err := kr.Sign(testPayload, now)
+		assert.NoError(t, err)
+		tokens = append(tokens, token)
+	}
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// The tokens should survive a restart
+	loaded, err := LoadKeyring(path)
+	assert.NoError(t, err)
+	assert.Equal(t, kr.Active().ID, loaded.Active().ID)
+	for _, token := range tokens {
+		_, err := loaded.Verify(token, now)
+		assert.NoError(t, err)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	now := time.Now()
+	kr := &Keyring{}
+
+	// HMAC keys should never be published
+	kr.Add(NewHMACKey("hmac", []byte("secret"), now))
+	assert.Len(t, kr.JWKS().Keys, 0)
+
+	// Other services should be able to verify the tokens with the published keys only
+	for _, alg := range []string{AlgorithmES256, AlgorithmEdDSA} {
+		k, err := kr.Rotate(alg, now)
+		assert.NoError(t, err)
+		token, err := kr.Sign(testPayload, now)
+		assert.NoError(t, err)
+
+		var jwk JWK
+		for _, j := range kr.JWKS().Keys {
+			if j.KeyID == k.ID {
+				jwk = j
+			}
+		}
+		assert.Equal(t, alg, jwk.Algorithm)
+		assert.Equal(t, "sig", jwk.Use)
+
+		parts := strings.Split(token, ".")
+		message := []byte(parts[0] + "." + parts[1])
+		sig, err := b64.DecodeString(parts[2])
+		assert.NoError(t, err)
+		x, err := b64.DecodeString(jwk.X)
+		assert.NoError(t, err)
+
+		switch alg {
+		case AlgorithmES256:
+			assert.Equal(t, "EC", jwk.KeyType)
+			y, err := b64.DecodeString(jwk.Y)
+			assert.NoError(t, err)
+			pub := ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			digest := sha256.Sum256(message)
+			assert.True(t, ecdsa.Verify(&pub, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])))
+		case AlgorithmEdDSA:
+			assert.Equal(t, "OKP", jwk.KeyType)
+			assert.True(t, ed25519.Verify(ed25519.PublicKey(x), message, sig))
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// The access levels of a scope: read covers the GET and HEAD requests, write covers all the others
+const (
+	AccessRead  = "read"
+	AccessWrite = "write"
+)
+
+// scopeRegex matches the scopes, which are a route prefix and an access level, e.g. `admin/reports:read`
+var scopeRegex = regexp.MustCompile(`^[a-z0-9._-]+(/[a-z0-9._-]+)*:(read|write)$`)
+
+// ValidateScopes returns an error if any of the scopes is not of the form `<route prefix>:<read|write>`
+func ValidateScopes(scopes []string) error {
+	for _, s := range scopes {
+		if !scopeRegex.MatchString(s) {
+			return fmt.Errorf("scope '%s' is invalid; scopes should be a route prefix followed by :read or :write, e.g. admin/reports:read", s)
+		}
+	}
+	return nil
+}
+
+// RequestScope returns the scope needed to make the request, e.g. `admin/reports/12:read` for
+// GET /admin/reports/12
+func RequestScope(r *http.Request) string {
+	access := AccessWrite
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		access = AccessRead
+	}
+	return strings.Trim(r.URL.Path, "/") + ":" + access
+}
+
+// HasScope returns true if any of the scopes covers the request. A scope covers the requests to its
+// route and the routes under it, with the same access level: `admin/reports:read` covers
+// GET /admin/reports and GET /admin/reports/12, but not POST /admin/reports/12/action.
+func HasScope(scopes []string, r *http.Request) bool {
+	needed := strings.SplitN(RequestScope(r), ":", 2)
+	for _, s := range scopes {
+		granted := strings.SplitN(s, ":", 2)
+		if len(granted) != 2 || granted[1] != needed[1] {
+			continue
+		}
+		if needed[0] == granted[0] || strings.HasPrefix(needed[0], granted[0]+"/") {
+			return true
+		}
+	}
+	return false
+}
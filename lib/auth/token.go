@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TokenLifespan is how long the tokens are valid for
+var TokenLifespan = 48 * time.Hour
+
+var ErrInvalidToken = fmt.Errorf("the token is invalid")
+var ErrTokenExpired = fmt.Errorf("the token has expired")
+
+// header is the JOSE header of the tokens
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+// claims is the body of the tokens: the payload along with the registered claims
+type claims struct {
+	TokenPayload
+	IssuedAt  int64 `json:"iat"`
+	ExpiresAt int64 `json:"exp"`
+}
+
+var b64 = base64.RawURLEncoding
+
+// NewToken creates a token with the payload, signed with the active key of the DefaultKeyring
+func NewToken(payload TokenPayload) (string, error) {
+	return DefaultKeyring.Sign(payload, time.Now())
+}
+
+// VerifyToken verifies the token with the DefaultKeyring and returns its payload
+func VerifyToken(token string) (TokenPayload, error) {
+	return DefaultKeyring.Verify(token, time.Now())
+}
+
+// Sign creates a token with the payload at t, signed with the active key
+func (kr *Keyring) Sign(payload TokenPayload, t time.Time) (string, error) {
+	k, err := kr.activeKey(t)
+	if err != nil {
+		return "", err
+	}
+
+	h, err := encodeSegment(header{Algorithm: k.Algorithm, Type: "JWT", KeyID: k.ID})
+	if err != nil {
+		return "", err
+	}
+	c, err := encodeSegment(claims{TokenPayload: payload, IssuedAt: t.Unix(), ExpiresAt: t.Add(TokenLifespan).Unix()})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := h + "." + c
+	sig, err := k.sign([]byte(signingInput))
+	if err != nil {
+		return "", fmt.Errorf("error signing the token with key %s: %v", k.ID, err)
+	}
+
+	return signingInput + "." + b64.EncodeToString(sig), nil
+}
+
+// Verify checks the signature of the token with the key that it names, and returns its payload if it
+// hasn't expired at t
+func (kr *Keyring) Verify(token string, t time.Time) (TokenPayload, error) {
+	var payload TokenPayload
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return payload, ErrInvalidToken
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return payload, ErrInvalidToken
+	}
+	k := kr.Key(h.KeyID)
+	if k == nil {
+		return payload, fmt.Errorf("%v: unknown key '%s'", ErrInvalidToken, h.KeyID)
+	}
+	// The algorithm is decided by the key, never by the token, so that a token cannot pick a weaker one
+	if h.Algorithm != k.Algorithm {
+		return payload, fmt.Errorf("%v: algorithm %s does not match key %s", ErrInvalidToken, h.Algorithm, k.ID)
+	}
+
+	sig, err := b64.DecodeString(parts[2])
+	if err != nil {
+		return payload, ErrInvalidToken
+	}
+	if !k.verify([]byte(parts[0]+"."+parts[1]), sig) {
+		return payload, fmt.Errorf("%v: signature verification failed", ErrInvalidToken)
+	}
+
+	var c claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return payload, ErrInvalidToken
+	}
+	if t.Unix() >= c.ExpiresAt {
+		return payload, ErrTokenExpired
+	}
+
+	return c.TokenPayload, nil
+}
+
+func encodeSegment(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return b64.EncodeToString(data), nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := b64.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
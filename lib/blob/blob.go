@@ -0,0 +1,155 @@
+package blob
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/teejays/matchapi/lib/auth"
+)
+
+// Store is the interface that a blob storage backend needs to implement
+type Store interface {
+	// Put saves the data read from r under the key, overwriting any existing blob
+	Put(key string, r io.Reader) error
+	// Get returns a reader for the blob saved under the key. It returns ErrNotExist if there is no such blob.
+	Get(key string) (io.ReadCloser, error)
+	// Delete removes the blob saved under the key. It does not return an error if there is no such blob.
+	Delete(key string) error
+}
+
+// ErrNotExist is returned when the requested blob does not exist
+var ErrNotExist = fmt.Errorf("blob does not exist")
+
+// ErrInvalidKey is returned when the key has characters that are not allowed
+var ErrInvalidKey = fmt.Errorf("blob key is invalid: only letters, numbers, dashes and underscores are allowed")
+
+var keyRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// ValidateKey returns ErrInvalidKey if the key cannot be used to save a blob
+func ValidateKey(key string) error {
+	if !keyRegex.MatchString(key) {
+		return ErrInvalidKey
+	}
+	return nil
+}
+
+// FileStore is a Store that saves the blobs as files in a directory on the local filesystem
+type FileStore struct {
+	Root string
+}
+
+// NewFileStore creates a FileStore at the provided root directory, creating the directory if needed
+func NewFileStore(root string) (*FileStore, error) {
+	err := os.MkdirAll(root, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("could not create the blob directory %s: %v", root, err)
+	}
+	return &FileStore{Root: root}, nil
+}
+
+// Put implements the Store interface
+func (s *FileStore) Put(key string, r io.Reader) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so that a failed write doesn't leave a partial blob behind
+	tmp, err := ioutil.TempFile(s.Root, key+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path(key))
+}
+
+// Get implements the Store interface
+func (s *FileStore) Get(key string) (io.ReadCloser, error) {
+	if err := ValidateKey(key); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotExist
+	}
+	return f, err
+}
+
+// Delete implements the Store interface
+func (s *FileStore) Delete(key string) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+	err := os.Remove(s.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *FileStore) path(key string) string {
+	return filepath.Join(s.Root, key)
+}
+
+// URLSigningKey is the secret used to sign the blob URLs. It is random unless it is configured, so the
+// URLs that have been handed out stop working after a restart.
+var URLSigningKey = auth.NewSecretKey()
+
+// URLPathPrefix is the route at which the blobs are served
+var URLPathPrefix = "/v1/image/"
+
+// URLLifetime is the duration for which a signed URL stays valid
+var URLLifetime = time.Hour
+
+// ErrInvalidSignature is returned when a signed URL cannot be verified
+var ErrInvalidSignature = fmt.Errorf("the url signature is invalid or has expired")
+
+// SignedURL returns a URL path, with the expiry and signature as query params, that can be used to
+// fetch the blob until the URLLifetime passes
+func SignedURL(key string) string {
+	expires := time.Now().Add(URLLifetime).Unix()
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(expires, 10))
+	q.Set("signature", sign(key, expires))
+	return URLPathPrefix + key + "?" + q.Encode()
+}
+
+// VerifySignedURL verifies the expiry and signature query params of a URL created by SignedURL
+func VerifySignedURL(key string, query url.Values) error {
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if time.Now().Unix() > expires {
+		return ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(sign(key, expires)), []byte(query.Get("signature"))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func sign(key string, expires int64) string {
+	h := hmac.New(sha256.New, []byte(URLSigningKey))
+	h.Write([]byte(key + ":" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(h.Sum(nil))
+}
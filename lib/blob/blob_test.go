@@ -0,0 +1,46 @@
+package blob
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignedURL(t *testing.T) {
+	signed := SignedURL("abc123")
+	assert.True(t, strings.HasPrefix(signed, URLPathPrefix+"abc123?"))
+
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+
+	// The untouched URL should be valid
+	assert.NoError(t, VerifySignedURL("abc123", q))
+
+	// The signature should not be valid for another key
+	assert.Equal(t, ErrInvalidSignature, VerifySignedURL("abc124", q))
+
+	// Extending the expiry should invalidate the signature
+	extended := url.Values{}
+	extended.Set("expires", strconv.FormatInt(time.Now().Add(24*time.Hour).Unix(), 10))
+	extended.Set("signature", q.Get("signature"))
+	assert.Equal(t, ErrInvalidSignature, VerifySignedURL("abc123", extended))
+
+	// Expired URLs should not be valid
+	expired := url.Values{}
+	expired.Set("expires", strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10))
+	expired.Set("signature", sign("abc123", time.Now().Add(-time.Minute).Unix()))
+	assert.Equal(t, ErrInvalidSignature, VerifySignedURL("abc123", expired))
+}
+
+func TestValidateKey(t *testing.T) {
+	assert.NoError(t, ValidateKey("a1b2_thumb"))
+	assert.Equal(t, ErrInvalidKey, ValidateKey("../etc/passwd"))
+	assert.Equal(t, ErrInvalidKey, ValidateKey(""))
+}
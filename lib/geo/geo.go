@@ -0,0 +1,148 @@
+package geo
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// earthRadius is the mean radius of the earth in kilometers
+const earthRadius = 6371.0
+
+// kmPerDegree is the approximate length of a degree of latitude in kilometers
+const kmPerDegree = 111.32
+
+// geohashAlphabet is the base32 alphabet used by geohashes
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// Point represents a location on earth
+type Point struct {
+	Lat float64
+	Lng float64
+}
+
+// Validate returns error if the Point is not a valid coordinate
+func (p Point) Validate() error {
+	if p.Lat < -90 || p.Lat > 90 {
+		return fmt.Errorf("latitude should be between -90 and 90")
+	}
+	if p.Lng < -180 || p.Lng > 180 {
+		return fmt.Errorf("longitude should be between -180 and 180")
+	}
+	return nil
+}
+
+// Coarsen rounds the coordinates of the Point to the provided number of decimal places. Two decimal places
+// correspond to roughly a kilometer, which is enough to calculate distances without revealing exact locations.
+func (p Point) Coarsen(decimals int) Point {
+	factor := math.Pow(10, float64(decimals))
+	return Point{
+		Lat: math.Round(p.Lat*factor) / factor,
+		Lng: math.Round(p.Lng*factor) / factor,
+	}
+}
+
+// Distance returns the great-circle distance between the two points in kilometers
+func Distance(a, b Point) float64 {
+	lat1 := toRadians(a.Lat)
+	lat2 := toRadians(b.Lat)
+	dLat := toRadians(b.Lat - a.Lat)
+	dLng := toRadians(b.Lng - a.Lng)
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// Geohash encodes the Point into a geohash of the provided precision (number of characters)
+func Geohash(p Point, precision int) string {
+	var latRange = [2]float64{-90, 90}
+	var lngRange = [2]float64{-180, 180}
+
+	var hash strings.Builder
+	var bit, ch int
+	isLng := true
+	for hash.Len() < precision {
+		if isLng {
+			mid := (lngRange[0] + lngRange[1]) / 2
+			if p.Lng >= mid {
+				ch |= 1 << uint(4-bit)
+				lngRange[0] = mid
+			} else {
+				lngRange[1] = mid
+			}
+		} else {
+			mid := (latRange[0] + latRange[1]) / 2
+			if p.Lat >= mid {
+				ch |= 1 << uint(4-bit)
+				latRange[0] = mid
+			} else {
+				latRange[1] = mid
+			}
+		}
+		isLng = !isLng
+
+		bit++
+		if bit == 5 {
+			hash.WriteByte(geohashAlphabet[ch])
+			bit, ch = 0, 0
+		}
+	}
+
+	return hash.String()
+}
+
+// CellSize returns the height and width, in degrees, of a geohash cell of the provided precision
+func CellSize(precision int) (float64, float64) {
+	bits := 5 * precision
+	latBits := bits / 2
+	lngBits := bits - latBits
+	return 180 / math.Pow(2, float64(latBits)), 360 / math.Pow(2, float64(lngBits))
+}
+
+// CoveringGeohashes returns the geohashes of the provided precision that together cover the circle
+// of radius km around the center. The cells can cover a larger area than the circle, so the results
+// should be filtered by Distance afterwards.
+func CoveringGeohashes(center Point, radius float64, precision int) []string {
+	cellLat, cellLng := CellSize(precision)
+
+	// Bounding box of the circle
+	dLat := radius / kmPerDegree
+	minLat := math.Max(-90, center.Lat-dLat)
+	maxLat := math.Min(90, center.Lat+dLat)
+
+	var dLng float64
+	cosLat := math.Cos(toRadians(math.Max(math.Abs(minLat), math.Abs(maxLat))))
+	if cosLat < 0.01 || radius/(kmPerDegree*cosLat) >= 180 {
+		// Near the poles, or when the radius is huge, the circle covers all longitudes
+		dLng = 180
+	} else {
+		dLng = radius / (kmPerDegree * cosLat)
+	}
+
+	var seen = make(map[string]bool)
+	var hashes []string
+	for lat := minLat; lat < maxLat+cellLat; lat += cellLat {
+		for lng := center.Lng - dLng; lng < center.Lng+dLng+cellLng; lng += cellLng {
+			h := Geohash(Point{Lat: math.Min(lat, 90), Lng: normalizeLng(lng)}, precision)
+			if !seen[h] {
+				seen[h] = true
+				hashes = append(hashes, h)
+			}
+		}
+	}
+
+	return hashes
+}
+
+// normalizeLng wraps the longitude into the [-180, 180) range
+func normalizeLng(lng float64) float64 {
+	lng = math.Mod(lng+180, 360)
+	if lng < 0 {
+		lng += 360
+	}
+	return lng - 180
+}
+
+func toRadians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
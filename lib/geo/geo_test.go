@@ -0,0 +1,57 @@
+package geo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeohash(t *testing.T) {
+	tt := []struct {
+		name      string
+		point     Point
+		precision int
+		expected  string
+	}{
+		{
+			name:      "known geohash for a point in Denmark",
+			point:     Point{Lat: 57.64911, Lng: 10.40744},
+			precision: 11,
+			expected:  "u4pruydqqvj",
+		},
+		{
+			name:      "low precision geohash for New York",
+			point:     Point{Lat: 40.7128, Lng: -74.0060},
+			precision: 4,
+			expected:  "dr5r",
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, Geohash(test.point, test.precision))
+		})
+	}
+}
+
+func TestDistance(t *testing.T) {
+	newYork := Point{Lat: 40.7128, Lng: -74.0060}
+	london := Point{Lat: 51.5074, Lng: -0.1278}
+
+	assert.InDelta(t, 5570, Distance(newYork, london), 10)
+	assert.Equal(t, 0.0, Distance(london, london))
+}
+
+func TestCoveringGeohashes(t *testing.T) {
+	center := Point{Lat: 40.7128, Lng: -74.0060}
+	nearby := Point{Lat: 40.80, Lng: -73.90}
+	hashes := CoveringGeohashes(center, 25, 4)
+
+	assert.Contains(t, hashes, Geohash(center, 4))
+	assert.Contains(t, hashes, Geohash(nearby, 4))
+}
+
+func TestCoarsen(t *testing.T) {
+	p := Point{Lat: 40.712776, Lng: -74.005974}.Coarsen(2)
+	assert.Equal(t, Point{Lat: 40.71, Lng: -74.01}, p)
+}
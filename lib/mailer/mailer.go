@@ -0,0 +1,288 @@
+// Package mailer renders and sends emails. It has an SMTP sender for real use, and an in-memory sender
+// for the tests.
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	htmltemplate "html/template"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+)
+
+// Message is an email. At least one of Text and HTML should be set; if both are, the email has both
+// versions and the client picks one.
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Validate returns an error if the Message cannot be sent
+func (m Message) Validate() error {
+	if m.From == "" {
+		return fmt.Errorf("invalid From: cannot be empty")
+	}
+	if m.To == "" || strings.ContainsAny(m.To, "\r\n") {
+		return fmt.Errorf("invalid To: should be a single email address")
+	}
+	if strings.ContainsAny(m.Subject, "\r\n") {
+		return fmt.Errorf("invalid Subject: cannot have line breaks")
+	}
+	if m.Text == "" && m.HTML == "" {
+		return fmt.Errorf("the message has no body")
+	}
+	return nil
+}
+
+// Sender sends emails
+type Sender interface {
+	Send(m Message) error
+}
+
+// DefaultSMTPTimeout is how long an SMTPSender waits for the server to send an email, when it has no Timeout
+var DefaultSMTPTimeout = 30 * time.Second
+
+// SMTPSender sends the emails through an SMTP server. Username and Password are optional; if Username is
+// empty, no authentication is done, which is handy for local test servers.
+type SMTPSender struct {
+	// Addr is the host:port of the SMTP server
+	Addr     string
+	Username string
+	Password string
+	// Timeout limits the whole exchange with the server, from connecting to the end of the email, so that
+	// a stalled server cannot block the sending. If zero, DefaultSMTPTimeout is used.
+	Timeout time.Duration
+}
+
+// Send implements the Sender interface
+func (s SMTPSender) Send(m Message) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return fmt.Errorf("invalid SMTP address '%s': %v", s.Addr, err)
+	}
+
+	body, err := m.bytes()
+	if err != nil {
+		return err
+	}
+
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = DefaultSMTPTimeout
+	}
+	conn, err := net.DialTimeout("tcp", s.Addr, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+
+	// The same steps as smtp.SendMail, which has no way to set a timeout
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if s.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.Username, s.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(m.From); err != nil {
+		return err
+	}
+	if err := c.Rcpt(m.To); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// bytes returns the message in the format that is sent over SMTP
+func (m Message) bytes() ([]byte, error) {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "From: %s\r\n", m.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", m.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", m.Subject)
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+
+	// Only one version of the body
+	if m.HTML == "" || m.Text == "" {
+		contentType, content := "text/plain", m.Text
+		if m.HTML != "" {
+			contentType, content = "text/html", m.HTML
+		}
+		fmt.Fprintf(&buf, "Content-Type: %s; charset=UTF-8\r\n", contentType)
+		fmt.Fprintf(&buf, "Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		if err := writeQuotedPrintable(&buf, content); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	// Both versions, the preferred one last
+	boundary, err := newBoundary()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", boundary)
+	for _, part := range []struct{ contentType, content string }{{"text/plain", m.Text}, {"text/html", m.HTML}} {
+		fmt.Fprintf(&buf, "--%s\r\n", boundary)
+		fmt.Fprintf(&buf, "Content-Type: %s; charset=UTF-8\r\n", part.contentType)
+		fmt.Fprintf(&buf, "Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		if err := writeQuotedPrintable(&buf, part.content); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&buf, "\r\n")
+	}
+	fmt.Fprintf(&buf, "--%s--\r\n", boundary)
+
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(buf *bytes.Buffer, content string) error {
+	w := quotedprintable.NewWriter(buf)
+	if _, err := w.Write([]byte(content)); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+func newBoundary() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// MemorySender keeps the emails in memory instead of sending them. It is meant for the tests.
+type MemorySender struct {
+	// Err, if set, is returned by Send instead of keeping the email
+	Err error
+
+	lock     sync.Mutex
+	messages []Message
+}
+
+// Send implements the Sender interface
+func (s *MemorySender) Send(m Message) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.Err != nil {
+		return s.Err
+	}
+	s.messages = append(s.messages, m)
+	return nil
+}
+
+// Messages returns the emails that have been sent so far
+func (s *MemorySender) Messages() []Message {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]Message{}, s.messages...)
+}
+
+// Template renders the subject and the body of an email. The subject and the text body use text/template,
+// and the HTML body uses html/template so that the data is escaped.
+type Template struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+}
+
+// NewTemplate parses the templates for the subject, the text body and the HTML body. The HTML body is optional.
+func NewTemplate(name, subject, text, html string) (*Template, error) {
+	var t Template
+	var err error
+
+	t.subject, err = texttemplate.New(name + ".subject").Option("missingkey=error").Parse(subject)
+	if err != nil {
+		return nil, err
+	}
+	t.text, err = texttemplate.New(name + ".text").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if html != "" {
+		t.html, err = htmltemplate.New(name + ".html").Option("missingkey=error").Parse(html)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &t, nil
+}
+
+// MustNewTemplate is like NewTemplate but panics if the templates cannot be parsed
+func MustNewTemplate(name, subject, text, html string) *Template {
+	t, err := NewTemplate(name, subject, text, html)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// Render returns the email for the data. From and To are not set.
+func (t *Template) Render(data interface{}) (Message, error) {
+	var m Message
+	var buf bytes.Buffer
+
+	if err := t.subject.Execute(&buf, data); err != nil {
+		return m, err
+	}
+	m.Subject = strings.TrimSpace(buf.String())
+
+	buf.Reset()
+	if err := t.text.Execute(&buf, data); err != nil {
+		return m, err
+	}
+	m.Text = buf.String()
+
+	if t.html != nil {
+		buf.Reset()
+		if err := t.html.Execute(&buf, data); err != nil {
+			return m, err
+		}
+		m.HTML = buf.String()
+	}
+
+	return m, nil
+}
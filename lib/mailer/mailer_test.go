@@ -0,0 +1,158 @@
+package mailer
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplate(t *testing.T) {
+	tmpl, err := NewTemplate("match", "It's a match with {{.Name}}!", "Hi, you and {{.Name}} like each other.", "<p>You and <b>{{.Name}}</b> like each other.</p>")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := tmpl.Render(map[string]string{"Name": "<Jane>"})
+	assert.NoError(t, err)
+	assert.Equal(t, "It's a match with <Jane>!", m.Subject)
+	assert.Equal(t, "Hi, you and <Jane> like each other.", m.Text)
+	// The HTML should be escaped
+	assert.Equal(t, "<p>You and <b>&lt;Jane&gt;</b> like each other.</p>", m.HTML)
+
+	// Missing data should be an error rather than an email with blanks
+	_, err = tmpl.Render(map[string]string{})
+	assert.Error(t, err)
+
+	// Templates that don't parse should be an error
+	_, err = NewTemplate("broken", "{{.Name", "", "")
+	assert.Error(t, err)
+}
+
+func TestMemorySender(t *testing.T) {
+	var s MemorySender
+
+	assert.Error(t, s.Send(Message{From: "app@email.com", To: "jane@email.com"}))
+	assert.Error(t, s.Send(Message{From: "app@email.com", To: "jane@email.com\r\nBcc: x@email.com", Text: "Hi"}))
+	assert.NoError(t, s.Send(Message{From: "app@email.com", To: "jane@email.com", Text: "Hi"}))
+
+	s.Err = fmt.Errorf("server is down")
+	assert.Error(t, s.Send(Message{From: "app@email.com", To: "john@email.com", Text: "Hi"}))
+
+	if assert.Len(t, s.Messages(), 1) {
+		assert.Equal(t, "jane@email.com", s.Messages()[0].To)
+	}
+}
+
+func TestSMTPSender(t *testing.T) {
+	addr, received := startTestSMTPServer(t)
+
+	s := SMTPSender{Addr: addr}
+	err := s.Send(Message{
+		From:    "app@email.com",
+		To:      "jane@email.com",
+		Subject: "Hello",
+		Text:    "Hi Jane",
+		HTML:    "<p>Hi Jane</p>",
+	})
+	assert.NoError(t, err)
+
+	data := <-received
+	assert.Contains(t, data, "MAIL FROM:<app@email.com>")
+	assert.Contains(t, data, "RCPT TO:<jane@email.com>")
+	assert.Contains(t, data, "Subject: Hello")
+	assert.Contains(t, data, "multipart/alternative")
+	assert.Contains(t, data, "Hi Jane")
+	assert.Contains(t, data, "<p>Hi Jane</p>")
+}
+
+func TestSMTPSenderTimeout(t *testing.T) {
+	// A server that accepts the connection but never answers
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		<-stop
+	}()
+
+	s := SMTPSender{Addr: l.Addr().String(), Timeout: 100 * time.Millisecond}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Send(Message{From: "app@email.com", To: "jane@email.com", Text: "Hi Jane"})
+	}()
+	select {
+	case err := <-done:
+		assert.Error(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Send did not give up on a stalled server")
+	}
+}
+
+// startTestSMTPServer starts a minimal SMTP server that accepts one email, and sends the whole session
+// on the returned channel
+func startTestSMTPServer(t *testing.T) (string, <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan string, 1)
+
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var session strings.Builder
+		r := bufio.NewReader(conn)
+		reply := func(s string) { fmt.Fprintf(conn, "%s\r\n", s) }
+
+		reply("220 localhost ready")
+		var inData bool
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				break
+			}
+			session.WriteString(line)
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					reply("250 OK")
+				}
+				continue
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case cmd == "DATA":
+				inData = true
+				reply("354 go ahead")
+			case cmd == "QUIT":
+				reply("221 bye")
+				received <- session.String()
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+		received <- session.String()
+	}()
+
+	return l.Addr().String(), received
+}
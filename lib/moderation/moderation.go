@@ -0,0 +1,210 @@
+package moderation
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Action is what should happen to a text that contains a term from the word list
+type Action int
+
+const (
+	// ActionNone means that the text is fine
+	ActionNone Action = iota
+	// ActionFlag means that the text can be saved, but should be reviewed by a moderator
+	ActionFlag
+	// ActionReject means that the text should not be saved
+	ActionReject
+)
+
+// String implements the fmt.Stringer interface
+func (a Action) String() string {
+	switch a {
+	case ActionNone:
+		return "none"
+	case ActionFlag:
+		return "flag"
+	case ActionReject:
+		return "reject"
+	}
+	return fmt.Sprintf("Action(%d)", int(a))
+}
+
+// Result is the outcome of checking a text against the word list
+type Result struct {
+	// Action is the strictest action of all the matched terms
+	Action Action
+	// Matches are the terms of the word list that were found in the text
+	Matches []string
+}
+
+// WordList maps the (normalized) terms that we look for to the action to be taken when they are found. A term
+// can be a single word or a phrase of multiple words.
+type WordList map[string]Action
+
+// DefaultWordList is used until a word list is loaded. It is deliberately small; a deployment is expected
+// to load its own list using LoadWordList.
+var DefaultWordList = WordList{
+	"kill yourself": ActionReject,
+	"kys":           ActionReject,
+	"idiot":         ActionFlag,
+	"loser":         ActionFlag,
+	"venmo":         ActionFlag,
+	"cashapp":       ActionFlag,
+	"onlyfans":      ActionFlag,
+}
+
+var wordList = DefaultWordList
+var wordListLock sync.RWMutex
+
+// SetWordList replaces the word list used by Check
+func SetWordList(list WordList) {
+	normalized := make(WordList, len(list))
+	for term, action := range list {
+		normalized[strings.Join(tokenize(term), " ")] = action
+	}
+
+	wordListLock.Lock()
+	defer wordListLock.Unlock()
+	wordList = normalized
+}
+
+// LoadWordList reads a word list file and uses it for all the subsequent checks. Every line of the file
+// has an action (`flag` or `reject`) followed by the term, e.g. `reject kill yourself`. Empty lines and
+// lines starting with # are ignored.
+func LoadWordList(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not open the moderation word list: %v", err)
+	}
+	defer f.Close()
+
+	list, err := ParseWordList(f)
+	if err != nil {
+		return err
+	}
+	SetWordList(list)
+	return nil
+}
+
+// ParseWordList parses a word list in the format described in LoadWordList
+func ParseWordList(r io.Reader) (WordList, error) {
+	var list = make(WordList)
+	scanner := bufio.NewScanner(r)
+	var n int
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("moderation word list line %d: expected an action followed by a term", n)
+		}
+		var action Action
+		switch parts[0] {
+		case "flag":
+			action = ActionFlag
+		case "reject":
+			action = ActionReject
+		default:
+			return nil, fmt.Errorf("moderation word list line %d: unknown action '%s'; possible values are flag and reject", n, parts[0])
+		}
+		list[strings.TrimSpace(parts[1])] = action
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// Check looks for the terms of the word list in the text. Words are matched case insensitively, and
+// common character substitutions (e.g. 0 for o) are undone so that they can't be used to get around the list.
+func Check(text string) Result {
+	var res Result
+
+	wordListLock.RLock()
+	defer wordListLock.RUnlock()
+
+	tokens := tokenize(text)
+	for term, action := range wordList {
+		if !containsPhrase(tokens, strings.Split(term, " ")) {
+			continue
+		}
+		res.Matches = append(res.Matches, term)
+		if action > res.Action {
+			res.Action = action
+		}
+	}
+	sort.Strings(res.Matches)
+
+	return res
+}
+
+// Merge combines the results of checking multiple texts
+func (r Result) Merge(other Result) Result {
+	if other.Action > r.Action {
+		r.Action = other.Action
+	}
+	for _, m := range other.Matches {
+		if !contains(r.Matches, m) {
+			r.Matches = append(r.Matches, m)
+		}
+	}
+	return r
+}
+
+// substitutions undoes the common character substitutions
+var substitutions = strings.NewReplacer(
+	"0", "o",
+	"1", "i",
+	"3", "e",
+	"4", "a",
+	"5", "s",
+	"7", "t",
+	"@", "a",
+	"$", "s",
+)
+
+// tokenize splits the text into lowercase words with the substitutions undone
+func tokenize(text string) []string {
+	text = substitutions.Replace(strings.ToLower(text))
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// containsPhrase returns true if the phrase appears as consecutive tokens
+func containsPhrase(tokens []string, phrase []string) bool {
+	for i := 0; i+len(phrase) <= len(tokens); i++ {
+		match := true
+		for j := range phrase {
+			if tokens[i+j] != phrase[j] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package moderation
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheck(t *testing.T) {
+	defer SetWordList(DefaultWordList)
+	SetWordList(WordList{
+		"badword":   ActionReject,
+		"Bad Thing": ActionFlag,
+		"meh":       ActionFlag,
+	})
+
+	tt := []struct {
+		name     string
+		text     string
+		expected Result
+	}{
+		{
+			name:     "clean text should not match",
+			text:     "I like long walks on the beach",
+			expected: Result{Action: ActionNone},
+		},
+		{
+			name:     "words should be matched case insensitively",
+			text:     "That is a BADWORD.",
+			expected: Result{Action: ActionReject, Matches: []string{"badword"}},
+		},
+		{
+			name:     "substituted characters should be matched",
+			text:     "b@dw0rd!",
+			expected: Result{Action: ActionReject, Matches: []string{"badword"}},
+		},
+		{
+			name:     "phrases should be matched across punctuation",
+			text:     "such a bad, thing",
+			expected: Result{Action: ActionFlag, Matches: []string{"bad thing"}},
+		},
+		{
+			name:     "words inside other words should not match",
+			text:     "a mehndi party",
+			expected: Result{Action: ActionNone},
+		},
+		{
+			name:     "the strictest action should win",
+			text:     "meh, badword",
+			expected: Result{Action: ActionReject, Matches: []string{"badword", "meh"}},
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, Check(test.text))
+		})
+	}
+}
+
+func TestParseWordList(t *testing.T) {
+	list, err := ParseWordList(strings.NewReader("# comment\n\nflag meh\nreject kill yourself\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, WordList{"meh": ActionFlag, "kill yourself": ActionReject}, list)
+
+	_, err = ParseWordList(strings.NewReader("ban meh"))
+	assert.Error(t, err)
+	_, err = ParseWordList(strings.NewReader("flag"))
+	assert.Error(t, err)
+}
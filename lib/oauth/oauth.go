@@ -0,0 +1,102 @@
+// Package oauth implements the client side of the OAuth 2.0 authorization code flow with PKCE (RFC 7636),
+// used to let users sign in with an external identity provider. Providers are pluggable: anything that
+// implements Provider can be registered, e.g. an OIDCProvider configured for a vendor, or a LocalProvider
+// in the tests.
+package oauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var ErrUnknownProvider = fmt.Errorf("the identity provider is not supported")
+
+// Identity is the user as known by the identity provider
+type Identity struct {
+	// Subject is the ID of the user at the provider. It never changes, unlike the email.
+	Subject string
+	Email   string
+	// EmailVerified is true if the provider has verified that the user owns the email
+	EmailVerified bool
+	FirstName     string
+	LastName      string
+}
+
+// Provider is an identity provider that users can sign in with
+type Provider interface {
+	// Name identifies the provider in the routes, e.g. `google`
+	Name() string
+	// AuthCodeURL returns the page of the provider where the user signs in. The provider sends the user
+	// back to the client with a code and the state.
+	AuthCodeURL(state, codeChallenge string) string
+	// Exchange trades the code, along with the verifier of its code challenge, for the identity of the user
+	Exchange(code, codeVerifier string) (Identity, error)
+}
+
+var providers = map[string]Provider{}
+var providersLock sync.RWMutex
+
+// Register makes the provider available to sign in with. A provider with the same name is replaced.
+func Register(p Provider) {
+	providersLock.Lock()
+	defer providersLock.Unlock()
+	providers[p.Name()] = p
+}
+
+// Unregister removes the provider with the name, if any
+func Unregister(name string) {
+	providersLock.Lock()
+	defer providersLock.Unlock()
+	delete(providers, name)
+}
+
+// GetProvider returns the registered provider with the name
+func GetProvider(name string) (Provider, error) {
+	providersLock.RLock()
+	defer providersLock.RUnlock()
+	p, ok := providers[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	return p, nil
+}
+
+// Providers returns the names of the registered providers, sorted
+func Providers() []string {
+	providersLock.RLock()
+	defer providersLock.RUnlock()
+	var names = []string{}
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewCodeVerifier returns a random PKCE code verifier
+func NewCodeVerifier() (string, error) {
+	return randomString(32)
+}
+
+// CodeChallenge returns the S256 code challenge of the verifier
+func CodeChallenge(verifier string) string {
+	h := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+// NewState returns a random state, which ties the response of the provider to the request
+func NewState() (string, error) {
+	return randomString(24)
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate random bytes: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
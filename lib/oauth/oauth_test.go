@@ -0,0 +1,123 @@
+package oauth
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodeChallenge(t *testing.T) {
+	// The example from RFC 7636, Appendix B
+	assert.Equal(t, "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"))
+
+	v1, err := NewCodeVerifier()
+	assert.NoError(t, err)
+	v2, err := NewCodeVerifier()
+	assert.NoError(t, err)
+	assert.NotEqual(t, v1, v2)
+	assert.True(t, len(v1) >= 43)
+}
+
+func TestLocalProvider(t *testing.T) {
+	p := NewLocalProvider("local")
+	p.AddIdentity(Identity{Subject: "1", Email: "jon.doe@email.com", EmailVerified: true})
+
+	verifier, err := NewCodeVerifier()
+	assert.NoError(t, err)
+	authURL := p.AuthCodeURL("state", CodeChallenge(verifier))
+
+	_, _, err = p.Authorize(authURL, "2")
+	assert.Error(t, err)
+
+	code, state, err := p.Authorize(authURL, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, "state", state)
+
+	// The code only works with the verifier of its challenge, and only once
+	_, err = p.Exchange(code, verifier+"x")
+	assert.Equal(t, ErrInvalidCode, err)
+
+	code, _, err = p.Authorize(authURL, "1")
+	assert.NoError(t, err)
+	id, err := p.Exchange(code, verifier)
+	assert.NoError(t, err)
+	assert.Equal(t, "jon.doe@email.com", id.Email)
+	_, err = p.Exchange(code, verifier)
+	assert.Equal(t, ErrInvalidCode, err)
+}
+
+func TestOIDCProvider(t *testing.T) {
+	verifier, err := NewCodeVerifier()
+	assert.NoError(t, err)
+
+	// Setup a provider that checks the code and the verifier, like a real one would
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.PostForm.Get("code") != "code" || r.PostForm.Get("code_verifier") != verifier || r.PostForm.Get("client_secret") != "secret" {
+			http.Error(w, `{"error": "invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "access", "token_type": "Bearer"})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"sub": "248289761001", "email": "jane.doe@email.com", "email_verified": "true", "given_name": "Jane"}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// The providers are loaded from the config file
+	dir, err := ioutil.TempDir("", "oauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config := []map[string]interface{}{{
+		"Name":         "example",
+		"ClientID":     "client",
+		"ClientSecret": "secret",
+		"AuthURL":      server.URL + "/authorize",
+		"TokenURL":     server.URL + "/token",
+		"UserInfoURL":  server.URL + "/userinfo",
+		"RedirectURL":  "https://app.example.com/oauth/callback",
+	}}
+	data, err := json.Marshal(config)
+	assert.NoError(t, err)
+	path := filepath.Join(dir, "providers.json")
+	assert.NoError(t, ioutil.WriteFile(path, data, 0600))
+	assert.NoError(t, LoadProviders(path))
+	defer Unregister("example")
+	assert.Contains(t, Providers(), "example")
+
+	p, err := GetProvider("example")
+	assert.NoError(t, err)
+
+	authURL, err := url.Parse(p.AuthCodeURL("state", CodeChallenge(verifier)))
+	assert.NoError(t, err)
+	q := authURL.Query()
+	assert.Equal(t, "code", q.Get("response_type"))
+	assert.Equal(t, "openid email profile", q.Get("scope"))
+	assert.Equal(t, CodeChallenge(verifier), q.Get("code_challenge"))
+	assert.Equal(t, "S256", q.Get("code_challenge_method"))
+
+	id, err := p.Exchange("code", verifier)
+	assert.NoError(t, err)
+	assert.Equal(t, Identity{Subject: "248289761001", Email: "jane.doe@email.com", EmailVerified: true, FirstName: "Jane"}, id)
+
+	_, err = p.Exchange("code", "wrong")
+	assert.Error(t, err)
+
+	_, err = GetProvider("unknown")
+	assert.Equal(t, ErrUnknownProvider, err)
+}
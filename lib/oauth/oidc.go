@@ -0,0 +1,165 @@
+package oauth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultScopes are requested when the provider is not configured with any
+var DefaultScopes = []string{"openid", "email", "profile"}
+
+// OIDCProvider is an OpenID Connect provider: the user is identified through the userinfo endpoint, with
+// the access token that the code is exchanged for. It works with any vendor, given its endpoints.
+type OIDCProvider struct {
+	ProviderName string `json:"Name"`
+	ClientID     string
+	ClientSecret string
+	AuthURL      string
+	TokenURL     string
+	UserInfoURL  string
+	// RedirectURL is the page of the client that the provider sends the user back to
+	RedirectURL string
+	Scopes      []string
+	// Client makes the requests to the provider; http.DefaultClient, with a timeout, is used if it is nil
+	Client *http.Client `json:"-"`
+}
+
+// Name returns the name of the provider
+func (p *OIDCProvider) Name() string {
+	return p.ProviderName
+}
+
+// AuthCodeURL returns the page of the provider where the user signs in
+func (p *OIDCProvider) AuthCodeURL(state, codeChallenge string) string {
+	scopes := p.Scopes
+	if len(scopes) == 0 {
+		scopes = DefaultScopes
+	}
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.RedirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(p.AuthURL, "?") {
+		sep = "&"
+	}
+	return p.AuthURL + sep + q.Encode()
+}
+
+// Exchange trades the code for an access token, and fetches the identity of the user with it
+func (p *OIDCProvider) Exchange(code, codeVerifier string) (Identity, error) {
+	var id Identity
+
+	resp, err := p.client().PostForm(p.TokenURL, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"client_id":     {p.ClientID},
+		"client_secret": {p.ClientSecret},
+		"code_verifier": {codeVerifier},
+	})
+	if err != nil {
+		return id, fmt.Errorf("could not exchange the code with %s: %v", p.Name(), err)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+	}
+	if err = decodeResponse(resp, &token); err != nil {
+		return id, fmt.Errorf("could not exchange the code with %s: %v", p.Name(), err)
+	}
+	if token.AccessToken == "" {
+		return id, fmt.Errorf("could not exchange the code with %s: no access token in the response", p.Name())
+	}
+
+	req, err := http.NewRequest(http.MethodGet, p.UserInfoURL, nil)
+	if err != nil {
+		return id, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	resp, err = p.client().Do(req)
+	if err != nil {
+		return id, fmt.Errorf("could not get the user info from %s: %v", p.Name(), err)
+	}
+	var info struct {
+		Subject    string      `json:"sub"`
+		Email      string      `json:"email"`
+		Verified   interface{} `json:"email_verified"`
+		GivenName  string      `json:"given_name"`
+		FamilyName string      `json:"family_name"`
+	}
+	if err = decodeResponse(resp, &info); err != nil {
+		return id, fmt.Errorf("could not get the user info from %s: %v", p.Name(), err)
+	}
+	if info.Subject == "" {
+		return id, fmt.Errorf("could not get the user info from %s: no subject in the response", p.Name())
+	}
+
+	id = Identity{
+		Subject:   info.Subject,
+		Email:     info.Email,
+		FirstName: info.GivenName,
+		LastName:  info.FamilyName,
+	}
+	// Some providers send the email_verified claim as a string
+	switch v := info.Verified.(type) {
+	case bool:
+		id.EmailVerified = v
+	case string:
+		id.EmailVerified = v == "true"
+	}
+
+	return id, nil
+}
+
+func (p *OIDCProvider) client() *http.Client {
+	if p.Client != nil {
+		return p.Client
+	}
+	return &http.Client{Timeout: 10 * time.Second}
+}
+
+// LoadProviders registers the OIDC providers configured in the JSON file, which holds a list of
+// OIDCProviders
+func LoadProviders(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read the identity providers: %v", err)
+	}
+	var list []*OIDCProvider
+	err = json.Unmarshal(data, &list)
+	if err != nil {
+		return fmt.Errorf("could not parse the identity providers in %s: %v", path, err)
+	}
+
+	for _, p := range list {
+		if p.ProviderName == "" || p.AuthURL == "" || p.TokenURL == "" || p.UserInfoURL == "" || p.RedirectURL == "" {
+			return fmt.Errorf("identity provider '%s' in %s needs a Name, an AuthURL, a TokenURL, a UserInfoURL and a RedirectURL", p.ProviderName, path)
+		}
+		Register(p)
+	}
+
+	return nil
+}
+
+func decodeResponse(resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,146 @@
+// Package pubsub is an in-process publish/subscribe broker for the events that should be pushed to the
+// users in real time. The services publish events for a user without knowing how, or whether, they are
+// delivered; the transports subscribe on behalf of the connected users.
+package pubsub
+
+import (
+	"sync"
+	"time"
+
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/lib/pk"
+)
+
+// The types of events that are published
+const (
+	EventLikeReceived  = "like_received"
+	EventMatch         = "match"
+	EventMessage       = "message"
+	EventProfileViewed = "profile_viewed"
+)
+
+// BufferSize is the number of events that can be queued for a subscriber before new events are dropped
+var BufferSize = 32
+
+// Event is something that happened that a user should know about
+type Event struct {
+	Type string
+	// UserID is the user that the event is meant for
+	UserID   pk.ID
+	Data     interface{}
+	Datetime time.Time
+}
+
+// Handler is a function that is called with every event that is published. It lets the services react
+// to the events of other services, e.g. to send notifications.
+type Handler func(e Event)
+
+// Broker delivers the published events to the subscriptions of the users they are meant for, and to
+// the handlers
+type Broker struct {
+	lock     sync.RWMutex
+	subs     map[pk.ID]map[*Subscription]bool
+	handlers []Handler
+}
+
+// Subscription receives the events for a user. A user can have several subscriptions, e.g. one per device.
+type Subscription struct {
+	// C is where the events are delivered. It is closed when the subscription is closed.
+	C <-chan Event
+
+	c      chan Event
+	userID pk.ID
+	broker *Broker
+	once   sync.Once
+}
+
+// NewBroker creates a Broker with no subscriptions
+func NewBroker() *Broker {
+	return &Broker{subs: make(map[pk.ID]map[*Subscription]bool)}
+}
+
+// Subscribe creates a subscription to the events for the user. It should be closed once it is no longer needed.
+func (b *Broker) Subscribe(userID pk.ID) *Subscription {
+	c := make(chan Event, BufferSize)
+	s := &Subscription{C: c, c: c, userID: userID, broker: b}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.subs[userID] == nil {
+		b.subs[userID] = make(map[*Subscription]bool)
+	}
+	b.subs[userID][s] = true
+
+	return s
+}
+
+// AddHandler registers a Handler. Handlers are called synchronously, in the order they were added, by
+// Publish.
+func (b *Broker) AddHandler(h Handler) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.handlers = append(b.handlers, h)
+}
+
+// Publish calls the handlers with the event, and then delivers it to all the subscriptions of the user.
+// It returns the number of subscriptions the event was delivered to. Delivering never blocks: subscriptions
+// that are too far behind miss the event.
+func (b *Broker) Publish(e Event) int {
+	if e.Datetime.IsZero() {
+		e.Datetime = time.Now()
+	}
+
+	b.lock.RLock()
+	handlers := b.handlers
+	b.lock.RUnlock()
+	for _, h := range handlers {
+		h(e)
+	}
+
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	var n int
+	for s := range b.subs[e.UserID] {
+		select {
+		case s.c <- e:
+			n++
+		default:
+			clog.Warnf("PubSub | Publish(): dropped a %s event for user %d: the subscriber is not keeping up", e.Type, e.UserID)
+		}
+	}
+	return n
+}
+
+// Close removes the subscription from the broker and closes its channel
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		b := s.broker
+		b.lock.Lock()
+		defer b.lock.Unlock()
+
+		delete(b.subs[s.userID], s)
+		if len(b.subs[s.userID]) == 0 {
+			delete(b.subs, s.userID)
+		}
+		close(s.c)
+	})
+}
+
+var defaultBroker = NewBroker()
+
+// Subscribe creates a subscription to the events for the user on the default broker
+func Subscribe(userID pk.ID) *Subscription {
+	return defaultBroker.Subscribe(userID)
+}
+
+// AddHandler registers a Handler on the default broker
+func AddHandler(h Handler) {
+	defaultBroker.AddHandler(h)
+}
+
+// Publish publishes the event on the default broker
+func Publish(e Event) int {
+	return defaultBroker.Publish(e)
+}
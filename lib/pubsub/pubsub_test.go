@@ -0,0 +1,66 @@
+package pubsub
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+)
+
+func init() {
+	clog.LogLevel = 7
+}
+
+func TestBroker(t *testing.T) {
+	b := NewBroker()
+
+	s1 := b.Subscribe(1)
+	s2 := b.Subscribe(1)
+	s3 := b.Subscribe(2)
+
+	// The event should only reach the subscriptions of the user it is meant for
+	n := b.Publish(Event{Type: EventLikeReceived, UserID: 1, Data: "hello"})
+	assert.Equal(t, 2, n)
+	for _, s := range []*Subscription{s1, s2} {
+		e := <-s.C
+		assert.Equal(t, EventLikeReceived, e.Type)
+		assert.Equal(t, "hello", e.Data)
+		assert.False(t, e.Datetime.IsZero())
+	}
+	assert.Len(t, s3.C, 0)
+
+	// Closed subscriptions should not receive anything, and closing twice should be fine
+	s1.Close()
+	s1.Close()
+	_, ok := <-s1.C
+	assert.False(t, ok)
+	assert.Equal(t, 1, b.Publish(Event{Type: EventMatch, UserID: 1}))
+	<-s2.C
+
+	// Publishing should never block on a slow subscriber
+	for i := 0; i < BufferSize+5; i++ {
+		b.Publish(Event{Type: EventMessage, UserID: 2})
+	}
+	assert.Len(t, s3.C, BufferSize)
+
+	s2.Close()
+	s3.Close()
+	assert.Empty(t, b.subs)
+	assert.Equal(t, 0, b.Publish(Event{Type: EventMatch, UserID: 1}))
+}
+
+func TestBrokerHandlers(t *testing.T) {
+	b := NewBroker()
+
+	// Handlers should get every event, even if nobody is subscribed
+	var got []Event
+	b.AddHandler(func(e Event) {
+		got = append(got, e)
+	})
+	b.Publish(Event{Type: EventLikeReceived, UserID: 1})
+	b.Publish(Event{Type: EventMatch, UserID: 2})
+	if assert.Len(t, got, 2) {
+		assert.Equal(t, EventLikeReceived, got[0].Type)
+		assert.Equal(t, EventMatch, got[1].Type)
+	}
+}
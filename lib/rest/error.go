@@ -0,0 +1,142 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/lib/validate"
+)
+
+// ProblemContentType is the content type of the error responses (RFC 7807)
+const ProblemContentType = "application/problem+json"
+
+// The machine-readable codes of the errors. Clients should rely on them rather than on the messages.
+const (
+	CodeInvalidRequest       = "invalid_request"
+	CodeValidationFailed     = "validation_failed"
+	CodeUnauthenticated      = "unauthenticated"
+	CodeInvalidCredentials   = "invalid_credentials"
+	CodeAccessDenied         = "access_denied"
+	CodeNotFound             = "not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeConflict             = "conflict"
+	CodeRequestTooLarge      = "request_too_large"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeTooManyRequests      = "too_many_requests"
+	CodeInternal             = "internal_error"
+)
+
+// statusCodes are the default codes of the statuses
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            CodeInvalidRequest,
+	http.StatusUnauthorized:          CodeUnauthenticated,
+	http.StatusForbidden:             CodeAccessDenied,
+	http.StatusNotFound:              CodeNotFound,
+	http.StatusMethodNotAllowed:      CodeMethodNotAllowed,
+	http.StatusConflict:              CodeConflict,
+	http.StatusRequestEntityTooLarge: CodeRequestTooLarge,
+	http.StatusUnsupportedMediaType:  CodeUnsupportedMediaType,
+	http.StatusUnprocessableEntity:   CodeValidationFailed,
+	http.StatusTooManyRequests:       CodeTooManyRequests,
+	http.StatusInternalServerError:   CodeInternal,
+}
+
+// Error is the body of every error response: an RFC 7807 problem details object, with the code of the
+// error, the fields that failed validation, if any, and the ID of the request, to find it in the logs
+type Error struct {
+	Type      string                `json:"type"`
+	Title     string                `json:"title"`
+	Status    int                   `json:"status"`
+	Detail    string                `json:"detail"`
+	Code      string                `json:"code"`
+	Errors    []validate.FieldError `json:"errors,omitempty"`
+	RequestID string                `json:"request_id,omitempty"`
+}
+
+// NewError creates an error with the status, and the default code for the status
+func NewError(status int, message string) *Error {
+	code, ok := statusCodes[status]
+	if !ok {
+		code = CodeInternal
+		if status < http.StatusInternalServerError {
+			code = CodeInvalidRequest
+		}
+	}
+	return &Error{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: message,
+		Code:   code,
+	}
+}
+
+func (e *Error) Error() string {
+	return e.Detail
+}
+
+// WithCode replaces the code of the error
+func (e *Error) WithCode(code string) *Error {
+	e.Code = code
+	return e
+}
+
+// WithFields adds the fields that failed validation to the error
+func (e *Error) WithFields(fields ...validate.FieldError) *Error {
+	e.Errors = append(e.Errors, fields...)
+	return e
+}
+
+// Write writes the error as the response. The request ID is taken from the response header, which is
+// set by RequestIDMiddleware.
+func (e *Error) Write(w http.ResponseWriter) {
+	e.RequestID = w.Header().Get(RequestIDHeader)
+
+	resp, err := json.Marshal(e)
+	if err != nil {
+		clog.Error(err.Error())
+		resp = []byte(`{"type": "about:blank", "status": 500, "code": "internal_error"}`)
+	}
+
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(e.Status)
+	_, err = w.Write(resp)
+	if err != nil {
+		clog.Error(err.Error())
+	}
+}
+
+// WriteError responds with an error with the status and the message, and the default code for the status.
+// It replaces http.Error in the handlers.
+func WriteError(w http.ResponseWriter, status int, message string) {
+	NewError(status, message).Write(w)
+}
+
+// WriteErrorCode responds with an error with the status, the code and the message
+func WriteErrorCode(w http.ResponseWriter, status int, code string, message string) {
+	NewError(status, message).WithCode(code).Write(w)
+}
+
+// WriteValidationError responds with a 422 for a request that failed validation. If err is a
+// validate.Errors, the fields that failed are listed in the response.
+func WriteValidationError(w http.ResponseWriter, err error) {
+	e := NewError(http.StatusUnprocessableEntity, fmt.Sprintf("There was an error validating the request: %v", err))
+	if errs, ok := err.(validate.Errors); ok {
+		e.WithFields(errs...)
+	}
+	e.Write(w)
+}
+
+// NotFoundHandler responds to the requests that don't match any route
+func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	WriteError(w, http.StatusNotFound, "The requested resource does not exist")
+}
+
+// MethodNotAllowedHandler responds to the requests that match a route, but not its methods
+func MethodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	WriteError(w, http.StatusMethodNotAllowed, "The method is not allowed on this resource")
+}
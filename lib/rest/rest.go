@@ -1,8 +1,13 @@
 package rest
 
 import (
-	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
 	"net/http"
+	"regexp"
+	"strings"
 
 	"github.com/teejays/clog"
 
@@ -31,11 +36,12 @@ var CleanAPIErrMessage = "There was an error processing the request. Please see
 // 	return pk.ID(id), nil
 // }
 
-// AddContextMiddleware is a http.Handler middleware function that logs any request received
+// AddContextMiddleware is a http.Handler middleware function that logs any request received. The context
+// of the request is kept, so that the handlers can tell when the client has gone away.
 func AddContextMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Call the next handler, with request.WithContext()
-		next.ServeHTTP(w, r.WithContext(context.Background()))
+		// Call the next handler
+		next.ServeHTTP(w, r)
 	})
 }
 
@@ -44,9 +50,19 @@ func AuthenticateMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Get the Authenticated Request
 		ar, err := auth.AuthenticateRequest(r)
+		if dErr, ok := err.(*auth.AccessDeniedError); ok {
+			clog.Error(err.Error())
+			WriteError(w, http.StatusForbidden, fmt.Sprintf("Access denied: %v", dErr))
+			return
+		}
+		if err == auth.ErrTokenExpired || err == auth.ErrTokenRevoked {
+			clog.Error(err.Error())
+			WriteError(w, http.StatusUnauthorized, fmt.Sprintf("Could not authenticate the request: %v", err))
+			return
+		}
 		if err != nil {
 			clog.Error(err.Error())
-			w.WriteHeader(http.StatusUnauthorized)
+			WriteError(w, http.StatusUnauthorized, "Could not authenticate the request")
 			return
 		}
 
@@ -55,11 +71,106 @@ func AuthenticateMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// QueryTokenMiddleware lets the auth token be passed in the `access_token` query param, for clients that
+// cannot set the Authorization header (e.g. the browser's EventSource). It should be used before
+// AuthenticateMiddleware, and only on the routes that need it.
+func QueryTokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if token := q.Get("access_token"); token != "" && r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+			// Don't let the token end up in the logs
+			q.Del("access_token")
+			r.URL.RawQuery = q.Encode()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireRole returns a middleware that only lets the request through if the authenticated user has
+// at least one of the roles. It should be used after AuthenticateMiddleware.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			payload, err := auth.GetPayloadFromRequest(r)
+			if err != nil {
+				clog.Error(err.Error())
+				WriteError(w, http.StatusUnauthorized, "Could not authenticate the request")
+				return
+			}
+
+			for _, role := range roles {
+				if payload.HasRole(role) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			clog.Warnf("user %d tried to access %s without any of the roles %v", payload.UserID, r.URL.Path, roles)
+			WriteError(w, http.StatusForbidden, "Access denied: you do not have the permission to access this endpoint")
+		})
+	}
+}
+
+// TrustProxyHeaders makes ClientIP use the X-Forwarded-For header. It should only be set when the server
+// runs behind a proxy that sets the header, since clients can set it to anything otherwise.
+var TrustProxyHeaders = false
+
+// ClientIP returns the IP address that the request comes from
+func ClientIP(r *http.Request) string {
+	if TrustProxyHeaders {
+		// The proxy closest to the server appends the address it got the request from at the end
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			parts := strings.Split(fwd, ",")
+			if ip := strings.TrimSpace(parts[len(parts)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// RequestIDHeader carries the ID of the request, in both the request and the response
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDRegex matches the request IDs that are accepted from the clients or the proxies
+var requestIDRegex = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestIDMiddleware gives every request an ID, which is sent back in the X-Request-ID header and in the
+// error responses, and is logged. An ID set by the client or a proxy is kept if it is sensible. It should
+// wrap the router, so that the requests that don't match any route get an ID too.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !requestIDRegex.MatchString(id) {
+			b := make([]byte, 8)
+			if _, err := rand.Read(b); err != nil {
+				clog.Errorf("could not generate a request ID: %v", err)
+			}
+			id = hex.EncodeToString(b)
+			r.Header.Set(RequestIDHeader, id)
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// GetRequestID returns the ID of the request, set by RequestIDMiddleware
+func GetRequestID(r *http.Request) string {
+	return r.Header.Get(RequestIDHeader)
+}
+
 // LoggerMiddleware is a http.Handler middleware function that logs any request received
 func LoggerMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Log the request
-		clog.Infof("Server: HTTP request received for %s %s", r.Method, r.URL.Path)
+		clog.Infof("Server: HTTP request %s received for %s %s", GetRequestID(r), r.Method, r.URL.Path)
 		// Call the next handler, which can be another middleware in the chain, or the final handler.
 		next.ServeHTTP(w, r)
 	})
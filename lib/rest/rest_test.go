@@ -0,0 +1,234 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/lib/auth"
+	"github.com/teejays/matchapi/lib/validate"
+)
+
+func init() {
+	clog.LogLevel = 7
+}
+
+func TestRequireRole(t *testing.T) {
+
+	// Setup a handler that needs the moderator role
+	var ok = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	h := AuthenticateMiddleware(RequireRole(auth.RoleModerator)(ok))
+
+	tt := []struct {
+		name         string
+		roles        []string
+		expectedCode int
+	}{
+		{
+			name:         "user without roles should be denied",
+			roles:        nil,
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "user with the role should be allowed",
+			roles:        []string{auth.RoleModerator},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "admin should be allowed",
+			roles:        []string{auth.RoleAdmin},
+			expectedCode: http.StatusOK,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			payload, err := auth.NewPayload(1, "jon.doe@email.com", test.roles)
+			if err != nil {
+				t.Fatal(err)
+			}
+			token, err := auth.NewToken(payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req, err := http.NewRequest(http.MethodGet, "/admin/stats", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+			var w = httptest.NewRecorder()
+
+			h.ServeHTTP(w, req)
+
+			assert.Equal(t, test.expectedCode, w.Code)
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/v1/login", nil)
+	r.RemoteAddr = "10.0.0.1:5432"
+	r.Header.Set("X-Forwarded-For", "1.2.3.4, 192.168.0.7")
+
+	// The header is ignored unless the server is behind a proxy
+	assert.Equal(t, "10.0.0.1", ClientIP(r))
+
+	TrustProxyHeaders = true
+	defer func() { TrustProxyHeaders = false }()
+	assert.Equal(t, "192.168.0.7", ClientIP(r))
+}
+
+func TestAuthenticateMiddlewareAPIKey(t *testing.T) {
+
+	// Setup a verifier that knows a single key
+	auth.SetAPIKeyVerifier(func(key string) (auth.TokenPayload, error) {
+		if key != "mk_test" {
+			return auth.TokenPayload{}, fmt.Errorf("invalid key")
+		}
+		return auth.TokenPayload{UserID: 1, Roles: []string{auth.RoleModerator}, APIKeyID: 1, Scopes: []string{"admin/reports:read"}}, nil
+	})
+	defer auth.SetAPIKeyVerifier(nil)
+
+	var ok = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	h := AuthenticateMiddleware(RequireRole(auth.RoleModerator)(ok))
+
+	tt := []struct {
+		name         string
+		method       string
+		path         string
+		key          string
+		expectedCode int
+	}{
+		{
+			name:         "key should be allowed on its scope",
+			method:       http.MethodGet,
+			path:         "/admin/reports",
+			key:          "mk_test",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "key should be allowed under its scope",
+			method:       http.MethodGet,
+			path:         "/admin/reports/12",
+			key:          "mk_test",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "key should be denied writes with a read scope",
+			method:       http.MethodPost,
+			path:         "/admin/reports/12/action",
+			key:          "mk_test",
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "key should be denied outside of its scope",
+			method:       http.MethodGet,
+			path:         "/admin/reportsx",
+			key:          "mk_test",
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "unknown key should be unauthenticated",
+			method:       http.MethodGet,
+			path:         "/admin/reports",
+			key:          "mk_other",
+			expectedCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.path, nil)
+			req.Header.Set("Authorization", "ApiKey "+test.key)
+			var w = httptest.NewRecorder()
+
+			h.ServeHTTP(w, req)
+
+			assert.Equal(t, test.expectedCode, w.Code)
+		})
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	var h = RequestIDMiddleware(AuthenticateMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	// Requests without a token get a problem+json error, with the request ID
+	req := httptest.NewRequest(http.MethodGet, "/v1/user", nil)
+	req.Header.Set(RequestIDHeader, "abc-123")
+	var w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, ProblemContentType, w.Header().Get("Content-Type"))
+	assert.Equal(t, "abc-123", w.Header().Get(RequestIDHeader))
+
+	var e Error
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &e))
+	assert.Equal(t, Error{
+		Type:      "about:blank",
+		Title:     "Unauthorized",
+		Status:    http.StatusUnauthorized,
+		Detail:    "Could not authenticate the request",
+		Code:      CodeUnauthenticated,
+		RequestID: "abc-123",
+	}, e)
+
+	// The field errors are included, with the code
+	w = httptest.NewRecorder()
+	NewError(http.StatusBadRequest, "The request is invalid").
+		WithCode(CodeValidationFailed).
+		WithFields(validate.FieldError{Field: "email", Code: "required", Message: "email cannot be empty"}).
+		Write(w)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"type": "about:blank", "title": "Bad Request", "status": 400, "detail": "The request is invalid", "code": "validation_failed", "errors": [{"field": "email", "code": "required", "message": "email cannot be empty"}]}`, w.Body.String())
+
+	// Validation errors are a 422, with the fields that failed
+	w = httptest.NewRecorder()
+	WriteValidationError(w, validate.Errors{
+		{Field: "Email", Code: validate.CodeRequired, Message: "email cannot be empty"},
+		{Field: "Preferences.MinAge", Code: validate.CodeOutOfRange, Message: "preferred minimum age should be between 18 and 100"},
+	})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.JSONEq(t, `{"type": "about:blank", "title": "Unprocessable Entity", "status": 422, "detail": "There was an error validating the request: email cannot be empty; preferred minimum age should be between 18 and 100", "code": "validation_failed", "errors": [{"field": "Email", "code": "required", "message": "email cannot be empty"}, {"field": "Preferences.MinAge", "code": "out_of_range", "message": "preferred minimum age should be between 18 and 100"}]}`, w.Body.String())
+
+	// Other errors are a 422 without the fields
+	w = httptest.NewRecorder()
+	WriteValidationError(w, fmt.Errorf("the key needs a name"))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.JSONEq(t, `{"type": "about:blank", "title": "Unprocessable Entity", "status": 422, "detail": "There was an error validating the request: the key needs a name", "code": "validation_failed"}`, w.Body.String())
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	var seen string
+	var h = RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = GetRequestID(r)
+	}))
+
+	// Requests get an ID, unless they have a sensible one already
+	for _, id := range []string{"", "bad id\nwith spaces", "trace.id_1-2"} {
+		req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+		req.Header.Set(RequestIDHeader, id)
+		var w = httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		assert.NotEmpty(t, seen)
+		assert.Equal(t, seen, w.Header().Get(RequestIDHeader))
+		if id == "trace.id_1-2" {
+			assert.Equal(t, id, seen)
+		} else {
+			assert.NotEqual(t, id, seen)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// Package totp implements time-based one-time passwords (RFC 6238), as used by authenticator apps, on top
+// of HMAC-based one-time passwords (RFC 4226).
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Digits is the number of digits in a code
+var Digits = 6
+
+// Period is how long each code is valid for
+var Period = 30 * time.Second
+
+// Skew is the number of periods before and after the current one for which the codes are still accepted,
+// to allow for clock drift and slow typing
+var Skew = 1
+
+// SecretSize is the size of the generated secrets in bytes, as recommended by RFC 4226
+const SecretSize = 20
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret generates a random base32 encoded secret
+func NewSecret() (string, error) {
+	b := make([]byte, SecretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// DecodeSecret decodes a base32 secret. It ignores the case, spaces and padding, since users might type it.
+func DecodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.Replace(secret, " ", "", -1))
+	secret = strings.TrimRight(secret, "=")
+	key, err := encoding.DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("the secret is not valid base32: %v", err)
+	}
+	return key, nil
+}
+
+// HOTP returns the HMAC-SHA1 based one-time password for the counter, with the provided number of digits
+func HOTP(key []byte, counter uint64, digits int) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+
+	h := hmac.New(sha1.New, key)
+	h.Write(msg)
+	sum := h.Sum(nil)
+
+	// Dynamic truncation, as described in section 5.3 of RFC 4226
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
+
+// Counter returns the number of periods since the Unix epoch at t
+func Counter(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// Code returns the code for the secret at t
+func Code(secret string, t time.Time) (string, error) {
+	key, err := DecodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return HOTP(key, uint64(Counter(t)), Digits), nil
+}
+
+// Validate checks the code against the secret at t, within the allowed skew. If the code is valid, it
+// returns the counter that it matched, so that the caller can reject codes that have already been used.
+func Validate(secret, code string, t time.Time) (int64, bool) {
+	code = strings.Replace(code, " ", "", -1)
+	if len(code) != Digits {
+		return 0, false
+	}
+	key, err := DecodeSecret(secret)
+	if err != nil {
+		return 0, false
+	}
+
+	current := Counter(t)
+	for i := -Skew; i <= Skew; i++ {
+		counter := current + int64(i)
+		if counter < 0 {
+			continue
+		}
+		expected := HOTP(key, uint64(counter), Digits)
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+	return 0, false
+}
+
+// URI returns the otpauth:// URI for the secret, which authenticator apps can import, usually from a QR code
+func URI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprintf("%d", Digits))
+	v.Set("period", fmt.Sprintf("%d", int(Period/time.Second)))
+
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
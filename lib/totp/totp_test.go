@@ -0,0 +1,85 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// rfcKey is the key used by the test vectors in RFC 4226 and RFC 6238
+var rfcKey = []byte("12345678901234567890")
+
+func TestHOTP(t *testing.T) {
+	// Test vectors from Appendix D of RFC 4226
+	expected := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for i, code := range expected {
+		assert.Equal(t, code, HOTP(rfcKey, uint64(i), 6))
+	}
+}
+
+func TestCode(t *testing.T) {
+	// SHA1 test vectors from Appendix B of RFC 6238
+	tt := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	Digits = 8
+	defer func() { Digits = 6 }()
+
+	secret := base32.StdEncoding.EncodeToString(rfcKey)
+	for _, tc := range tt {
+		code, err := Code(secret, time.Unix(tc.unix, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, tc.code, code, "at %d", tc.unix)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := NewSecret()
+	assert.NoError(t, err)
+	assert.Len(t, secret, 32)
+
+	now := time.Unix(1600000000, 0)
+	code, err := Code(secret, now)
+	assert.NoError(t, err)
+
+	// The code should be accepted for the current period and the ones within the skew
+	counter, ok := Validate(secret, code, now)
+	assert.True(t, ok)
+	assert.Equal(t, Counter(now), counter)
+	_, ok = Validate(secret, code, now.Add(Period))
+	assert.True(t, ok)
+	_, ok = Validate(secret, code, now.Add(-Period))
+	assert.True(t, ok)
+	_, ok = Validate(secret, code, now.Add(2*Period))
+	assert.False(t, ok)
+
+	// Users might type the secret in lower case, with spaces
+	_, ok = Validate(strings.ToLower(secret[:4]+" "+secret[4:]), code, now)
+	assert.True(t, ok)
+
+	_, ok = Validate(secret, "12345", now)
+	assert.False(t, ok)
+	_, ok = Validate("not base32!", code, now)
+	assert.False(t, ok)
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Match API", "jane@email.com", "JBSWY3DPEHPK3PXP")
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/Match%20API:jane@email.com?"), uri)
+	assert.Contains(t, uri, "secret=JBSWY3DPEHPK3PXP")
+	assert.Contains(t, uri, "issuer=Match+API")
+	assert.Contains(t, uri, "digits=6")
+	assert.Contains(t, uri, "period=30")
+}
@@ -0,0 +1,102 @@
+// Package validate collects the validation errors of a request field by field, so that the clients can
+// point the user to the fields that need to be fixed
+package validate
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The codes of the field errors, which tell the clients what is wrong with the field
+const (
+	CodeRequired   = "required"
+	CodeInvalid    = "invalid"
+	CodeTooShort   = "too_short"
+	CodeTooLong    = "too_long"
+	CodeTooMany    = "too_many"
+	CodeOutOfRange = "out_of_range"
+	CodeDuplicate  = "duplicate"
+	CodeNotAllowed = "not_allowed"
+	CodeNotFound   = "not_found"
+)
+
+// FieldError is a field that failed validation. Field is the name of the field in the request, with
+// the path to it for nested fields, e.g. `Preferences.MinAge` or `Prompts[0].Answer`.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	return e.Message
+}
+
+// Errors are all the fields that failed validation
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	var msgs []string
+	for _, fe := range e {
+		msgs = append(msgs, fe.Message)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Validator collects the errors of the rules that fail. The zero value is ready to use.
+type Validator struct {
+	errs Errors
+}
+
+// Add adds an error for the field
+func (v *Validator) Add(field, code, format string, args ...interface{}) {
+	v.errs = append(v.errs, FieldError{Field: field, Code: code, Message: fmt.Sprintf(format, args...)})
+}
+
+// Check adds an error for the field if ok is false. It returns ok, so that the rules that depend on
+// each other can be chained.
+func (v *Validator) Check(ok bool, field, code, format string, args ...interface{}) bool {
+	if !ok {
+		v.Add(field, code, format, args...)
+	}
+	return ok
+}
+
+// Required adds an error for the field if the value is blank
+func (v *Validator) Required(field, value, message string) bool {
+	return v.Check(strings.TrimSpace(value) != "", field, CodeRequired, message)
+}
+
+// Merge adds the errors of a nested validation, under the field. Errors that are not Errors are added
+// as an invalid field.
+func (v *Validator) Merge(field string, err error) {
+	if err == nil {
+		return
+	}
+	errs, ok := err.(Errors)
+	if !ok {
+		v.Add(field, CodeInvalid, "%v", err)
+		return
+	}
+	for _, fe := range errs {
+		if field != "" && fe.Field != "" {
+			fe.Field = field + "." + fe.Field
+		} else if field != "" {
+			fe.Field = field
+		}
+		v.errs = append(v.errs, fe)
+	}
+}
+
+// Errors returns the errors collected so far
+func (v *Validator) Errors() Errors {
+	return v.errs
+}
+
+// Err returns the collected errors as Errors, or nil if all the rules have passed
+func (v *Validator) Err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
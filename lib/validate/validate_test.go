@@ -0,0 +1,38 @@
+package validate
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidator(t *testing.T) {
+	var v Validator
+
+	// Nothing has failed yet
+	assert.NoError(t, v.Err())
+
+	assert.True(t, v.Required("Name", "Jon", "name cannot be empty"))
+	assert.False(t, v.Required("Email", "  ", "email cannot be empty"))
+	assert.True(t, v.Check(5 > 3, "Age", CodeOutOfRange, "age should be more than %d", 3))
+	assert.False(t, v.Check(len("ab") >= 3, "Password", CodeTooShort, "password needs a minimum of %d characters", 3))
+
+	// Nested errors are added under the field, and other errors are an invalid field
+	var nested Validator
+	nested.Add("MinAge", CodeOutOfRange, "preferred minimum age is too low")
+	v.Merge("Preferences", nested.Err())
+	v.Merge("Location", fmt.Errorf("latitude should be between -90 and 90"))
+	v.Merge("", Errors{{Field: "Bio", Code: CodeTooLong, Message: "bio is too long"}})
+	v.Merge("Photos", nil)
+
+	err := v.Err()
+	assert.Equal(t, Errors{
+		{Field: "Email", Code: CodeRequired, Message: "email cannot be empty"},
+		{Field: "Password", Code: CodeTooShort, Message: "password needs a minimum of 3 characters"},
+		{Field: "Preferences.MinAge", Code: CodeOutOfRange, Message: "preferred minimum age is too low"},
+		{Field: "Location", Code: CodeInvalid, Message: "latitude should be between -90 and 90"},
+		{Field: "Bio", Code: CodeTooLong, Message: "bio is too long"},
+	}, err)
+	assert.Equal(t, "email cannot be empty; password needs a minimum of 3 characters; preferred minimum age is too low; latitude should be between -90 and 90; bio is too long", err.Error())
+}
@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/teejays/clog"
@@ -11,7 +12,22 @@ import (
 	"github.com/teejays/matchapi/db"
 	"github.com/teejays/matchapi/handler/v1"
 	handlerV2 "github.com/teejays/matchapi/handler/v2"
+	"github.com/teejays/matchapi/lib/auth"
+	"github.com/teejays/matchapi/lib/blob"
+	"github.com/teejays/matchapi/lib/mailer"
+	"github.com/teejays/matchapi/lib/moderation"
+	"github.com/teejays/matchapi/lib/oauth"
+	"github.com/teejays/matchapi/lib/pubsub"
 	"github.com/teejays/matchapi/lib/rest"
+	"github.com/teejays/matchapi/service/apikey/v1"
+	authV1 "github.com/teejays/matchapi/service/auth/v1"
+	"github.com/teejays/matchapi/service/discover/v1"
+	likeV2 "github.com/teejays/matchapi/service/like/v2"
+	"github.com/teejays/matchapi/service/mail/v1"
+	"github.com/teejays/matchapi/service/notification/v1"
+	"github.com/teejays/matchapi/service/photo/v1"
+	"github.com/teejays/matchapi/service/session/v1"
+	"github.com/teejays/matchapi/service/user/v1"
 )
 
 // listenPort is the port at which the server will listen. It can be
@@ -22,6 +38,82 @@ var listenPort = flag.Int("port", 8080, "port at which the server should listen"
 // Turning it on increases the log level of the logging library we're using
 var verbose = flag.Bool("verbose", false, "verbose mode")
 
+// superLikeQuota is the number of super likes a user can give per day. It can be passed as a
+// command line flag using `--super-like-quota <n>`.
+var superLikeQuota = flag.Int("super-like-quota", likeV2.SuperLikeDailyQuota, "number of super likes a user can give per day")
+
+// likeRateLimit and likeRateWindow configure how many likes a user can give within a rolling window,
+// e.g. `--like-rate-limit 100 --like-rate-window 24h`
+var likeRateLimit = flag.Int("like-rate-limit", likeV2.LikeRateLimit, "number of likes a user can give within the like rate window")
+var likeRateWindow = flag.Duration("like-rate-window", likeV2.LikeRateWindow, "rolling window over which the like rate limit applies")
+
+// minimumAge is the minimum age, in years, required to sign up
+var minimumAge = flag.Int("minimum-age", user.MinimumAge, "minimum age required to sign up")
+
+// discoverRanker is the name of the ranking strategy used to order the discovery feed: `random` or `newest`
+var discoverRanker = flag.String("discover-ranker", discover.RankerName, "ranking strategy for the discovery feed (random, newest)")
+
+// imageDir is the directory where the images uploaded by the users are saved
+var imageDir = flag.String("image-dir", ".data/images", "directory where the uploaded images are saved")
+
+// imageURLSecret signs the URLs of the images. A random secret is generated at startup if it is empty, so
+// the URLs that have been handed out stop working after a restart.
+var imageURLSecret = flag.String("image-url-secret", "", "secret used to sign the image URLs")
+
+// moderationWordList is the path to the word list used to flag or reject abusive profile text. The
+// built-in default list is used if it is empty.
+var moderationWordList = flag.String("moderation-word-list", "", "path to the word list used to moderate profile text")
+
+// adminEmail is the email of a user that is granted the admin role at startup. It is how the first
+// admin is created; after that, admins can grant roles through the API.
+var adminEmail = flag.String("admin-email", "", "email of a user to grant the admin role at startup")
+
+// notificationSink is where the email and push notifications go during development: `log` writes them to
+// the log, and `file` appends them to the file at `--notification-file`. They are not sent if it is empty.
+var notificationSink = flag.String("notification-sink", "", "development sink for the email and push notifications (log, file)")
+var notificationFile = flag.String("notification-file", ".data/notifications.log", "file that the notifications are appended to by the file sink")
+
+// smtpAddr is the host:port of the SMTP server used to send the emails, e.g. a local test server like
+// `localhost:1025`. Emails are only sent if it is set; they stay in the outbox otherwise.
+var smtpAddr = flag.String("smtp-addr", "", "host:port of the SMTP server used to send the emails")
+var smtpUsername = flag.String("smtp-username", "", "username for the SMTP server, if it needs authentication")
+var smtpPassword = flag.String("smtp-password", "", "password for the SMTP server, if it needs authentication")
+var smtpTimeout = flag.Duration("smtp-timeout", mailer.DefaultSMTPTimeout, "how long the SMTP server has to take each email, from connecting to the end")
+var mailFrom = flag.String("mail-from", mail.From, "address that the emails are sent from")
+var mailInterval = flag.Duration("mail-interval", 10*time.Second, "how often the outbox is checked for emails to send")
+
+// requireVerifiedEmail stops the users from liking and discovering other users until they have verified
+// their email. The verification emails link to `--verification-url`, with the token in the query params.
+var requireVerifiedEmail = flag.Bool("require-verified-email", user.RequireVerifiedEmail, "require users to verify their email before liking and discovering")
+var verificationURL = flag.String("verification-url", user.VerificationURL, "link sent to the users to verify their email")
+var verificationResendInterval = flag.Duration("verification-resend-interval", user.VerificationResendInterval, "how long the users have to wait before asking for another verification email")
+
+// verificationSecret signs the email verification tokens. A random secret is generated at startup if it is
+// empty, so it should be set for the links that have been sent to keep working after a restart.
+var verificationSecret = flag.String("verification-secret", "", "secret used to sign the email verification tokens")
+
+// trustProxy makes the server use the X-Forwarded-For header to find the address of the clients, e.g. to
+// throttle the failed logins. It should only be set when the server runs behind a proxy.
+var trustProxy = flag.Bool("trust-proxy", false, "use the X-Forwarded-For header to find the client's IP address")
+var maxFailedLogins = flag.Int("max-failed-logins", authV1.MaxFailedLogins, "number of failed logins after which an account is locked")
+var lockoutDuration = flag.Duration("lockout-duration", authV1.LockoutDuration, "how long an account or an IP address stays locked after too many failed logins")
+
+// jwtAlgorithm is the algorithm that the auth tokens are signed with. The signing key is rotated every
+// `--jwt-rotation-interval`; the previous keys still verify the tokens that they have signed until these
+// expire. The keys are saved in the `--jwt-keyring` file, and the public keys are published at
+// /.well-known/jwks.json.
+var jwtAlgorithm = flag.String("jwt-algorithm", auth.AlgorithmES256, "algorithm that the auth tokens are signed with (HS256, ES256, EdDSA)")
+var jwtRotationInterval = flag.Duration("jwt-rotation-interval", 7*24*time.Hour, "how often the signing key is rotated; 0 disables the rotation")
+var jwtKeyring = flag.String("jwt-keyring", ".data/jwt_keys.json", "file that the signing keys are saved in")
+
+// challengeSecret signs the two-factor challenge tokens given after the password is checked. A random
+// secret is generated at startup if it is empty.
+var challengeSecret = flag.String("challenge-secret", "", "secret used to sign the two-factor challenge tokens")
+
+// oauthProviders is the path to a JSON file that configures the OpenID Connect providers that users can
+// sign in with. Social login is off if it is empty.
+var oauthProviders = flag.String("oauth-providers", "", "path to the JSON file that configures the identity providers")
+
 func main() {
 	var err error
 
@@ -34,12 +126,102 @@ func main() {
 		clog.LogLevel = 1
 	}
 
+	// Configure the services based on the flags
+	likeV2.SuperLikeDailyQuota = *superLikeQuota
+	likeV2.LikeRateLimit = *likeRateLimit
+	likeV2.LikeRateWindow = *likeRateWindow
+	user.MinimumAge = *minimumAge
+	user.RequireVerifiedEmail = *requireVerifiedEmail
+	user.VerificationURL = *verificationURL
+	user.VerificationResendInterval = *verificationResendInterval
+	if *verificationSecret != "" {
+		user.VerificationSecretKey = *verificationSecret
+	}
+	rest.TrustProxyHeaders = *trustProxy
+	authV1.MaxFailedLogins = *maxFailedLogins
+	authV1.LockoutDuration = *lockoutDuration
+	if *challengeSecret != "" {
+		authV1.ChallengeSecretKey = *challengeSecret
+	}
+	if _, err = discover.GetRanker(*discoverRanker); err != nil {
+		clog.FatalErr(err)
+	}
+	discover.RankerName = *discoverRanker
+	if *oauthProviders != "" {
+		if err = oauth.LoadProviders(*oauthProviders); err != nil {
+			clog.FatalErr(err)
+		}
+	}
+	if *moderationWordList != "" {
+		if err = moderation.LoadWordList(*moderationWordList); err != nil {
+			clog.FatalErr(err)
+		}
+	}
+
 	// Initialize the database: Consult the README for more details
 	err = db.InitDB()
 	if err != nil {
 		clog.FatalErr(err)
 	}
 
+	// Grant the admin role, if requested
+	if *adminEmail != "" {
+		err = grantAdmin(*adminEmail)
+		if err != nil {
+			clog.FatalErr(err)
+		}
+	}
+
+	// Load the keys that sign the auth tokens, and rotate them when they are due
+	keyring, err := auth.LoadKeyring(*jwtKeyring)
+	if err != nil {
+		clog.FatalErr(err)
+	}
+	if _, err = keyring.RotateIfDue(*jwtAlgorithm, *jwtRotationInterval, time.Now()); err != nil {
+		clog.FatalErr(err)
+	}
+	auth.DefaultKeyring = keyring
+	stopRotation := keyring.StartRotation(*jwtAlgorithm, *jwtRotationInterval)
+	defer stopRotation()
+
+	// Tokens stop working as soon as their session is revoked
+	auth.AddPayloadValidator(session.ValidateTokenPayload)
+	// Suspended and banned users should be locked out even if they hold a valid token, and so should
+	// tokens with roles that have since been revoked
+	auth.AddPayloadValidator(user.ValidateTokenPayload)
+	// The internal tools can use API keys instead of logging in
+	auth.SetAPIKeyVerifier(apikey.Verify)
+
+	// Turn the likes and matches into notifications
+	pubsub.AddHandler(notification.HandleEvent)
+	if *notificationSink != "" {
+		for _, name := range []string{notification.ChannelEmail, notification.ChannelPush} {
+			c, err := notification.NewSink(*notificationSink, name, *notificationFile)
+			if err != nil {
+				clog.FatalErr(err)
+			}
+			notification.RegisterChannel(name, c)
+		}
+	}
+
+	// Send the emails in the outbox through SMTP, including the email notifications
+	mail.From = *mailFrom
+	if *smtpAddr != "" {
+		mail.Sender = mailer.SMTPSender{Addr: *smtpAddr, Username: *smtpUsername, Password: *smtpPassword, Timeout: *smtpTimeout}
+		notification.RegisterChannel(notification.ChannelEmail, mail.NotificationChannel{})
+		stopMail := mail.StartWorker(*mailInterval)
+		defer stopMail()
+	}
+
+	// Initialize the storage for the images uploaded by the users
+	if *imageURLSecret != "" {
+		blob.URLSigningKey = *imageURLSecret
+	}
+	photo.Store, err = blob.NewFileStore(*imageDir)
+	if err != nil {
+		clog.FatalErr(err)
+	}
+
 	// Initialize & start the webserver
 	err = initServer(*listenPort)
 	if err != nil {
@@ -47,6 +229,27 @@ func main() {
 	}
 }
 
+// grantAdmin grants the admin role to the user with the email
+func grantAdmin(email string) error {
+	creds, err := user.GetUserCredsByEmail(email)
+	if err != nil {
+		return err
+	}
+	if len(creds) != 1 {
+		return fmt.Errorf("could not grant the admin role: expected one user with the email %s, found %d", email, len(creds))
+	}
+
+	u, err := user.GetUserByID(creds[0].ID)
+	if err != nil {
+		return err
+	}
+	if u.HasRole(auth.RoleAdmin) {
+		return nil
+	}
+
+	return u.SetRoles(append(u.Roles, auth.RoleAdmin))
+}
+
 // initServer setups and star the webserver
 func initServer(port int) error {
 
@@ -62,8 +265,11 @@ func initServer(port int) error {
 // registerHandlers setups the routes and middleware for the webserver
 func registerHandlers() {
 
-	// Create a new gorilla.mux router
+	// Create a new gorilla.mux router, which responds with JSON errors to the requests that don't match
+	// any route
 	r := mux.NewRouter()
+	r.NotFoundHandler = http.HandlerFunc(rest.NotFoundHandler)
+	r.MethodNotAllowedHandler = http.HandlerFunc(rest.MethodNotAllowedHandler)
 
 	// Setup middlewares
 	r.Use(rest.LoggerMiddleware)
@@ -77,9 +283,21 @@ func registerHandlers() {
 	// 1. Unauthenticated Routes: We are going to go ahead and deal with pseudo-authenticated
 	// routes but first, let's create routes that do no need any authentication
 	// - Unauthenticated V1:
+	r.HandleFunc("/.well-known/jwks.json", handler.HandleGetJWKS).Methods(http.MethodGet)
 	rv1 := r.PathPrefix("/v1").Subrouter()
 	rv1.HandleFunc("/user", handler.HandleCreateUser).Methods(http.MethodPost)
 	rv1.HandleFunc("/login", handler.HandleLogin).Methods(http.MethodPost)
+	rv1.HandleFunc("/login/2fa", handler.HandleLoginTwoFactor).Methods(http.MethodPost)
+	rv1.HandleFunc("/oauth", handler.HandleGetOAuthProviders).Methods(http.MethodGet)
+	rv1.HandleFunc("/oauth/{provider}/authorize", handler.HandleBeginOAuthLogin).Methods(http.MethodPost)
+	rv1.HandleFunc("/oauth/{provider}/login", handler.HandleOAuthLogin).Methods(http.MethodPost)
+	rv1.HandleFunc("/user/verify", handler.HandleVerifyEmail).Methods(http.MethodPost)
+	rv1.HandleFunc("/image/{key}", handler.HandleGetImage).Methods(http.MethodGet)
+
+	// - The event stream is authenticated too, but since browsers cannot set headers on it, the token
+	// can also be passed in the query params
+	events := rest.QueryTokenMiddleware(rest.AuthenticateMiddleware(http.HandlerFunc(handlerV2.HandleGetEvents)))
+	r.Handle("/v2/events", events).Methods(http.MethodGet)
 
 	// 2. Authenticated Routes: These routes will run a middleware authentication function
 	a := r.PathPrefix("").Subrouter()
@@ -89,15 +307,64 @@ func registerHandlers() {
 	av1 := a.PathPrefix("/v1").Subrouter()
 	av1.HandleFunc("/user", handler.HandleGetUser).Methods(http.MethodGet)
 	av1.HandleFunc("/user", handler.HandleUpdateUserProfile).Methods(http.MethodPut)
+	av1.HandleFunc("/user/preferences", handler.HandleUpdateUserPreferences).Methods(http.MethodPut)
+	av1.HandleFunc("/user/verify/resend", handler.HandleResendVerificationEmail).Methods(http.MethodPost)
+	av1.HandleFunc("/user/2fa", handler.HandleBeginTwoFactorEnrollment).Methods(http.MethodPost)
+	av1.HandleFunc("/user/2fa/confirm", handler.HandleConfirmTwoFactorEnrollment).Methods(http.MethodPost)
+	av1.HandleFunc("/user/2fa", handler.HandleDisableTwoFactor).Methods(http.MethodDelete)
+	av1.HandleFunc("/user/images", handler.HandleGetUserImages).Methods(http.MethodGet)
+	av1.HandleFunc("/user/images", handler.HandlePostUserImage).Methods(http.MethodPost)
+	av1.HandleFunc("/user/images", handler.HandleReorderUserImages).Methods(http.MethodPut)
+	av1.HandleFunc("/user/images/{key}", handler.HandleDeleteUserImage).Methods(http.MethodDelete)
+	av1.HandleFunc("/user/{id:[0-9]+}", handler.HandleGetUserByID).Methods(http.MethodGet)
+	av1.HandleFunc("/sessions", handler.HandleGetSessions).Methods(http.MethodGet)
+	av1.HandleFunc("/sessions/{id:[0-9]+}", handler.HandleDeleteSession).Methods(http.MethodDelete)
+	av1.HandleFunc("/block", handler.HandleGetBlocks).Methods(http.MethodGet)
+	av1.HandleFunc("/block", handler.HandlePostBlock).Methods(http.MethodPost)
+	av1.HandleFunc("/block/{id:[0-9]+}", handler.HandleDeleteBlock).Methods(http.MethodDelete)
+	av1.HandleFunc("/report", handler.HandlePostReport).Methods(http.MethodPost)
 	av1.HandleFunc("/like/incoming", handler.HandleGetIncomingLikes).Methods("GET")
+	av1.HandleFunc("/notifications", handler.HandleGetNotifications).Methods(http.MethodGet)
+	av1.HandleFunc("/notifications/read", handler.HandleMarkAllNotificationsRead).Methods(http.MethodPut)
+	av1.HandleFunc("/notifications/{id:[0-9]+}/read", handler.HandleMarkNotificationRead).Methods(http.MethodPut)
+	av1.HandleFunc("/notifications/preferences", handler.HandleGetNotificationPreferences).Methods(http.MethodGet)
+	av1.HandleFunc("/notifications/preferences", handler.HandleUpdateNotificationPreferences).Methods(http.MethodPut)
+
+	// - Admin; only the users with the admin or moderator role can access these routes
+	ad := a.PathPrefix("/admin").Subrouter()
+	ad.Use(rest.RequireRole(auth.RoleAdmin, auth.RoleModerator))
+	ad.HandleFunc("/stats", handler.HandleGetStats).Methods(http.MethodGet)
+	ad.Handle("/audit", rest.RequireRole(auth.RoleAdmin)(http.HandlerFunc(handler.HandleGetAuditLog))).Methods(http.MethodGet)
+	ad.HandleFunc("/users", handler.HandleSearchAdminUsers).Methods(http.MethodGet)
+	ad.HandleFunc("/users/{id:[0-9]+}", handler.HandleGetAdminUser).Methods(http.MethodGet)
+	ad.HandleFunc("/users/{id:[0-9]+}/suspension", handler.HandleSuspendUser).Methods(http.MethodPost)
+	ad.HandleFunc("/users/{id:[0-9]+}/suspension", handler.HandleUnsuspendUser).Methods(http.MethodDelete)
+	ad.Handle("/users/{id:[0-9]+}/roles", rest.RequireRole(auth.RoleAdmin)(http.HandlerFunc(handler.HandleUpdateUserRoles))).Methods(http.MethodPut)
+	ad.Handle("/api-keys", rest.RequireRole(auth.RoleAdmin)(http.HandlerFunc(handler.HandleGetAPIKeys))).Methods(http.MethodGet)
+	ad.Handle("/api-keys", rest.RequireRole(auth.RoleAdmin)(http.HandlerFunc(handler.HandleCreateAPIKey))).Methods(http.MethodPost)
+	ad.Handle("/api-keys/{id:[0-9]+}", rest.RequireRole(auth.RoleAdmin)(http.HandlerFunc(handler.HandleDeleteAPIKey))).Methods(http.MethodDelete)
+	ad.HandleFunc("/reports", handler.HandleGetOpenReports).Methods(http.MethodGet)
+	ad.HandleFunc("/reports/{id:[0-9]+}", handler.HandleGetReport).Methods(http.MethodGet)
+	ad.HandleFunc("/reports/{id:[0-9]+}/action", handler.HandlePostReportAction).Methods(http.MethodPost)
+	ad.HandleFunc("/moderation", handler.HandleGetFlaggedProfiles).Methods(http.MethodGet)
+	ad.HandleFunc("/moderation/{id:[0-9]+}", handler.HandleReviewFlaggedProfile).Methods(http.MethodPost)
 
 	// - Authenticated V2; Create a path that takes v2 as prefix
 	av2 := a.PathPrefix("/v2").Subrouter()
 	av2.HandleFunc("/like/incoming", handlerV2.HandleGetIncomingLikes).Methods("GET")
 	av2.HandleFunc("/like", handlerV2.HandlePostLike).Methods("POST")
+	av2.HandleFunc("/like/quota", handlerV2.HandleGetLikeQuota).Methods("GET")
+	av2.HandleFunc("/pass", handlerV2.HandlePostPass).Methods("POST")
+	av2.HandleFunc("/discover", handlerV2.HandleGetDiscover).Methods("GET")
+	av2.HandleFunc("/conversation", handlerV2.HandleGetConversations).Methods("GET")
+	av2.HandleFunc("/conversation", handlerV2.HandlePostConversation).Methods("POST")
+	av2.HandleFunc("/conversation/{id:[0-9]+}/message", handlerV2.HandleGetMessages).Methods("GET")
+	av2.HandleFunc("/conversation/{id:[0-9]+}/message", handlerV2.HandlePostMessage).Methods("POST")
+	av2.HandleFunc("/conversation/{id:[0-9]+}/read", handlerV2.HandleMarkConversationRead).Methods("PUT")
 
 	// Register the router as the handler in the standard net/http package
 	// Add a simple middleware function so we can log the requests
-	http.Handle("/", r)
+	// Every request gets an ID, including the ones that don't match any route
+	http.Handle("/", rest.RequestIDMiddleware(r))
 
 }
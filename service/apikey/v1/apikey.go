@@ -0,0 +1,335 @@
+package apikey
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/db"
+	"github.com/teejays/matchapi/lib/auth"
+	"github.com/teejays/matchapi/lib/pk"
+	"github.com/teejays/matchapi/service/audit/v1"
+	"github.com/teejays/matchapi/service/user/v1"
+)
+
+// KeyPrefix starts every API key, so that they are easy to recognize, e.g. by secret scanners
+const KeyPrefix = "mk_"
+
+// prefixSize and secretSize are the number of random bytes in the visible prefix and the secret part
+// of the keys
+const prefixSize = 4
+const secretSize = 24
+
+// MaxNameLength is the maximum length of the name of a key
+const MaxNameLength = 100
+
+// DefaultLifespan is how long the keys are valid for when no expiry is given, and MaxLifespan is the
+// longest that they can be valid for
+var DefaultLifespan = 90 * 24 * time.Hour
+var MaxLifespan = 365 * 24 * time.Hour
+
+// SecretKey is used to hash the keys before they are saved
+var SecretKey = "Keys to the kingdom"
+
+// LastUsedResolution is how often the last used time of a key is updated, so that every request
+// doesn't write to the database
+var LastUsedResolution = time.Minute
+
+var ErrAPIKeyNotFound = fmt.Errorf("the API key does not exist")
+var ErrInvalidAPIKey = fmt.Errorf("the API key is invalid, expired or revoked")
+var ErrInvalidUser = fmt.Errorf("the user does not exist")
+
+// APIKey lets the internal tools call the API on behalf of a user, but only on the routes that the
+// key has a scope for
+type APIKey struct {
+	ID   pk.ID
+	Name string
+	// Prefix is the part of the key that is kept in the clear, so that it can be told apart from the others
+	Prefix string
+	// UserID is the user that the requests made with the key act as. The key never has more access
+	// than the user.
+	UserID    pk.ID
+	Scopes    []string
+	CreatedBy pk.ID
+	IsRevoked bool
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	// LastUsedAt is empty if the key has never been used
+	LastUsedAt time.Time
+}
+
+// record is how the keys are saved: only the hash of the secret part is kept
+type record struct {
+	APIKey `mapstructure:",squash"`
+	Hash   []byte
+}
+
+// NewAPIKeyRequest is the request used by the admins to create an API key
+type NewAPIKeyRequest struct {
+	Name string
+	// UserID is the user that the key acts as; it defaults to the admin creating the key
+	UserID pk.ID
+	Scopes []string
+	// ExpiresInDays defaults to the DefaultLifespan
+	ExpiresInDays int
+}
+
+// NewAPIKeyResponse has the key itself, which is only ever shown once
+type NewAPIKeyResponse struct {
+	APIKey
+	Key string
+}
+
+// Validate returns an error if the request is not valid
+func (req NewAPIKeyRequest) Validate() error {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return fmt.Errorf("the key needs a name")
+	}
+	if len([]rune(name)) > MaxNameLength {
+		return fmt.Errorf("the name can be at most %d characters long", MaxNameLength)
+	}
+	if len(req.Scopes) < 1 {
+		return fmt.Errorf("the key needs at least one scope")
+	}
+	if err := auth.ValidateScopes(req.Scopes); err != nil {
+		return err
+	}
+	if req.ExpiresInDays < 0 || time.Duration(req.ExpiresInDays)*24*time.Hour > MaxLifespan {
+		return fmt.Errorf("ExpiresInDays should be between 1 and %d", int(MaxLifespan.Hours()/24))
+	}
+	return nil
+}
+
+// New creates an API key, at t. The request should have been validated.
+func New(req NewAPIKeyRequest, createdBy pk.ID, t time.Time) (NewAPIKeyResponse, error) {
+	var resp NewAPIKeyResponse
+
+	if req.UserID == 0 {
+		req.UserID = createdBy
+	}
+	u, err := user.GetUserByID(req.UserID)
+	if db.IsNotExist(err) {
+		return resp, ErrInvalidUser
+	}
+	if err != nil {
+		return resp, err
+	}
+	if u.IsDeleted {
+		return resp, ErrInvalidUser
+	}
+
+	lifespan := DefaultLifespan
+	if req.ExpiresInDays > 0 {
+		lifespan = time.Duration(req.ExpiresInDays) * 24 * time.Hour
+	}
+
+	prefix, err := randomBytes(prefixSize)
+	if err != nil {
+		return resp, err
+	}
+	secret, err := randomBytes(secretSize)
+	if err != nil {
+		return resp, err
+	}
+	r := record{
+		APIKey: APIKey{
+			Name:      strings.TrimSpace(req.Name),
+			Prefix:    hex.EncodeToString(prefix),
+			UserID:    req.UserID,
+			Scopes:    req.Scopes,
+			CreatedBy: createdBy,
+			CreatedAt: t,
+			ExpiresAt: t.Add(lifespan),
+		},
+	}
+	key := KeyPrefix + r.Prefix + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	r.Hash, err = auth.GetHash(key, SecretKey)
+	if err != nil {
+		return resp, err
+	}
+
+	id, err := db.SaveNewEntity(db.APIKeyCollection, &r)
+	if err != nil {
+		return resp, err
+	}
+	r.ID = id
+
+	_, err = audit.Record(audit.Entry{
+		Event:  audit.EventAPIKeyCreated,
+		UserID: r.UserID,
+		Detail: fmt.Sprintf("API key %d (%s) created by user %d with the scopes %s", id, r.Name, createdBy, strings.Join(r.Scopes, ", ")),
+	})
+	if err != nil {
+		clog.Errorf("APIKey | New(): could not record the audit entry: %v", err)
+	}
+
+	return NewAPIKeyResponse{APIKey: r.APIKey, Key: key}, nil
+}
+
+// GetAPIKeys returns the keys that haven't been revoked, the newest first
+func GetAPIKeys() ([]APIKey, error) {
+	records, err := getRecordsByQuery("IsRevoked:false")
+	if err != nil {
+		return nil, err
+	}
+
+	var keys = []APIKey{}
+	for _, r := range records {
+		keys = append(keys, r.APIKey)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.After(keys[j].CreatedAt)
+	})
+
+	return keys, nil
+}
+
+// lastUsedLock makes sure the read-modify-write of the last used time doesn't undo a revocation
+var lastUsedLock sync.Mutex
+
+// Revoke revokes the key, by the admin revokedBy. The key stops working straight away.
+func Revoke(id pk.ID, revokedBy pk.ID) error {
+	lastUsedLock.Lock()
+	defer lastUsedLock.Unlock()
+
+	var r record
+	err := db.GetEntityByID(db.APIKeyCollection, id, &r)
+	if db.IsNotExist(err) {
+		return ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if r.IsRevoked {
+		return ErrAPIKeyNotFound
+	}
+
+	r.IsRevoked = true
+	err = db.SaveEntityByID(db.APIKeyCollection, id, r)
+	if err != nil {
+		return err
+	}
+
+	_, err = audit.Record(audit.Entry{
+		Event:  audit.EventAPIKeyRevoked,
+		UserID: r.UserID,
+		Detail: fmt.Sprintf("API key %d (%s) revoked by user %d", id, r.Name, revokedBy),
+	})
+	if err != nil {
+		clog.Errorf("APIKey | Revoke(): could not record the audit entry: %v", err)
+	}
+
+	return nil
+}
+
+// Verify checks the key and returns the payload that the requests made with it are authenticated
+// with. It keeps track of when the key was last used. It is meant to be registered as an
+// auth.APIKeyVerifier.
+func Verify(key string) (auth.TokenPayload, error) {
+	var payload auth.TokenPayload
+
+	// The key looks like mk_<prefix>_<secret>
+	parts := strings.SplitN(strings.TrimPrefix(key, KeyPrefix), "_", 2)
+	if !strings.HasPrefix(key, KeyPrefix) || len(parts) != 2 || len(parts[0]) != 2*prefixSize {
+		return payload, ErrInvalidAPIKey
+	}
+	if _, err := hex.DecodeString(parts[0]); err != nil {
+		return payload, ErrInvalidAPIKey
+	}
+
+	h, err := auth.GetHash(key, SecretKey)
+	if err != nil {
+		return payload, err
+	}
+
+	lastUsedLock.Lock()
+	defer lastUsedLock.Unlock()
+
+	records, err := getRecordsByQuery(fmt.Sprintf("Prefix:%s", parts[0]))
+	if err != nil {
+		return payload, err
+	}
+	var r *record
+	for i := range records {
+		if auth.IsEqualHash(records[i].Hash, h) {
+			r = &records[i]
+			break
+		}
+	}
+	now := time.Now()
+	if r == nil || r.IsRevoked || !now.Before(r.ExpiresAt) {
+		return payload, ErrInvalidAPIKey
+	}
+
+	// The key acts as the user, with their current roles
+	u, err := user.GetUserByID(r.UserID)
+	if db.IsNotExist(err) {
+		return payload, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return payload, err
+	}
+	if u.IsDeleted {
+		return payload, ErrInvalidAPIKey
+	}
+
+	if now.Sub(r.LastUsedAt) >= LastUsedResolution {
+		r.LastUsedAt = now
+		err = db.SaveEntityByID(db.APIKeyCollection, r.ID, *r)
+		if err != nil {
+			return payload, err
+		}
+	}
+
+	payload = auth.TokenPayload{
+		UserID:   u.ID,
+		Email:    u.Email,
+		Roles:    u.Roles,
+		APIKeyID: r.ID,
+		Scopes:   r.Scopes,
+	}
+
+	return payload, nil
+}
+
+// getRecordsByQuery returns the keys that match the query. They are fetched by ID, since the hashes
+// don't survive the decoding of the query results.
+func getRecordsByQuery(query string) ([]record, error) {
+	result, err := db.Query(db.APIKeyCollection, query)
+	if err != nil {
+		return nil, err
+	}
+	var keys []APIKey
+	err = db.DecodeQueryResult(result, &keys)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []record
+	for _, k := range keys {
+		var r record
+		err = db.GetEntityByID(db.APIKeyCollection, k.ID, &r)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+
+	return records, nil
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("could not generate random bytes: %v", err)
+	}
+	return b, nil
+}
@@ -0,0 +1,133 @@
+package apikey
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/db"
+	"github.com/teejays/matchapi/lib/auth"
+	"github.com/teejays/matchapi/service/user/v1"
+)
+
+func init() {
+	clog.LogLevel = 7
+}
+
+func TestAPIKeys(t *testing.T) {
+
+	// Initialize the mock DB client
+	err := db.InitMockClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.DestoryMockClient()
+
+	// Populate the User mock data in DB
+	err = user.HelperPopulateMockData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Invalid requests are rejected
+	assert.Error(t, NewAPIKeyRequest{Name: " ", Scopes: []string{"admin/reports:read"}}.Validate())
+	assert.Error(t, NewAPIKeyRequest{Name: "Dashboard"}.Validate())
+	assert.Error(t, NewAPIKeyRequest{Name: "Dashboard", Scopes: []string{"/admin/reports"}}.Validate())
+	assert.Error(t, NewAPIKeyRequest{Name: "Dashboard", Scopes: []string{"admin/reports:read"}, ExpiresInDays: 1000}.Validate())
+
+	req := NewAPIKeyRequest{Name: "Dashboard", Scopes: []string{"admin/reports:read"}}
+	assert.NoError(t, req.Validate())
+	_, err = New(NewAPIKeyRequest{Name: "Dashboard", UserID: 12345, Scopes: req.Scopes}, 1, time.Now())
+	assert.Equal(t, ErrInvalidUser, err)
+
+	// The key acts as the admin who created it by default
+	now := time.Now()
+	resp, err := New(req, 1, now)
+	assert.NoError(t, err)
+	assert.Equal(t, KeyPrefix+resp.Prefix+"_", resp.Key[:len(KeyPrefix)+len(resp.Prefix)+1])
+	assert.Equal(t, now.Add(DefaultLifespan), resp.ExpiresAt)
+
+	payload, err := Verify(resp.Key)
+	assert.NoError(t, err)
+	assert.Equal(t, resp.ID, payload.APIKeyID)
+	assert.EqualValues(t, 1, payload.UserID)
+	assert.Equal(t, req.Scopes, payload.Scopes)
+
+	// The last used time is recorded, and the hash is never listed
+	keys, err := GetAPIKeys()
+	assert.NoError(t, err)
+	if assert.Len(t, keys, 1) {
+		assert.Equal(t, resp.ID, keys[0].ID)
+		assert.False(t, keys[0].LastUsedAt.IsZero())
+	}
+
+	// Wrong, expired and revoked keys are rejected
+	_, err = Verify(resp.Key + "x")
+	assert.Equal(t, ErrInvalidAPIKey, err)
+	_, err = Verify("Bearer " + resp.Key)
+	assert.Equal(t, ErrInvalidAPIKey, err)
+
+	expired, err := New(NewAPIKeyRequest{Name: "Old", UserID: 2, Scopes: req.Scopes, ExpiresInDays: 1}, 1, now.Add(-48*time.Hour))
+	assert.NoError(t, err)
+	_, err = Verify(expired.Key)
+	assert.Equal(t, ErrInvalidAPIKey, err)
+
+	assert.NoError(t, Revoke(resp.ID, 1))
+	_, err = Verify(resp.Key)
+	assert.Equal(t, ErrInvalidAPIKey, err)
+	assert.Equal(t, ErrAPIKeyNotFound, Revoke(resp.ID, 1))
+
+	keys, err = GetAPIKeys()
+	assert.NoError(t, err)
+	if assert.Len(t, keys, 1) {
+		assert.Equal(t, expired.ID, keys[0].ID)
+	}
+}
+
+func TestVerifySecretWithUnderscore(t *testing.T) {
+
+	// Initialize the mock DB client
+	err := db.InitMockClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.DestoryMockClient()
+
+	// Populate the User mock data in DB
+	err = user.HelperPopulateMockData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// base64url secrets can have underscores, so only the first one after the prefix separates the parts
+	key := KeyPrefix + "0a1b2c3d_" + "Zm9v_YmFy_YmF6-cXV4_MTIzNDU2Nzg5MA"
+	r := record{
+		APIKey: APIKey{
+			Name:      "Dashboard",
+			Prefix:    "0a1b2c3d",
+			UserID:    1,
+			Scopes:    []string{"admin/reports:read"},
+			CreatedBy: 1,
+			CreatedAt: time.Now(),
+			ExpiresAt: time.Now().Add(DefaultLifespan),
+		},
+	}
+	r.Hash, err = auth.GetHash(key, SecretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := db.SaveNewEntity(db.APIKeyCollection, &r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := Verify(key)
+	assert.NoError(t, err)
+	assert.Equal(t, id, payload.APIKeyID)
+	assert.EqualValues(t, 1, payload.UserID)
+
+	_, err = Verify(KeyPrefix + "0a1b2c3d_" + "Zm9v_YmFy_YmF6-cXV4_MTIzNDU2Nzg5MB")
+	assert.Equal(t, ErrInvalidAPIKey, err)
+}
@@ -0,0 +1,95 @@
+package audit
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/db"
+	"github.com/teejays/matchapi/lib/pk"
+)
+
+// The security events that are recorded in the audit log
+const (
+	// EventAccountLocked is recorded when an account is locked after too many failed logins
+	EventAccountLocked = "account_locked"
+	// EventIPLocked is recorded when an IP address is locked out after too many failed logins
+	EventIPLocked = "ip_locked"
+	// EventAPIKeyCreated is recorded when an admin creates an API key
+	EventAPIKeyCreated = "api_key_created"
+	// EventAPIKeyRevoked is recorded when an admin revokes an API key
+	EventAPIKeyRevoked = "api_key_revoked"
+	// EventUserSuspended is recorded when an admin or a moderator suspends a user
+	EventUserSuspended = "user_suspended"
+	// EventSuspensionLifted is recorded when an admin or a moderator lifts the suspension of a user
+	EventSuspensionLifted = "suspension_lifted"
+)
+
+// Events are all the known events
+var Events = []string{EventAccountLocked, EventIPLocked, EventAPIKeyCreated, EventAPIKeyRevoked, EventUserSuspended, EventSuspensionLifted}
+
+// Entry is a record in the audit log
+type Entry struct {
+	ID    pk.ID
+	Event string
+	// UserID is the user that the event is about, if it is known. It is empty e.g. when someone tries to
+	// log in with an unknown email.
+	UserID   pk.ID
+	Email    string
+	IP       string
+	Detail   string
+	Datetime time.Time
+}
+
+// Record saves the entry in the audit log
+func Record(e Entry) (Entry, error) {
+	if e.Event == "" {
+		return e, fmt.Errorf("cannot record an audit entry without an event")
+	}
+	if e.Datetime.IsZero() {
+		e.Datetime = time.Now()
+	}
+
+	id, err := db.SaveNewEntity(db.AuditCollection, &e)
+	if err != nil {
+		return e, err
+	}
+	e.ID = id
+
+	clog.Warnf("Audit | %s: user=%d email=%s ip=%s %s", e.Event, e.UserID, e.Email, e.IP, e.Detail)
+
+	return e, nil
+}
+
+// GetEntriesByEvent returns the entries for the event, newest first
+func GetEntriesByEvent(event string) ([]Entry, error) {
+	return getEntriesByQuery(fmt.Sprintf("Event:%s", event))
+}
+
+// GetEntriesByUserID returns the entries about the user, newest first
+func GetEntriesByUserID(userID pk.ID) ([]Entry, error) {
+	return getEntriesByQuery(fmt.Sprintf("UserID:%d", userID))
+}
+
+func getEntriesByQuery(query string) ([]Entry, error) {
+
+	// Run the query
+	result, err := db.Query(db.AuditCollection, query)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	err = db.DecodeQueryResult(result, &entries)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Datetime.After(entries[j].Datetime)
+	})
+
+	return entries, nil
+}
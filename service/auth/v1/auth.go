@@ -1,11 +1,14 @@
 package auth
 
 import (
+	"crypto/sha256"
 	"fmt"
+	"time"
 
-	"github.com/teejays/go-jwt"
 	"github.com/teejays/matchapi/lib/auth"
 	authLib "github.com/teejays/matchapi/lib/auth"
+	"github.com/teejays/matchapi/lib/validate"
+	"github.com/teejays/matchapi/service/session/v1"
 	"github.com/teejays/matchapi/service/user/v1"
 )
 
@@ -13,6 +16,20 @@ import (
 type LoginRequest struct {
 	Email    string
 	Password string
+	// DeviceLabel is an optional name for the device, shown in the list of sessions
+	DeviceLabel string
+	// IP is the address that the request comes from, used to throttle the failed logins. It is set by the
+	// handler, not by the client, and so is the UserAgent.
+	IP        string `json:"-"`
+	UserAgent string `json:"-"`
+}
+
+// Validate returns the fields of the request that are missing
+func (req LoginRequest) Validate() error {
+	var v validate.Validator
+	v.Required("Email", req.Email, "email cannot be empty")
+	v.Required("Password", req.Password, "password cannot be empty")
+	return v.Err()
 }
 
 // TODO: this should probably not be hard coded here
@@ -21,67 +38,114 @@ var PasswordSecretKey = "I am a disco dancer"
 var ErrInvalidEmail = fmt.Errorf("no accounts found with the given email")
 var ErrInvalidPassword = fmt.Errorf("accounts found but could not match the password")
 
+// LoginResponse is the result of a successful password login. If the user has enabled two-factor
+// authentication, there is no Token yet: the ChallengeToken has to be exchanged, along with a code,
+// through LoginTwoFactor.
+type LoginResponse struct {
+	Token             string `json:",omitempty"`
+	TwoFactorRequired bool   `json:",omitempty"`
+	ChallengeToken    string `json:",omitempty"`
+}
+
 // Login takes a LoginRequest and verifies that login credentials
-func Login(req LoginRequest) (string, error) {
+func Login(req LoginRequest) (LoginResponse, error) {
+	var resp LoginResponse
+
+	now := time.Now()
+
+	// Logins are throttled after failed attempts, before the credentials are checked
+	if err := loginThrottle.check(req.Email, req.IP, now); err != nil {
+		return resp, err
+	}
 
 	// find the user by email ID
 	creds, err := user.GetUserCredsByEmail(req.Email)
 	if err != nil {
-		return "", err
-	}
-
-	if len(creds) < 1 {
-		return "", ErrInvalidEmail
+		return resp, err
 	}
 
 	// there should be only one user with this email
 	if len(creds) > 1 {
-		return "", fmt.Errorf("email %s has mutiple accounts", req.Email)
+		return resp, fmt.Errorf("email %s has mutiple accounts", req.Email)
 	}
 
-	// hash the password
+	// hash the password and compare it even if the email is unknown, against a hash that cannot match, so
+	// that unknown emails and wrong passwords take the same time
 	h, err := authLib.GetHash(req.Password, PasswordSecretKey)
 	if err != nil {
-		return "", err
+		return resp, err
+	}
+	var c = user.UserCred{PasswordHash: make([]byte, sha256.Size)}
+	if len(creds) == 1 {
+		c = creds[0]
+	}
+	match := authLib.IsEqualHash(h, c.PasswordHash)
+
+	if len(creds) < 1 {
+		loginThrottle.fail(req.Email, req.IP, 0, now)
+		return resp, ErrInvalidEmail
+	}
+	if !match {
+		loginThrottle.fail(req.Email, req.IP, c.ID, now)
+		return resp, ErrInvalidPassword
 	}
 
-	// Find the users (we're treating it as multiple users here)
-	var u *user.User
-	var found bool
-	for _, c := range creds {
-		if authLib.IsEqualHash(h, c.PasswordHash) && req.Email == c.Email {
-			// We have a user!
-			u, err = user.GetUserByID(c.ID)
-			if err != nil {
-				return "", err
-			}
-			found = true
-			break
-		}
+	// We have a user!
+	u, err := user.GetUserByID(c.ID)
+	if err != nil {
+		return resp, err
 	}
 
-	if !found {
-		return "", ErrInvalidPassword
+	resp, err = completeLogin(u, session.Device{Label: req.DeviceLabel, UserAgent: req.UserAgent, IP: req.IP}, now)
+	if err != nil {
+		return resp, err
+	}
+	if !resp.TwoFactorRequired {
+		loginThrottle.succeed(req.Email)
 	}
 
-	// Get the JWT client and create a token
-	if !jwt.IsClientInitialized() {
-		err = auth.InitJWTClient()
-		if err != nil {
-			return "", err
-		}
+	return resp, nil
+}
+
+// completeLogin logs in the user once they have proven who they are, at t. Users with two-factor
+// authentication only get a challenge token.
+func completeLogin(u *user.User, d session.Device, t time.Time) (LoginResponse, error) {
+	var resp LoginResponse
+
+	// Suspended and banned users cannot log in
+	if err := u.CheckAccess(t); err != nil {
+		return resp, err
 	}
-	cl, err := jwt.GetClient()
+
+	// Users with two-factor authentication need to provide a code before they get a token
+	enabled, err := IsTwoFactorEnabled(u.ID)
 	if err != nil {
-		return "", err
+		return resp, err
+	}
+	if enabled {
+		resp.TwoFactorRequired = true
+		resp.ChallengeToken, err = newChallengeToken(u.ID, t)
+		return resp, err
 	}
 
-	payload, err := auth.NewPayload(u.ID, u.Email)
+	resp.Token, err = newToken(u, d)
+	return resp, err
+}
+
+// newToken records a new session for the user on the device, and creates a JWT token for it
+func newToken(u *user.User, d session.Device) (string, error) {
+	payload, err := auth.NewPayload(u.ID, u.Email, u.Roles)
 	if err != nil {
 		return "", fmt.Errorf("error creating payload for JWT token: %v", err)
 	}
 
-	token, err := cl.CreateToken(payload)
+	s, err := session.NewSession(u.ID, d, time.Now())
+	if err != nil {
+		return "", err
+	}
+	payload.SessionID = s.ID
+
+	token, err := auth.NewToken(payload)
 	if err != nil {
 		return "", fmt.Errorf("error creating JWT token: %v", err)
 	}
@@ -0,0 +1,212 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/db"
+	"github.com/teejays/matchapi/lib/oauth"
+	"github.com/teejays/matchapi/lib/pk"
+	"github.com/teejays/matchapi/service/session/v1"
+	"github.com/teejays/matchapi/service/user/v1"
+)
+
+// OAuthStateTTL is how long the user has to sign in with the identity provider
+var OAuthStateTTL = 10 * time.Minute
+
+// MaxPendingOAuthLoginsPerIP and MaxPendingOAuthLogins limit the number of sign ins that have been started
+// but not finished yet, from an IP address and in total, since they are kept in memory
+var MaxPendingOAuthLoginsPerIP = 10
+var MaxPendingOAuthLogins = 10000
+
+var ErrInvalidOAuthState = fmt.Errorf("the sign in has expired or was not started here; please try again")
+var ErrOAuthFailed = fmt.Errorf("the identity provider could not confirm who the user is")
+var ErrOAuthAccountNotFound = fmt.Errorf("no account uses the email of this identity; please sign up first")
+var ErrOAuthEmailNotVerified = fmt.Errorf("the email has to be verified, both with the identity provider and here, to link the accounts")
+var ErrTooManyOAuthLogins = fmt.Errorf("too many sign ins have been started; please try again later")
+
+// OAuthIdentity links a user to their account at an identity provider
+type OAuthIdentity struct {
+	ID pk.ID
+	// Key identifies the account at the provider; it's a hash of the provider and the subject, which
+	// can be searched for whatever characters the subject has
+	Key            string
+	Provider       string
+	Subject        string
+	UserID         pk.ID
+	Email          string
+	DatetimeLinked time.Time
+}
+
+// OAuthStart is what the client needs to send the user to the identity provider
+type OAuthStart struct {
+	URL   string
+	State string
+}
+
+// OAuthLoginRequest finishes the sign in, with the code and the state that the identity provider has
+// sent back to the client
+type OAuthLoginRequest struct {
+	State string
+	Code  string
+	// DeviceLabel is an optional name for the device, shown in the list of sessions
+	DeviceLabel string
+	// IP is the address that the request comes from, set by the handler along with the UserAgent
+	IP        string `json:"-"`
+	UserAgent string `json:"-"`
+}
+
+// pendingOAuthLogin is a sign in that has been started, but not finished yet
+type pendingOAuthLogin struct {
+	provider     string
+	codeVerifier string
+	ip           string
+	expiresAt    time.Time
+}
+
+// oauthStates holds the pending sign ins by their state. The code verifiers never leave the server.
+var oauthStates = map[string]pendingOAuthLogin{}
+var oauthStatesLock sync.Mutex
+
+// BeginOAuthLogin starts a sign in with the identity provider, for a client at the IP address. It returns
+// ErrTooManyOAuthLogins if too many sign ins are pending already.
+func BeginOAuthLogin(provider string, ip string) (OAuthStart, error) {
+	var start OAuthStart
+
+	p, err := oauth.GetProvider(provider)
+	if err != nil {
+		return start, err
+	}
+
+	verifier, err := oauth.NewCodeVerifier()
+	if err != nil {
+		return start, err
+	}
+	start.State, err = oauth.NewState()
+	if err != nil {
+		return start, err
+	}
+	start.URL = p.AuthCodeURL(start.State, oauth.CodeChallenge(verifier))
+
+	now := time.Now()
+	oauthStatesLock.Lock()
+	defer oauthStatesLock.Unlock()
+	var fromIP int
+	for state, pending := range oauthStates {
+		if now.After(pending.expiresAt) {
+			delete(oauthStates, state)
+			continue
+		}
+		if pending.ip == ip {
+			fromIP++
+		}
+	}
+	if fromIP >= MaxPendingOAuthLoginsPerIP || len(oauthStates) >= MaxPendingOAuthLogins {
+		return OAuthStart{}, ErrTooManyOAuthLogins
+	}
+	oauthStates[start.State] = pendingOAuthLogin{provider: provider, codeVerifier: verifier, ip: ip, expiresAt: now.Add(OAuthStateTTL)}
+
+	return start, nil
+}
+
+// LoginOAuth finishes a sign in with the identity provider. The first time, the identity is linked to the
+// user with the same email, if both the provider and the user have verified it. Users with two-factor
+// authentication still need to provide a code, like with Login.
+func LoginOAuth(provider string, req OAuthLoginRequest) (LoginResponse, error) {
+	var resp LoginResponse
+	now := time.Now()
+
+	p, err := oauth.GetProvider(provider)
+	if err != nil {
+		return resp, err
+	}
+
+	// Each state can only be used once
+	oauthStatesLock.Lock()
+	pending, ok := oauthStates[req.State]
+	delete(oauthStates, req.State)
+	oauthStatesLock.Unlock()
+	if !ok || pending.provider != provider || now.After(pending.expiresAt) {
+		return resp, ErrInvalidOAuthState
+	}
+
+	id, err := p.Exchange(req.Code, pending.codeVerifier)
+	if err != nil {
+		clog.Errorf("Auth | LoginOAuth(): %v", err)
+		return resp, ErrOAuthFailed
+	}
+
+	u, err := getOAuthUser(provider, id, now)
+	if err != nil {
+		return resp, err
+	}
+
+	return completeLogin(u, session.Device{Label: req.DeviceLabel, UserAgent: req.UserAgent, IP: req.IP}, now)
+}
+
+// getOAuthUser returns the user linked to the identity, linking it first if needed
+func getOAuthUser(provider string, id oauth.Identity, t time.Time) (*user.User, error) {
+	key := oauthIdentityKey(provider, id.Subject)
+
+	result, err := db.Query(db.OAuthIdentityCollection, fmt.Sprintf("Key:%s", key))
+	if err != nil {
+		return nil, err
+	}
+	var links []OAuthIdentity
+	err = db.DecodeQueryResult(result, &links)
+	if err != nil {
+		return nil, err
+	}
+	if len(links) > 0 {
+		return user.GetUserByID(links[0].UserID)
+	}
+
+	// Link the identity to the user with the same email. Both sides have to have verified the email, so
+	// that nobody can take over an account by signing up with someone else's email, on either side.
+	email := strings.TrimSpace(id.Email)
+	if email == "" {
+		return nil, ErrOAuthAccountNotFound
+	}
+	creds, err := user.GetUserCredsByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if len(creds) != 1 {
+		return nil, ErrOAuthAccountNotFound
+	}
+	u, err := user.GetUserByID(creds[0].ID)
+	if err != nil {
+		return nil, err
+	}
+	if !id.EmailVerified || !u.IsEmailVerified {
+		return nil, ErrOAuthEmailNotVerified
+	}
+
+	link := OAuthIdentity{
+		Key:            key,
+		Provider:       provider,
+		Subject:        id.Subject,
+		UserID:         u.ID,
+		Email:          email,
+		DatetimeLinked: t,
+	}
+	_, err = db.SaveNewEntity(db.OAuthIdentityCollection, &link)
+	if err != nil {
+		return nil, err
+	}
+
+	clog.Infof("Auth | LoginOAuth(): linked user %d to their %s account", u.ID, provider)
+
+	return u, nil
+}
+
+func oauthIdentityKey(provider, subject string) string {
+	h := sha256.Sum256([]byte(provider + "\x00" + subject))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/teejays/matchapi/db"
+	authLib "github.com/teejays/matchapi/lib/auth"
+	"github.com/teejays/matchapi/lib/oauth"
+	"github.com/teejays/matchapi/service/user/v1"
+)
+
+func TestOAuthLogin(t *testing.T) {
+
+	// Initialize the mock DB client
+	err := db.InitMockClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.DestoryMockClient()
+
+	// Populate the User mock data in DB
+	err = user.HelperPopulateMockData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := user.GetUserByID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Setup a local identity provider, which knows the first user and a stranger
+	p := oauth.NewLocalProvider("local")
+	p.AddIdentity(oauth.Identity{Subject: "jon", Email: u.Email, EmailVerified: true})
+	p.AddIdentity(oauth.Identity{Subject: "stranger", Email: "stranger@email.com", EmailVerified: true})
+	oauth.Register(p)
+	defer oauth.Unregister("local")
+
+	login := func(provider string, subject string) (LoginResponse, error) {
+		start, err := BeginOAuthLogin("local", "127.0.0.1")
+		if err != nil {
+			return LoginResponse{}, err
+		}
+		code, state, err := p.Authorize(start.URL, subject)
+		if err != nil {
+			return LoginResponse{}, err
+		}
+		return LoginOAuth(provider, OAuthLoginRequest{State: state, Code: code})
+	}
+
+	_, err = BeginOAuthLogin("unknown", "127.0.0.1")
+	assert.Equal(t, oauth.ErrUnknownProvider, err)
+
+	// Identities without an account cannot sign in
+	_, err = login("local", "stranger")
+	assert.Equal(t, ErrOAuthAccountNotFound, err)
+
+	// The accounts are only linked once the user has verified their email
+	_, err = login("local", "jon")
+	assert.Equal(t, ErrOAuthEmailNotVerified, err)
+
+	u.IsEmailVerified = true
+	err = db.SaveEntityByID(db.UserCollection, u.ID, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := login("local", "jon")
+	assert.NoError(t, err)
+	assert.False(t, resp.TwoFactorRequired)
+
+	// The token is the same as the one given by Login
+	payload, err := authLib.VerifyToken(resp.Token)
+	assert.NoError(t, err)
+	assert.Equal(t, u.ID, payload.UserID)
+	assert.Equal(t, u.Email, payload.Email)
+	assert.NotZero(t, payload.SessionID)
+
+	// Once linked, the identity keeps working even if the email changes at the provider
+	p.AddIdentity(oauth.Identity{Subject: "jon", Email: "jon@elsewhere.com"})
+	resp, err = login("local", "jon")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, resp.Token)
+
+	// States can only be used once, and only with their provider
+	start, err := BeginOAuthLogin("local", "127.0.0.1")
+	assert.NoError(t, err)
+	code, state, err := p.Authorize(start.URL, "jon")
+	assert.NoError(t, err)
+	_, err = LoginOAuth("local", OAuthLoginRequest{State: state + "x", Code: code})
+	assert.Equal(t, ErrInvalidOAuthState, err)
+	_, err = LoginOAuth("local", OAuthLoginRequest{State: state, Code: "wrong"})
+	assert.Equal(t, ErrOAuthFailed, err)
+	_, err = LoginOAuth("local", OAuthLoginRequest{State: state, Code: code})
+	assert.Equal(t, ErrInvalidOAuthState, err)
+}
+
+func TestBeginOAuthLoginLimits(t *testing.T) {
+	defer func(perIP, total int) { MaxPendingOAuthLoginsPerIP, MaxPendingOAuthLogins = perIP, total }(MaxPendingOAuthLoginsPerIP, MaxPendingOAuthLogins)
+	MaxPendingOAuthLoginsPerIP = 2
+	MaxPendingOAuthLogins = 3
+
+	oauth.Register(oauth.NewLocalProvider("local"))
+	defer oauth.Unregister("local")
+
+	oauthStatesLock.Lock()
+	oauthStates = make(map[string]pendingOAuthLogin)
+	oauthStatesLock.Unlock()
+
+	// An IP address can only have a few pending sign ins
+	for i := 0; i < 2; i++ {
+		_, err := BeginOAuthLogin("local", "10.0.0.1")
+		assert.NoError(t, err)
+	}
+	_, err := BeginOAuthLogin("local", "10.0.0.1")
+	assert.Equal(t, ErrTooManyOAuthLogins, err)
+
+	// And there are only so many in total
+	_, err = BeginOAuthLogin("local", "10.0.0.2")
+	assert.NoError(t, err)
+	_, err = BeginOAuthLogin("local", "10.0.0.3")
+	assert.Equal(t, ErrTooManyOAuthLogins, err)
+
+	// Expired sign ins don't count
+	oauthStatesLock.Lock()
+	for state, pending := range oauthStates {
+		pending.expiresAt = time.Now().Add(-time.Second)
+		oauthStates[state] = pending
+	}
+	oauthStatesLock.Unlock()
+	_, err = BeginOAuthLogin("local", "10.0.0.1")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/lib/pk"
+	"github.com/teejays/matchapi/service/audit/v1"
+)
+
+// MaxFailedLogins is the number of failed logins for an account, within the FailedLoginWindow, after which
+// the account is locked for the LockoutDuration
+var MaxFailedLogins = 5
+
+// MaxFailedLoginsPerIP is the number of failed logins from an IP address, within the FailedLoginWindow,
+// after which the address is locked out for the LockoutDuration. It is higher than MaxFailedLogins since
+// many users can share an address.
+var MaxFailedLoginsPerIP = 20
+
+// FailedLoginWindow is the rolling window over which the failed logins are counted
+var FailedLoginWindow = 15 * time.Minute
+
+// LockoutDuration is how long an account or an IP address stays locked
+var LockoutDuration = 15 * time.Minute
+
+// BaseLoginDelay is how long an account has to wait after a failed login before it can try again. The
+// delay doubles with every failed login, up to MaxLoginDelay.
+var BaseLoginDelay = time.Second
+
+// MaxLoginDelay is the longest delay between two logins, before the account gets locked
+var MaxLoginDelay = 30 * time.Second
+
+// ThrottleError is returned when a login is attempted too soon after failed logins, or while the account
+// or the IP address is locked. It is returned before the credentials are checked, so it doesn't reveal
+// whether they are correct.
+type ThrottleError struct {
+	// Locked is true if the login is rejected because of a lockout, rather than a delay
+	Locked  bool
+	RetryAt time.Time
+}
+
+func (e *ThrottleError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("too many failed logins; logins are locked until %s", e.RetryAt.UTC().Format(time.RFC3339))
+	}
+	return fmt.Sprintf("too many failed logins; please try again at %s", e.RetryAt.UTC().Format(time.RFC3339))
+}
+
+// failedLogins keeps track of the recent failed logins for an account or an IP address
+type failedLogins struct {
+	Timestamps  []time.Time
+	LockedUntil time.Time
+}
+
+// prune drops the failed logins that fall outside of the rolling window ending at t
+func (f *failedLogins) prune(t time.Time) {
+	windowStart := t.Add(-FailedLoginWindow)
+	var timestamps []time.Time
+	for _, ts := range f.Timestamps {
+		if ts.After(windowStart) {
+			timestamps = append(timestamps, ts)
+		}
+	}
+	f.Timestamps = timestamps
+}
+
+// isStale returns true if the failed logins don't affect anything anymore at t
+func (f *failedLogins) isStale(t time.Time) bool {
+	f.prune(t)
+	return len(f.Timestamps) == 0 && !f.LockedUntil.After(t)
+}
+
+// throttle tracks the failed logins in memory. Locks are lost when the server restarts, which is fine
+// since an attacker cannot make it restart.
+type throttle struct {
+	lock      sync.Mutex
+	accounts  map[string]*failedLogins
+	ips       map[string]*failedLogins
+	lastSweep time.Time
+}
+
+var loginThrottle = newThrottle()
+
+func newThrottle() *throttle {
+	return &throttle{
+		accounts: make(map[string]*failedLogins),
+		ips:      make(map[string]*failedLogins),
+	}
+}
+
+// accountKey normalizes the email, so that the account cannot be attacked with variations of it. Unknown
+// emails are tracked like the known ones, so that they behave the same.
+func accountKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// LoginDelay returns how long an account has to wait after the provided number of failed logins
+func LoginDelay(failures int) time.Duration {
+	if failures < 1 {
+		return 0
+	}
+	d := BaseLoginDelay
+	for i := 1; i < failures; i++ {
+		d *= 2
+		if d >= MaxLoginDelay {
+			return MaxLoginDelay
+		}
+	}
+	return d
+}
+
+// check returns a ThrottleError if a login for the email from the IP address is not allowed at t
+func (th *throttle) check(email, ip string, t time.Time) error {
+	th.lock.Lock()
+	defer th.lock.Unlock()
+
+	if f, exists := th.ips[ip]; exists && ip != "" && f.LockedUntil.After(t) {
+		return &ThrottleError{Locked: true, RetryAt: f.LockedUntil}
+	}
+
+	f, exists := th.accounts[accountKey(email)]
+	if !exists {
+		return nil
+	}
+	if f.LockedUntil.After(t) {
+		return &ThrottleError{Locked: true, RetryAt: f.LockedUntil}
+	}
+	f.prune(t)
+	if n := len(f.Timestamps); n > 0 {
+		retryAt := f.Timestamps[n-1].Add(LoginDelay(n))
+		if retryAt.After(t) {
+			return &ThrottleError{RetryAt: retryAt}
+		}
+	}
+
+	return nil
+}
+
+// fail records a failed login for the email from the IP address at t, and locks the account or the address
+// if they have reached their limit
+func (th *throttle) fail(email, ip string, userID pk.ID, t time.Time) {
+	th.lock.Lock()
+	defer th.lock.Unlock()
+
+	th.sweep(t)
+
+	key := accountKey(email)
+	if th.record(th.accounts, key, MaxFailedLogins, t) {
+		th.audit(audit.Entry{Event: audit.EventAccountLocked, UserID: userID, Email: key, IP: ip, Datetime: t})
+	}
+	if ip != "" && th.record(th.ips, ip, MaxFailedLoginsPerIP, t) {
+		th.audit(audit.Entry{Event: audit.EventIPLocked, UserID: userID, Email: key, IP: ip, Datetime: t})
+	}
+}
+
+// record adds a failed login at t for the key, and returns true if it has caused a lockout
+func (th *throttle) record(m map[string]*failedLogins, key string, max int, t time.Time) bool {
+	f, exists := m[key]
+	if !exists {
+		f = &failedLogins{}
+		m[key] = f
+	}
+	f.prune(t)
+	f.Timestamps = append(f.Timestamps, t)
+
+	if len(f.Timestamps) < max {
+		return false
+	}
+	f.LockedUntil = t.Add(LockoutDuration)
+	f.Timestamps = nil
+	return true
+}
+
+func (th *throttle) audit(e audit.Entry) {
+	e.Detail = fmt.Sprintf("logins locked until %s", e.Datetime.Add(LockoutDuration).UTC().Format(time.RFC3339))
+	if _, err := audit.Record(e); err != nil {
+		clog.Errorf("Auth | throttle: could not record the '%s' event in the audit log: %v", e.Event, err)
+	}
+}
+
+// succeed clears the failed logins of the account after a successful login. The failures of the IP address
+// are kept, so that an attacker cannot reset them with their own account.
+func (th *throttle) succeed(email string) {
+	th.lock.Lock()
+	defer th.lock.Unlock()
+
+	delete(th.accounts, accountKey(email))
+}
+
+// sweep forgets the accounts and addresses that don't have recent failed logins, so that the memory used
+// doesn't keep growing. It runs at most once per FailedLoginWindow.
+func (th *throttle) sweep(t time.Time) {
+	if t.Sub(th.lastSweep) < FailedLoginWindow {
+		return
+	}
+	th.lastSweep = t
+
+	for _, m := range []map[string]*failedLogins{th.accounts, th.ips} {
+		for key, f := range m {
+			if f.isStale(t) {
+				delete(m, key)
+			}
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/teejays/matchapi/db"
+	authLib "github.com/teejays/matchapi/lib/auth"
+	"github.com/teejays/matchapi/service/audit/v1"
+	"github.com/teejays/matchapi/service/user/v1"
+)
+
+func TestThrottle(t *testing.T) {
+
+	// Initialize the mock DB client, for the audit log
+	err := db.InitMockClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.DestoryMockClient()
+
+	th := newThrottle()
+	now := time.Now()
+	email, ip := "jane@email.com", "10.0.0.1"
+
+	// The delay between the logins should grow with every failure
+	assert.NoError(t, th.check(email, ip, now))
+	th.fail(email, ip, 2, now)
+	err = th.check(email, ip, now)
+	if assert.IsType(t, &ThrottleError{}, err) {
+		assert.False(t, err.(*ThrottleError).Locked)
+		assert.True(t, now.Add(BaseLoginDelay).Equal(err.(*ThrottleError).RetryAt))
+	}
+	// Emails are matched regardless of the case
+	assert.Error(t, th.check(" JANE@email.com", "10.0.0.2", now))
+
+	now = now.Add(BaseLoginDelay)
+	assert.NoError(t, th.check(email, ip, now))
+	th.fail(email, ip, 2, now)
+	assert.Error(t, th.check(email, ip, now.Add(BaseLoginDelay)))
+	assert.NoError(t, th.check(email, ip, now.Add(2*BaseLoginDelay)))
+
+	// The account should be locked once it reaches the limit, and the lockout should be audited
+	for i := 2; i < MaxFailedLogins; i++ {
+		now = now.Add(MaxLoginDelay)
+		th.fail(email, ip, 2, now)
+	}
+	err = th.check(email, "10.0.0.2", now.Add(MaxLoginDelay))
+	if assert.IsType(t, &ThrottleError{}, err) {
+		assert.True(t, err.(*ThrottleError).Locked)
+		assert.True(t, now.Add(LockoutDuration).Equal(err.(*ThrottleError).RetryAt))
+	}
+	assert.NoError(t, th.check(email, ip, now.Add(LockoutDuration)))
+
+	entries, err := audit.GetEntriesByUserID(2)
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, audit.EventAccountLocked, entries[0].Event)
+		assert.Equal(t, ip, entries[0].IP)
+	}
+
+	// A successful login clears the failures of the account
+	th.fail("john@email.com", ip, 1, now)
+	th.succeed("john@email.com")
+	assert.NoError(t, th.check("john@email.com", ip, now))
+
+	// The IP address should be locked out once it reaches its limit, whatever the accounts
+	th = newThrottle()
+	for i := 0; i < MaxFailedLoginsPerIP; i++ {
+		th.fail(string(rune('a'+i))+"@email.com", ip, 0, now)
+	}
+	err = th.check("someone@email.com", ip, now)
+	if assert.IsType(t, &ThrottleError{}, err) {
+		assert.True(t, err.(*ThrottleError).Locked)
+	}
+	assert.NoError(t, th.check("someone@email.com", "10.0.0.2", now))
+
+	entries, err = audit.GetEntriesByEvent(audit.EventIPLocked)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	// Stale entries are forgotten
+	th.fail("john@email.com", "10.0.0.3", 1, now.Add(2*LockoutDuration))
+	assert.Len(t, th.accounts, 1)
+	assert.Len(t, th.ips, 1)
+}
+
+func TestLoginThrottle(t *testing.T) {
+
+	// Initialize the mock DB client
+	err := db.InitMockClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.DestoryMockClient()
+
+	// Populate the User mock data in DB, with a password for the first user
+	err = user.HelperPopulateMockData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := user.GetUserByID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.PasswordHash, err = authLib.GetHash("secret", PasswordSecretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.SaveEntityByID(db.UserCollection, u.ID, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loginThrottle = newThrottle()
+	defer func() { loginThrottle = newThrottle() }()
+
+	// A wrong password should delay the next login, even with the right password
+	_, err = Login(LoginRequest{Email: u.Email, Password: "wrong", IP: "10.0.0.1"})
+	assert.Equal(t, ErrInvalidPassword, err)
+	_, err = Login(LoginRequest{Email: u.Email, Password: "secret", IP: "10.0.0.1"})
+	assert.IsType(t, &ThrottleError{}, err)
+
+	// Unknown emails should be throttled the same way
+	_, err = Login(LoginRequest{Email: "nobody@email.com", Password: "wrong", IP: "10.0.0.1"})
+	assert.Equal(t, ErrInvalidEmail, err)
+	_, err = Login(LoginRequest{Email: "nobody@email.com", Password: "wrong", IP: "10.0.0.1"})
+	assert.IsType(t, &ThrottleError{}, err)
+}
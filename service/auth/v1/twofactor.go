@@ -0,0 +1,365 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/db"
+	authLib "github.com/teejays/matchapi/lib/auth"
+	"github.com/teejays/matchapi/lib/pk"
+	"github.com/teejays/matchapi/lib/totp"
+	"github.com/teejays/matchapi/service/session/v1"
+	"github.com/teejays/matchapi/service/user/v1"
+)
+
+// TwoFactorIssuer is the name that authenticator apps show next to the codes
+var TwoFactorIssuer = "Match API"
+
+// ChallengeSecretKey is used to sign the two-factor challenge tokens. It is random unless it is configured,
+// so the pending challenges don't survive a restart.
+var ChallengeSecretKey = authLib.NewSecretKey()
+
+// ChallengeTokenTTL is how long the user has to provide a code after they have entered their password
+var ChallengeTokenTTL = 5 * time.Minute
+
+// RecoveryCodeCount is the number of recovery codes given to the user when they enroll. Each of them can be
+// used once instead of a code, e.g. if the user has lost their phone.
+const RecoveryCodeCount = 10
+
+var ErrTwoFactorAlreadyEnabled = fmt.Errorf("two-factor authentication is already enabled")
+var ErrTwoFactorNotEnabled = fmt.Errorf("two-factor authentication is not enabled")
+var ErrTwoFactorNotEnrolled = fmt.Errorf("two-factor authentication enrollment has not been started")
+var ErrInvalidTwoFactorCode = fmt.Errorf("the two-factor code is invalid")
+var ErrInvalidChallengeToken = fmt.Errorf("the challenge token is invalid or has expired; please log in again")
+
+// TwoFactor holds the two-factor authentication settings of a user
+type TwoFactor struct {
+	UserID pk.ID
+	// Secret is the base32 encoded TOTP secret shared with the user's authenticator app
+	Secret    string
+	IsEnabled bool
+	// RecoveryCodeHashes are the hashes of the recovery codes that haven't been used yet
+	RecoveryCodeHashes [][]byte
+	// LastCounter is the TOTP counter of the last code that was accepted, so that a code cannot be used twice
+	LastCounter     int64
+	DatetimeEnabled time.Time
+}
+
+// Enrollment is what the user needs to set up their authenticator app. It's only shown once.
+type Enrollment struct {
+	Secret        string
+	URI           string
+	RecoveryCodes []string
+}
+
+// TwoFactorLoginRequest is the second step of the login for users with two-factor authentication. The Code
+// can either be a code from the authenticator app or one of the recovery codes.
+type TwoFactorLoginRequest struct {
+	ChallengeToken string
+	Code           string
+	// DeviceLabel is an optional name for the device, shown in the list of sessions
+	DeviceLabel string
+	// IP is the address that the request comes from, set by the handler along with the UserAgent
+	IP        string `json:"-"`
+	UserAgent string `json:"-"`
+}
+
+// getTwoFactor returns the two-factor settings of the user, which are empty if the user never enrolled
+func getTwoFactor(userID pk.ID) (TwoFactor, error) {
+	var tf TwoFactor
+	err := db.GetEntityByID(db.TwoFactorCollection, userID, &tf)
+	if db.IsNotExist(err) {
+		return TwoFactor{UserID: userID}, nil
+	}
+	return tf, err
+}
+
+func saveTwoFactor(tf TwoFactor) error {
+	return db.SaveEntityByID(db.TwoFactorCollection, tf.UserID, tf)
+}
+
+// IsTwoFactorEnabled returns true if the user needs a code to log in
+func IsTwoFactorEnabled(userID pk.ID) (bool, error) {
+	tf, err := getTwoFactor(userID)
+	if err != nil {
+		return false, err
+	}
+	return tf.IsEnabled, nil
+}
+
+// BeginTwoFactorEnrollment generates a new secret and recovery codes for the user. Two-factor
+// authentication is only enabled once the user confirms that their app works, with ConfirmTwoFactorEnrollment.
+func BeginTwoFactorEnrollment(u *user.User) (Enrollment, error) {
+	var e Enrollment
+
+	tf, err := getTwoFactor(u.ID)
+	if err != nil {
+		return e, err
+	}
+	if tf.IsEnabled {
+		return e, ErrTwoFactorAlreadyEnabled
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return e, err
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return e, err
+	}
+
+	tf = TwoFactor{
+		UserID:             u.ID,
+		Secret:             secret,
+		RecoveryCodeHashes: hashes,
+	}
+	if err := saveTwoFactor(tf); err != nil {
+		return e, err
+	}
+
+	e = Enrollment{
+		Secret:        secret,
+		URI:           totp.URI(TwoFactorIssuer, u.Email, secret),
+		RecoveryCodes: codes,
+	}
+	return e, nil
+}
+
+// ConfirmTwoFactorEnrollment enables two-factor authentication, if the code from the authenticator app is valid
+func ConfirmTwoFactorEnrollment(userID pk.ID, code string) error {
+	tf, err := getTwoFactor(userID)
+	if err != nil {
+		return err
+	}
+	if tf.IsEnabled {
+		return ErrTwoFactorAlreadyEnabled
+	}
+	if tf.Secret == "" {
+		return ErrTwoFactorNotEnrolled
+	}
+
+	counter, ok := totp.Validate(tf.Secret, code, time.Now())
+	if !ok {
+		return ErrInvalidTwoFactorCode
+	}
+
+	tf.IsEnabled = true
+	tf.LastCounter = counter
+	tf.DatetimeEnabled = time.Now()
+
+	clog.Infof("Auth | ConfirmTwoFactorEnrollment(): user %d has enabled two-factor authentication", userID)
+
+	return saveTwoFactor(tf)
+}
+
+// DisableTwoFactor turns off two-factor authentication. It needs a valid code, or a recovery code, so that a
+// stolen session cannot be used to turn it off.
+func DisableTwoFactor(userID pk.ID, code string) error {
+	tf, err := getTwoFactor(userID)
+	if err != nil {
+		return err
+	}
+	if !tf.IsEnabled {
+		return ErrTwoFactorNotEnabled
+	}
+
+	if err := tf.verify(code, time.Now()); err != nil {
+		return err
+	}
+
+	clog.Infof("Auth | DisableTwoFactor(): user %d has disabled two-factor authentication", userID)
+
+	return db.SaveEntityByID(db.TwoFactorCollection, userID, TwoFactor{UserID: userID})
+}
+
+// LoginTwoFactor exchanges the challenge token from Login and a code for a JWT token
+func LoginTwoFactor(req TwoFactorLoginRequest) (string, error) {
+	now := time.Now()
+
+	userID, err := verifyChallengeToken(req.ChallengeToken, now)
+	if err != nil {
+		return "", err
+	}
+
+	u, err := user.GetUserByID(userID)
+	if err != nil {
+		return "", err
+	}
+
+	// The codes count towards the same limits as the passwords, so that they cannot be guessed either
+	if err := loginThrottle.check(u.Email, req.IP, now); err != nil {
+		return "", err
+	}
+
+	if err := u.CheckAccess(now); err != nil {
+		return "", err
+	}
+
+	tf, err := getTwoFactor(userID)
+	if err != nil {
+		return "", err
+	}
+	// Two-factor authentication might have been disabled since the challenge was issued
+	if !tf.IsEnabled {
+		return "", ErrInvalidChallengeToken
+	}
+
+	if err := tf.verify(req.Code, now); err != nil {
+		if err == ErrInvalidTwoFactorCode {
+			loginThrottle.fail(u.Email, req.IP, u.ID, now)
+		}
+		return "", err
+	}
+
+	token, err := newToken(u, session.Device{Label: req.DeviceLabel, UserAgent: req.UserAgent, IP: req.IP})
+	if err != nil {
+		return "", err
+	}
+	loginThrottle.succeed(u.Email)
+
+	return token, nil
+}
+
+// verify checks the code from the authenticator app or the recovery code. Used codes are saved, so that
+// they cannot be used again.
+func (tf *TwoFactor) verify(code string, t time.Time) error {
+	code = strings.TrimSpace(code)
+
+	if counter, ok := totp.Validate(tf.Secret, code, t); ok {
+		if counter <= tf.LastCounter {
+			return ErrInvalidTwoFactorCode
+		}
+		tf.LastCounter = counter
+		return saveTwoFactor(*tf)
+	}
+
+	h, err := authLib.GetHash(normalizeRecoveryCode(code), PasswordSecretKey)
+	if err != nil {
+		return err
+	}
+	for i, rh := range tf.RecoveryCodeHashes {
+		if authLib.IsEqualHash(h, rh) {
+			tf.RecoveryCodeHashes = append(tf.RecoveryCodeHashes[:i], tf.RecoveryCodeHashes[i+1:]...)
+			clog.Infof("Auth | TwoFactor: user %d has used a recovery code; %d left", tf.UserID, len(tf.RecoveryCodeHashes))
+			return saveTwoFactor(*tf)
+		}
+	}
+
+	return ErrInvalidTwoFactorCode
+}
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newRecoveryCodes generates the recovery codes, formatted like `abcde-fghij`, along with their hashes
+func newRecoveryCodes() ([]string, [][]byte, error) {
+	var codes []string
+	var hashes [][]byte
+	for i := 0; i < RecoveryCodeCount; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		s := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))[:10]
+		code := s[:5] + "-" + s[5:]
+
+		h, err := authLib.GetHash(normalizeRecoveryCode(code), PasswordSecretKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, h)
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode ignores the case and the dashes, since users might type the code
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.Replace(code, "-", "", -1))
+}
+
+type pendingChallenge struct {
+	userID    pk.ID
+	expiresAt time.Time
+}
+
+// challenges holds the challenges that haven't been used yet by their nonce. A token is only valid if its
+// nonce is here, and the nonce is removed on the first attempt, so that each challenge can only be used once.
+var challenges = map[string]pendingChallenge{}
+var challengesLock sync.Mutex
+
+// newChallengeToken returns a signed token that proves that the user has entered their password
+func newChallengeToken(userID pk.ID, t time.Time) (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	expiresAt := t.Add(ChallengeTokenTTL)
+	payload := fmt.Sprintf("%d:%d:%x", userID, expiresAt.Unix(), nonce)
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+
+	challengesLock.Lock()
+	defer challengesLock.Unlock()
+	for n, pending := range challenges {
+		if t.After(pending.expiresAt) {
+			delete(challenges, n)
+		}
+	}
+	challenges[fmt.Sprintf("%x", nonce)] = pendingChallenge{userID: userID, expiresAt: expiresAt}
+
+	return encoded + "." + signChallenge(encoded), nil
+}
+
+// verifyChallengeToken returns the ID of the user that the challenge token was issued for, and uses the
+// challenge up
+func verifyChallengeToken(token string, t time.Time) (pk.ID, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return 0, ErrInvalidChallengeToken
+	}
+	if !hmac.Equal([]byte(parts[1]), []byte(signChallenge(parts[0]))) {
+		return 0, ErrInvalidChallengeToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return 0, ErrInvalidChallengeToken
+	}
+	fields := strings.Split(string(payload), ":")
+	if len(fields) != 3 {
+		return 0, ErrInvalidChallengeToken
+	}
+	id, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, ErrInvalidChallengeToken
+	}
+	expires, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || t.Unix() > expires {
+		return 0, ErrInvalidChallengeToken
+	}
+
+	challengesLock.Lock()
+	pending, ok := challenges[fields[2]]
+	delete(challenges, fields[2])
+	challengesLock.Unlock()
+	if !ok || pending.userID != pk.ID(id) || t.After(pending.expiresAt) {
+		return 0, ErrInvalidChallengeToken
+	}
+
+	return pk.ID(id), nil
+}
+
+func signChallenge(message string) string {
+	h := hmac.New(sha256.New, []byte(ChallengeSecretKey))
+	h.Write([]byte(message))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
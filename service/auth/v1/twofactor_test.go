@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"encoding/base64"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/db"
+	authLib "github.com/teejays/matchapi/lib/auth"
+	"github.com/teejays/matchapi/lib/totp"
+	"github.com/teejays/matchapi/service/user/v1"
+)
+
+func init() {
+	clog.LogLevel = 7
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+
+	// Initialize the mock DB client
+	err := db.InitMockClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.DestoryMockClient()
+
+	// Populate the User mock data in DB, with a password for the first user
+	err = user.HelperPopulateMockData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := user.GetUserByID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.PasswordHash, err = authLib.GetHash("secret", PasswordSecretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.SaveEntityByID(db.UserCollection, u.ID, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := LoginRequest{Email: u.Email, Password: "secret"}
+
+	// The failed codes below should not be delayed
+	loginThrottle = newThrottle()
+	defer func(d time.Duration) { BaseLoginDelay = d }(BaseLoginDelay)
+	BaseLoginDelay = 0
+
+	// Without two-factor authentication, the password is enough
+	resp, err := Login(req)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, resp.Token)
+	assert.False(t, resp.TwoFactorRequired)
+
+	// The token should belong to a new session
+	payload, err := authLib.VerifyToken(resp.Token)
+	assert.NoError(t, err)
+	assert.Equal(t, u.ID, payload.UserID)
+	assert.NotZero(t, payload.SessionID)
+
+	// Two-factor authentication is only enabled once the enrollment is confirmed with a valid code
+	assert.Equal(t, ErrTwoFactorNotEnrolled, ConfirmTwoFactorEnrollment(u.ID, "123456"))
+	e, err := BeginTwoFactorEnrollment(u)
+	assert.NoError(t, err)
+	assert.Contains(t, e.URI, "secret="+e.Secret)
+	assert.Len(t, e.RecoveryCodes, RecoveryCodeCount)
+
+	resp, err = Login(req)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, resp.Token)
+
+	assert.Equal(t, ErrInvalidTwoFactorCode, ConfirmTwoFactorEnrollment(u.ID, "000000x"))
+	code, err := totp.Code(e.Secret, time.Now().Add(-totp.Period))
+	assert.NoError(t, err)
+	assert.NoError(t, ConfirmTwoFactorEnrollment(u.ID, code))
+	_, err = BeginTwoFactorEnrollment(u)
+	assert.Equal(t, ErrTwoFactorAlreadyEnabled, err)
+
+	// Now the password only gives a challenge token
+	resp, err = Login(req)
+	assert.NoError(t, err)
+	assert.Empty(t, resp.Token)
+	assert.True(t, resp.TwoFactorRequired)
+	assert.NotEmpty(t, resp.ChallengeToken)
+
+	// Each attempt needs a new challenge, since they can only be used once
+	challenge := func() string {
+		resp, err := Login(req)
+		assert.NoError(t, err)
+		return resp.ChallengeToken
+	}
+
+	// The code that confirmed the enrollment cannot be used again
+	_, err = LoginTwoFactor(TwoFactorLoginRequest{ChallengeToken: resp.ChallengeToken, Code: code})
+	assert.Equal(t, ErrInvalidTwoFactorCode, err)
+	_, err = LoginTwoFactor(TwoFactorLoginRequest{ChallengeToken: resp.ChallengeToken, Code: code})
+	assert.Equal(t, ErrInvalidChallengeToken, err)
+
+	// A tampered challenge token is rejected, and so is one that was never issued, even if it is signed
+	code, err = totp.Code(e.Secret, time.Now())
+	assert.NoError(t, err)
+	_, err = LoginTwoFactor(TwoFactorLoginRequest{ChallengeToken: challenge() + "x", Code: code})
+	assert.Equal(t, ErrInvalidChallengeToken, err)
+	forged := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d:%x", u.ID, time.Now().Add(time.Minute).Unix(), []byte("0123456789abcdef"))))
+	_, err = LoginTwoFactor(TwoFactorLoginRequest{ChallengeToken: forged + "." + signChallenge(forged), Code: code})
+	assert.Equal(t, ErrInvalidChallengeToken, err)
+
+	token, err := LoginTwoFactor(TwoFactorLoginRequest{ChallengeToken: challenge(), Code: code})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token)
+
+	// Recovery codes can be used once, regardless of the case and dashes
+	recovery := e.RecoveryCodes[0]
+	token, err = LoginTwoFactor(TwoFactorLoginRequest{ChallengeToken: challenge(), Code: " " + recovery + " "})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token)
+	_, err = LoginTwoFactor(TwoFactorLoginRequest{ChallengeToken: challenge(), Code: recovery})
+	assert.Equal(t, ErrInvalidTwoFactorCode, err)
+
+	// Expired challenge tokens are rejected
+	expired, err := newChallengeToken(u.ID, time.Now().Add(-2*ChallengeTokenTTL))
+	assert.NoError(t, err)
+	_, err = LoginTwoFactor(TwoFactorLoginRequest{ChallengeToken: expired, Code: e.RecoveryCodes[1]})
+	assert.Equal(t, ErrInvalidChallengeToken, err)
+
+	// Disabling needs a valid code too
+	assert.Equal(t, ErrInvalidTwoFactorCode, DisableTwoFactor(u.ID, "123456"))
+	assert.NoError(t, DisableTwoFactor(u.ID, e.RecoveryCodes[1]))
+	assert.Equal(t, ErrTwoFactorNotEnabled, DisableTwoFactor(u.ID, e.RecoveryCodes[2]))
+
+	resp, err = Login(req)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, resp.Token)
+}
@@ -0,0 +1,208 @@
+package block
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/db"
+	"github.com/teejays/matchapi/lib/pk"
+	"github.com/teejays/matchapi/service/user/v1"
+)
+
+// BasicBlock represents the part of Block struct that is generated by the user behavior
+type BasicBlock struct {
+	BlockedID pk.ID
+}
+
+// Block represents the action of a user blocking another user. Blocks apply in both directions: the
+// two users can no longer see or interact with each other.
+type Block struct {
+	ID         pk.ID
+	BlockerID  pk.ID
+	Datetime   time.Time
+	IsDeleted  bool
+	BasicBlock `mapstructure:",squash"`
+}
+
+// BlockedUser represents a Block along with the profile of the blocked user, so that the blocker
+// can recognize who they have blocked
+type BlockedUser struct {
+	Blocked user.ShareableProfileUser
+	Block
+}
+
+var ErrCannotBlockSelf = fmt.Errorf("invalid BlockedID: users cannot block themselves")
+var ErrBlockDoesNotExist = fmt.Errorf("the user is not blocked")
+
+// Validate returns error if the data in the BasicBlock is not valid
+func (b BasicBlock) Validate() error {
+
+	// BlockedID should be greater than zero
+	if b.BlockedID < 1 {
+		return fmt.Errorf("invalid BlockedID: should be greater than 0")
+	}
+
+	// BlockedID should be a valid user
+	_, err := user.GetUserByID(b.BlockedID)
+	if err != nil {
+		return fmt.Errorf("invalid BlockedID: could not validate that a user exists with this userID: %v", err)
+	}
+
+	return nil
+}
+
+// NewBlock blocks the user with BlockedID on behalf of the user with blockerID. Blocking a user
+// that is already blocked returns the existing block.
+func NewBlock(blockerID pk.ID, b BasicBlock) (Block, error) {
+
+	// Create a new Block object
+	var blk Block
+	blk.BasicBlock = b
+	blk.BlockerID = blockerID
+	blk.Datetime = time.Now()
+
+	// Validate that the data is okay
+	if blockerID == b.BlockedID {
+		return blk, ErrCannotBlockSelf
+	}
+	if err := b.Validate(); err != nil {
+		return blk, fmt.Errorf("could not validate the data: %v", err)
+	}
+
+	// Don't create duplicate blocks
+	existing, err := getActiveBlock(blockerID, b.BlockedID)
+	if err != nil {
+		return blk, err
+	}
+	if existing != nil {
+		return *existing, nil
+	}
+
+	// Save the object in the DB
+	id, err := db.SaveNewEntity(db.BlockCollection, &blk)
+	if err != nil {
+		return blk, err
+	}
+
+	clog.Debugf("Block | NewBlock(): user %d blocked user %d", blockerID, b.BlockedID)
+
+	var block Block
+	err = db.GetEntityByID(db.BlockCollection, id, &block)
+	if err != nil {
+		return block, err
+	}
+
+	return block, nil
+}
+
+// DeleteBlock unblocks the user with blockedID on behalf of the user with blockerID
+func DeleteBlock(blockerID, blockedID pk.ID) error {
+	existing, err := getActiveBlock(blockerID, blockedID)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		return ErrBlockDoesNotExist
+	}
+
+	existing.IsDeleted = true
+	return db.SaveEntityByID(db.BlockCollection, existing.ID, existing)
+}
+
+// GetBlockedUsersByBlockerID returns the users that have been blocked by the provided user
+func GetBlockedUsersByBlockerID(id pk.ID) ([]BlockedUser, error) {
+	var blockedUsers = []BlockedUser{}
+
+	blocks, err := getBlocksByQuery(fmt.Sprintf("BlockerID:%d", id))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, b := range blocks {
+		if b.IsDeleted {
+			continue
+		}
+		blocked, err := user.GetUserByID(b.BlockedID)
+		if err != nil {
+			clog.Warnf("There was an error trying GetUserByID(%d): %v", b.BlockedID, err)
+			continue
+		}
+		blockedUsers = append(blockedUsers, BlockedUser{
+			Blocked: user.ShareableProfileUser{ID: blocked.ID, ShareableProfile: blocked.ShareableProfile.WithImageURLs()},
+			Block:   b,
+		})
+	}
+
+	return blockedUsers, nil
+}
+
+// GetBlockedUserIDs returns the IDs of all the users that the provided user cannot interact with: the users
+// that they have blocked, and the users that have blocked them
+func GetBlockedUserIDs(id pk.ID) (map[pk.ID]bool, error) {
+	var ids = make(map[pk.ID]bool)
+
+	blocking, err := getBlocksByQuery(fmt.Sprintf("BlockerID:%d", id))
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range blocking {
+		if !b.IsDeleted {
+			ids[b.BlockedID] = true
+		}
+	}
+
+	blockedBy, err := getBlocksByQuery(fmt.Sprintf("BlockedID:%d", id))
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range blockedBy {
+		if !b.IsDeleted {
+			ids[b.BlockerID] = true
+		}
+	}
+
+	return ids, nil
+}
+
+// IsBlocked returns true if either of the users has blocked the other
+func IsBlocked(a, b pk.ID) (bool, error) {
+	ids, err := GetBlockedUserIDs(a)
+	if err != nil {
+		return false, err
+	}
+	return ids[b], nil
+}
+
+// getActiveBlock returns the block, if any, that the blocker has placed on the blocked user
+func getActiveBlock(blockerID, blockedID pk.ID) (*Block, error) {
+	blocks, err := getBlocksByQuery(fmt.Sprintf("BlockerID:%d", blockerID))
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range blocks {
+		if b.BlockedID == blockedID && !b.IsDeleted {
+			return &b, nil
+		}
+	}
+	return nil, nil
+}
+
+func getBlocksByQuery(query string) ([]Block, error) {
+
+	// Run the query
+	result, err := db.Query(db.BlockCollection, query)
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert the result into blocks
+	var blocks []Block
+	err = db.DecodeQueryResult(result, &blocks)
+	if err != nil {
+		return nil, err
+	}
+
+	return blocks, nil
+}
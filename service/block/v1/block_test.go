@@ -0,0 +1,68 @@
+package block
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/db"
+	"github.com/teejays/matchapi/lib/pk"
+	"github.com/teejays/matchapi/service/user/v1"
+)
+
+func init() {
+	clog.LogLevel = 7
+}
+
+func TestBlock(t *testing.T) {
+
+	// Initialize the mock DB client
+	err := db.InitMockClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.DestoryMockClient()
+
+	// Populate the User mock data in DB
+	err = user.HelperPopulateMockData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Users cannot block themselves
+	_, err = NewBlock(1, BasicBlock{BlockedID: 1})
+	assert.Equal(t, ErrCannotBlockSelf, err)
+
+	// Block, and block again: there should only be one block
+	b1, err := NewBlock(1, BasicBlock{BlockedID: 2})
+	assert.NoError(t, err)
+	b2, err := NewBlock(1, BasicBlock{BlockedID: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, b1.ID, b2.ID)
+
+	blocked, err := GetBlockedUsersByBlockerID(1)
+	assert.NoError(t, err)
+	if assert.Len(t, blocked, 1) {
+		assert.Equal(t, pk.ID(2), blocked[0].Blocked.ID)
+	}
+
+	// Blocks should apply in both directions
+	isBlocked, err := IsBlocked(1, 2)
+	assert.NoError(t, err)
+	assert.True(t, isBlocked)
+	isBlocked, err = IsBlocked(2, 1)
+	assert.NoError(t, err)
+	assert.True(t, isBlocked)
+	isBlocked, err = IsBlocked(2, 3)
+	assert.NoError(t, err)
+	assert.False(t, isBlocked)
+
+	// Only the blocker can remove the block
+	assert.Equal(t, ErrBlockDoesNotExist, DeleteBlock(2, 1))
+	assert.NoError(t, DeleteBlock(1, 2))
+	isBlocked, err = IsBlocked(2, 1)
+	assert.NoError(t, err)
+	assert.False(t, isBlocked)
+	assert.Equal(t, ErrBlockDoesNotExist, DeleteBlock(1, 2))
+}
@@ -0,0 +1,193 @@
+package discover
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/lib/pk"
+	"github.com/teejays/matchapi/service/block/v1"
+	"github.com/teejays/matchapi/service/like/v2"
+	"github.com/teejays/matchapi/service/user/v1"
+)
+
+// DefaultPageSize is the number of candidates returned in a page when the request doesn't specify it
+var DefaultPageSize = 20
+
+// MaxPageSize is the maximum number of candidates that can be requested in a single page
+var MaxPageSize = 100
+
+// Request represents the parameters used to fetch a page of candidates
+type Request struct {
+	// Page is the 1-indexed page number
+	Page int
+	// PageSize is the number of candidates in the page
+	PageSize int
+	// Seed is used by the ranker to make the ordering deterministic, so that the pages are stable
+	Seed int64
+	// MinAge and MaxAge optionally narrow down the candidates to an age range; 0 means no limit
+	MinAge int
+	MaxAge int
+}
+
+// Page represents a page of candidates for the viewer
+type Page struct {
+	Candidates []user.ShareableProfileUser
+	Page       int
+	PageSize   int
+	Seed       int64
+	HasMore    bool
+}
+
+// Filter returns false if the candidate should not be shown to the viewer
+type Filter func(viewer *user.User, candidate *user.User) bool
+
+// filters are applied, in order, to every potential candidate
+var filters = []Filter{
+	standingFilter,
+	moderationFilter,
+	preferencesFilter,
+}
+
+// Validate returns error if the Request is not valid
+func (req Request) Validate() error {
+	if req.Page < 1 {
+		return fmt.Errorf("invalid page: should be greater than 0")
+	}
+	if req.PageSize < 1 || req.PageSize > MaxPageSize {
+		return fmt.Errorf("invalid page size: should be between 1 and %d", MaxPageSize)
+	}
+	if req.MinAge < 0 || req.MaxAge < 0 {
+		return fmt.Errorf("invalid age range: ages cannot be negative")
+	}
+	if req.MaxAge > 0 && req.MaxAge < req.MinAge {
+		return fmt.Errorf("invalid age range: max age cannot be less than min age")
+	}
+	return nil
+}
+
+// GetCandidates returns a page of users that the viewer could like. It excludes the viewer,
+// deleted users, and users that the viewer has already liked or passed on.
+func GetCandidates(viewerID pk.ID, req Request) (Page, error) {
+	var page = Page{
+		Candidates: []user.ShareableProfileUser{},
+		Page:       req.Page,
+		PageSize:   req.PageSize,
+		Seed:       req.Seed,
+	}
+
+	if err := req.Validate(); err != nil {
+		return page, err
+	}
+
+	viewer, err := user.GetUserByID(viewerID)
+	if err != nil {
+		return page, err
+	}
+	if err := viewer.CheckEmailVerified(); err != nil {
+		return page, err
+	}
+
+	candidates, err := getCandidates(viewer)
+	if err != nil {
+		return page, err
+	}
+
+	// Narrow down the candidates if an age range is requested
+	if req.MinAge > 0 || req.MaxAge > 0 {
+		candidates = user.FilterByAge(candidates, req.MinAge, req.MaxAge, false)
+	}
+
+	// Order the candidates using the configured ranker
+	ranker, err := GetRanker(RankerName)
+	if err != nil {
+		return page, err
+	}
+	candidates = ranker.Rank(viewer, candidates, req.Seed)
+
+	// Paginate
+	start := (req.Page - 1) * req.PageSize
+	end := start + req.PageSize
+	if start > len(candidates) {
+		start = len(candidates)
+	}
+	if end > len(candidates) {
+		end = len(candidates)
+	}
+	for i := range candidates[start:end] {
+		page.Candidates = append(page.Candidates, candidates[start+i].ShareWith(viewer))
+	}
+	page.HasMore = end < len(candidates)
+
+	return page, nil
+}
+
+// getCandidates returns all the users that the viewer can potentially see, in no particular order
+func getCandidates(viewer *user.User) ([]user.User, error) {
+
+	// If the viewer strictly wants users within a distance, only the nearby users need to be looked at
+	var users []user.User
+	var err error
+	p := viewer.Preferences
+	if viewer.Location != nil && p.MaxDistance > 0 && p.IsDealbreaker(user.DealbreakerDistance) {
+		users, err = user.GetUsersNearby(*viewer.Location, float64(p.MaxDistance))
+	} else {
+		users, err = user.GetActiveUsers()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Users that have already been liked or passed on should not show up again
+	actedOn, err := like.GetActedOnUserIDs(viewer.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Users that have blocked each other should never see each other
+	blocked, err := block.GetBlockedUserIDs(viewer.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []user.User
+	for i := range users {
+		u := &users[i]
+		if u.ID == viewer.ID || u.IsDeleted || actedOn[u.ID] || blocked[u.ID] {
+			continue
+		}
+		if !applyFilters(viewer, u) {
+			continue
+		}
+		candidates = append(candidates, *u)
+	}
+
+	clog.Debugf("Discover | getCandidates(): found %d candidates for user %d", len(candidates), viewer.ID)
+
+	return candidates, nil
+}
+
+// standingFilter hides the candidates who are currently suspended or banned
+func standingFilter(viewer *user.User, candidate *user.User) bool {
+	return candidate.CheckAccess(time.Now()) == nil
+}
+
+// moderationFilter hides the candidates whose profiles are waiting to be reviewed by a moderator
+func moderationFilter(viewer *user.User, candidate *user.User) bool {
+	return !candidate.IsFlagged()
+}
+
+// preferencesFilter makes sure that the viewer and the candidate satisfy each other's preferences
+func preferencesFilter(viewer *user.User, candidate *user.User) bool {
+	return user.IsMutualMatch(viewer, candidate)
+}
+
+func applyFilters(viewer *user.User, candidate *user.User) bool {
+	for _, f := range filters {
+		if !f(viewer, candidate) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,127 @@
+package discover
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/db"
+	"github.com/teejays/matchapi/lib/pk"
+	"github.com/teejays/matchapi/service/like/v2"
+	"github.com/teejays/matchapi/service/user/v1"
+)
+
+func init() {
+	clog.LogLevel = 7
+}
+
+func TestGetCandidates(t *testing.T) {
+
+	// Initialize the mock DB client
+	err := db.InitMockClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.DestoryMockClient()
+
+	// Populate the User mock data in DB
+	err = user.HelperPopulateMockData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// User 1 likes user 2, so user 2 should not show up for user 1 anymore
+	_, err = like.NewLike(1, like.BasicLike{ReceiverID: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		name        string
+		viewerID    pk.ID
+		req         Request
+		expectedIDs []pk.ID
+		shouldErr   bool
+	}{
+		{
+			name:      "invalid page should give an error",
+			viewerID:  1,
+			req:       Request{Page: 0, PageSize: 10},
+			shouldErr: true,
+		},
+		{
+			name:        "liked users and the viewer should be excluded",
+			viewerID:    1,
+			req:         Request{Page: 1, PageSize: 10},
+			expectedIDs: []pk.ID{3},
+		},
+		{
+			name:        "users that have not been acted on should be included",
+			viewerID:    2,
+			req:         Request{Page: 1, PageSize: 10},
+			expectedIDs: []pk.ID{1, 3},
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			page, err := GetCandidates(test.viewerID, test.req)
+			assert.Equal(t, test.shouldErr, err != nil)
+			if !test.shouldErr {
+				var ids []pk.ID
+				for _, c := range page.Candidates {
+					ids = append(ids, c.ID)
+				}
+				assert.ElementsMatch(t, test.expectedIDs, ids)
+			}
+		})
+	}
+}
+
+func TestGetCandidatesSuspended(t *testing.T) {
+
+	// Initialize the mock DB client
+	err := db.InitMockClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.DestoryMockClient()
+
+	// Populate the User mock data in DB
+	err = user.HelperPopulateMockData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Suspend user 3 and ban user 1, so that neither shows up for user 2
+	u, err := user.GetUserByID(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, u.Suspend(time.Now().Add(time.Hour)))
+	u, err = user.GetUserByID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, u.Ban())
+
+	page, err := GetCandidates(2, Request{Page: 1, PageSize: 10})
+	assert.NoError(t, err)
+	assert.Empty(t, page.Candidates)
+}
+
+func TestRandomRankerIsDeterministic(t *testing.T) {
+	var candidates = func() []user.User {
+		var users []user.User
+		for _, u := range user.MockUsers {
+			users = append(users, *u)
+		}
+		return users
+	}
+
+	a := RandomRanker{}.Rank(nil, candidates(), 42)
+	b := RandomRanker{}.Rank(nil, candidates(), 42)
+	assert.Equal(t, a, b)
+}
@@ -0,0 +1,72 @@
+package discover
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"github.com/teejays/matchapi/service/user/v1"
+)
+
+// Ranker orders the candidates for a viewer. Implementations should be deterministic for a given
+// seed, so that the pages stay stable while the viewer is paging through them.
+type Ranker interface {
+	Rank(viewer *user.User, candidates []user.User, seed int64) []user.User
+}
+
+// RankerName is the name of the ranker that is used to order the candidates
+var RankerName = "random"
+
+// rankers holds all the registered rankers by their names
+var rankers = map[string]Ranker{
+	"random": RandomRanker{},
+	"newest": NewestRanker{},
+}
+
+// RegisterRanker makes a ranker available under the provided name
+func RegisterRanker(name string, r Ranker) {
+	rankers[name] = r
+}
+
+// GetRanker returns the ranker registered under the provided name
+func GetRanker(name string) (Ranker, error) {
+	r, exists := rankers[name]
+	if !exists {
+		return nil, fmt.Errorf("no ranker registered with the name '%s'", name)
+	}
+	return r, nil
+}
+
+// RandomRanker shuffles the candidates using the seed
+type RandomRanker struct{}
+
+// Rank implements the Ranker interface
+func (RandomRanker) Rank(viewer *user.User, candidates []user.User, seed int64) []user.User {
+	// Start from a known order so that the shuffle only depends on the seed
+	sortByID(candidates)
+
+	r := rand.New(rand.NewSource(seed))
+	r.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+
+	return candidates
+}
+
+// NewestRanker orders the candidates so that the most recently joined users come first
+type NewestRanker struct{}
+
+// Rank implements the Ranker interface
+func (NewestRanker) Rank(viewer *user.User, candidates []user.User, seed int64) []user.User {
+	sortByID(candidates)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].DatetimeCreated.After(candidates[j].DatetimeCreated)
+	})
+	return candidates
+}
+
+func sortByID(users []user.User) {
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID < users[j].ID
+	})
+}
@@ -2,20 +2,34 @@ package like
 
 import (
 	"fmt"
-	"reflect"
+	"sort"
+	"sync"
 	"time"
 
-	"github.com/mitchellh/mapstructure"
 	"github.com/teejays/clog"
 
 	"github.com/teejays/matchapi/db"
 	"github.com/teejays/matchapi/lib/pk"
+	"github.com/teejays/matchapi/lib/pubsub"
+	"github.com/teejays/matchapi/lib/validate"
+	"github.com/teejays/matchapi/service/block/v1"
 	"github.com/teejays/matchapi/service/user/v1"
 )
 
+const (
+	KindNormal int = iota
+	KindSuper
+)
+
+// SuperLikeDailyQuota is the number of super likes that a user can give in a day. The day
+// starts and ends based on the time zone of the user.
+var SuperLikeDailyQuota = 1
+
 // BasicLike represents the part of Like struct that is generated by the user behavior
 type BasicLike struct {
 	ReceiverID pk.ID
+	// Kind differentiates a normal like from a super like; possible values are 0 (normal) and 1 (super)
+	Kind int
 }
 
 // Like represents the like action
@@ -29,28 +43,50 @@ type Like struct {
 
 // IncomingLike represents a Like object but information tailored towards the receiver of the like
 type IncomingLike struct {
-	Giver user.ShareableProfile
+	IsSuperLike bool
+	Giver       user.ShareableProfile
 	Like
 }
 
-// Validate returns error if the data in the BasicLike is not valid
+// SuperLikeQuotaError is returned when a user has used up all the super likes for the day
+type SuperLikeQuotaError struct {
+	Quota   int
+	ResetAt time.Time
+}
+
+func (e *SuperLikeQuotaError) Error() string {
+	return fmt.Sprintf("daily super like quota of %d has been used up; it resets at %s", e.Quota, e.ResetAt.Format(time.RFC3339))
+}
+
+// ErrReceiverNotFound is returned when the receiver of a like does not exist. It is also returned when
+// the receiver and the giver have blocked each other, so that a block can't be detected by liking a user.
+var ErrReceiverNotFound = fmt.Errorf("invalid ReceiverID: no user found with this userID")
+
+// Validate returns error if the data in the BasicLike is not valid. The fields that are not valid are
+// returned as validate.Errors; a receiver that doesn't exist is ErrReceiverNotFound.
 func (b BasicLike) Validate() error {
+	var v validate.Validator
 
 	// ReceiverID should be greater than zero
-	if b.ReceiverID < 1 {
-		return fmt.Errorf("invalid ReceiverID: should be greater than 0")
+	v.Check(b.ReceiverID >= 1, "ReceiverID", validate.CodeInvalid, "invalid ReceiverID: should be greater than 0")
+
+	// Kind should be one of the known kinds
+	v.Check(b.Kind == KindNormal || b.Kind == KindSuper, "Kind", validate.CodeInvalid, "invalid Kind: possible values are 0 (normal) and 1 (super)")
+
+	if err := v.Err(); err != nil {
+		return err
 	}
 
 	clog.Debugf("BasicLike.Validate(): ReceiverId: %v", b.ReceiverID)
 
 	// ReceiverID should be a valid user
 	u, err := user.GetUserByID(b.ReceiverID)
+	if db.IsNotExist(err) || (err == nil && u == nil) {
+		return ErrReceiverNotFound
+	}
 	if err != nil {
 		return fmt.Errorf("invalid ReceiverID: could not validate that a user exists with this userID: %v", err)
 	}
-	if u == nil {
-		return fmt.Errorf("invalid ReceiverID: no user found with this userID")
-	}
 
 	return nil
 
@@ -67,24 +103,128 @@ func NewLike(userID pk.ID, b BasicLike) (Like, error) {
 
 	// Validate that the data is okay
 	if err := b.Validate(); err != nil {
+		if err == ErrReceiverNotFound {
+			return l, err
+		}
 		return l, fmt.Errorf("could not validate the data: %v", err)
 	}
 
-	// Save the object in the DB
-	id, err := db.SaveNewEntity(db.LikeCollection, &l)
+	// The giver might need to verify their email first
+	giver, err := user.GetUserByID(userID)
+	if err != nil {
+		return l, err
+	}
+	if err := giver.CheckEmailVerified(); err != nil {
+		return l, err
+	}
+
+	// Users that have blocked each other cannot like each other; they get the same error as for a
+	// user that doesn't exist
+	blocked, err := block.IsBlocked(userID, b.ReceiverID)
+	if err != nil {
+		return l, err
+	}
+	if blocked {
+		return l, ErrReceiverNotFound
+	}
+
+	first, err := saveLike(&l)
 	if err != nil {
 		return l, err
 	}
 
 	var like Like
-	err = db.GetEntityByID(db.LikeCollection, id, &like)
+	err = db.GetEntityByID(db.LikeCollection, l.ID, &like)
 	if err != nil {
 		return like, err
 	}
 
+	publishLike(like, first)
+
 	return like, nil
 }
 
+// likeLock makes sure that the quotas are checked and the likes saved atomically, so that concurrent likes
+// can't go over the quotas or make the same match twice
+var likeLock sync.Mutex
+
+// saveLike saves the like if the giver hasn't used up their quotas. It returns true if it is the first like
+// of the giver for the receiver.
+func saveLike(l *Like) (bool, error) {
+	likeLock.Lock()
+	defer likeLock.Unlock()
+
+	// Super likes are limited by a daily quota
+	if l.Kind == KindSuper {
+		if err := checkSuperLikeQuota(l.GiverID, l.Datetime); err != nil {
+			return false, err
+		}
+	}
+
+	// Every like counts towards the rate limit of the giver
+	if err := checkQuota(l.GiverID, l.Datetime); err != nil {
+		return false, err
+	}
+
+	// Only the first like of the receiver can make a match
+	alreadyLiked, err := hasLiked(l.GiverID, l.ReceiverID)
+	if err != nil {
+		return false, err
+	}
+
+	// Save the object in the DB
+	id, err := db.SaveNewEntity(db.LikeCollection, l)
+	if err != nil {
+		return false, err
+	}
+	l.ID = id
+
+	// The like only uses up the quota once it has been saved
+	if err := recordQuota(l.GiverID, l.Datetime); err != nil {
+		clog.Errorf("Like | saveLike(): could not record like %d against the rate limit: %v", id, err)
+	}
+
+	return !alreadyLiked, nil
+}
+
+// publishLike lets the receiver know about the like, and both users know if the like made a match. Only the
+// first like of the receiver can make a match, since the users have already been told about it otherwise.
+func publishLike(l Like, first bool) {
+	giver, err := user.GetUserByID(l.GiverID)
+	if err != nil {
+		clog.Warnf("There was an error trying GetUserByID(%d): %v", l.GiverID, err)
+		return
+	}
+	receiver, err := user.GetUserByID(l.ReceiverID)
+	if err != nil {
+		clog.Warnf("There was an error trying GetUserByID(%d): %v", l.ReceiverID, err)
+		return
+	}
+
+	pubsub.Publish(pubsub.Event{
+		Type:   pubsub.EventLikeReceived,
+		UserID: receiver.ID,
+		Data: IncomingLike{
+			IsSuperLike: l.Kind == KindSuper,
+			Giver:       giver.ShareWith(receiver).ShareableProfile,
+			Like:        l,
+		},
+	})
+
+	if !first {
+		return
+	}
+	matched, err := IsMatch(giver.ID, receiver.ID)
+	if err != nil {
+		clog.Warnf("There was an error trying IsMatch(%d, %d): %v", giver.ID, receiver.ID, err)
+		return
+	}
+	if matched {
+		pubsub.Publish(pubsub.Event{Type: pubsub.EventMatch, UserID: giver.ID, Data: receiver.ShareWith(giver)})
+		pubsub.Publish(pubsub.Event{Type: pubsub.EventMatch, UserID: receiver.ID, Data: giver.ShareWith(receiver)})
+	}
+}
+
 // GetIncomingLikesByUserID returns all the users that have like the provided UserID
 func GetIncomingLikesByUserID(id pk.ID) ([]IncomingLike, error) {
 	var incomingLikes = []IncomingLike{}
@@ -95,57 +235,132 @@ func GetIncomingLikesByUserID(id pk.ID) ([]IncomingLike, error) {
 		return nil, err
 	}
 
-	//
+	// The receiver is needed to calculate the distance to each of the givers
+	receiver, err := user.GetUserByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Likes from blocked users, in either direction, are not shown
+	blockedIDs, err := block.GetBlockedUserIDs(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Likes from suspended or banned users are hidden until they are allowed back
+	now := time.Now()
 	for _, l := range likes {
 		if l.ReceiverID != id {
 			panic("an unexpected entity found in the search result")
 		}
+		if blockedIDs[l.GiverID] {
+			continue
+		}
 		giver, err := user.GetUserByID(l.GiverID)
 		if err != nil {
 			clog.Warnf("There was an error trying GetUserByID(%d): %v", l.GiverID, err)
 			continue
 		}
+		if giver.CheckAccess(now) != nil {
+			continue
+		}
 		var incomingLike IncomingLike
 		incomingLike.Like = l
-		incomingLike.Giver = giver.ShareableProfile
+		incomingLike.IsSuperLike = l.Kind == KindSuper
+		incomingLike.Giver = giver.ShareWith(receiver).ShareableProfile
 		incomingLikes = append(incomingLikes, incomingLike)
 	}
 
+	// Super likes should show up before the normal likes
+	sort.SliceStable(incomingLikes, func(i, j int) bool {
+		return incomingLikes[i].IsSuperLike && !incomingLikes[j].IsSuperLike
+	})
+
 	return incomingLikes, nil
 }
 
-func getLikesByReceiverID(id pk.ID) ([]Like, error) {
+// IsMatch returns true if the two users have liked each other
+func IsMatch(a, b pk.ID) (bool, error) {
+	for _, pair := range [][2]pk.ID{{a, b}, {b, a}} {
+		liked, err := hasLiked(pair[0], pair[1])
+		if err != nil {
+			return false, err
+		}
+		if !liked {
+			return false, nil
+		}
+	}
+	return true, nil
+}
 
-	// Run the query
-	result, err := db.Query(db.LikeCollection, fmt.Sprintf("ReceiverID:%d", id))
+// hasLiked returns true if the giver has a like for the receiver that hasn't been deleted
+func hasLiked(giverID, receiverID pk.ID) (bool, error) {
+	likes, err := getLikesByQuery(fmt.Sprintf("GiverID:%d+ReceiverID:%d", giverID, receiverID))
 	if err != nil {
-		return nil, err
+		return false, err
 	}
-
-	// Convert the result into likes
-	var likes []Like
-	stringToDateTimeHook := func(
-		f reflect.Type,
-		t reflect.Type,
-		data interface{}) (interface{}, error) {
-		if t == reflect.TypeOf(time.Time{}) && f == reflect.TypeOf("") {
-			return time.Parse(time.RFC3339, data.(string))
+	for _, l := range likes {
+		if !l.IsDeleted {
+			return true, nil
 		}
+	}
+	return false, nil
+}
+
+// checkSuperLikeQuota returns a SuperLikeQuotaError if the user has already given the maximum
+// number of super likes allowed for the day that includes t
+func checkSuperLikeQuota(userID pk.ID, t time.Time) error {
+
+	giver, err := user.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+
+	// The day is based on the time zone of the giver
+	t = t.In(giver.TimeLocation())
+	dayStart := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	dayEnd := dayStart.AddDate(0, 0, 1)
 
-		return data, nil
+	likes, err := getLikesByGiverID(userID)
+	if err != nil {
+		return err
 	}
-	config := mapstructure.DecoderConfig{
-		DecodeHook: stringToDateTimeHook,
-		Result:     &likes,
+
+	var count int
+	for _, l := range likes {
+		if l.Kind == KindSuper && !l.IsDeleted && !l.Datetime.Before(dayStart) {
+			count++
+		}
 	}
 
-	decoder, err := mapstructure.NewDecoder(&config)
+	if count >= SuperLikeDailyQuota {
+		return &SuperLikeQuotaError{Quota: SuperLikeDailyQuota, ResetAt: dayEnd}
+	}
+
+	return nil
+}
+
+func getLikesByReceiverID(id pk.ID) ([]Like, error) {
+	return getLikesByQuery(fmt.Sprintf("ReceiverID:%d", id))
+}
+
+func getLikesByGiverID(id pk.ID) ([]Like, error) {
+	return getLikesByQuery(fmt.Sprintf("GiverID:%d", id))
+}
+
+func getLikesByQuery(query string) ([]Like, error) {
+
+	// Run the query
+	result, err := db.Query(db.LikeCollection, query)
 	if err != nil {
 		return nil, err
 	}
-	err = decoder.Decode(result)
+
+	// Convert the result into likes
+	var likes []Like
+	err = db.DecodeQueryResult(result, &likes)
 	if err != nil {
-		return nil, fmt.Errorf("could not convert map to a struct: %v", err)
+		return nil, err
 	}
 
 	clog.Debugf("%+v", likes)
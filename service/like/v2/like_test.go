@@ -1,7 +1,19 @@
 package like
 
 import (
+	"sync"
+	"sync/atomic"
+	"testing"
 	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/db"
+	"github.com/teejays/matchapi/lib/pk"
+	"github.com/teejays/matchapi/lib/pubsub"
+	"github.com/teejays/matchapi/service/block/v1"
+	"github.com/teejays/matchapi/service/user/v1"
 )
 
 var mockLikes = map[int]Like{
@@ -27,3 +39,237 @@ var mockLikes = map[int]Like{
 		},
 	},
 }
+
+func init() {
+	clog.LogLevel = 7
+}
+
+func TestNewLikeSuperLikeQuota(t *testing.T) {
+
+	// Initialize the mock DB client
+	err := db.InitMockClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.DestoryMockClient()
+
+	// Populate the User mock data in DB
+	err = user.HelperPopulateMockData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Use up the quota
+	for i := 0; i < SuperLikeDailyQuota; i++ {
+		_, err = NewLike(1, BasicLike{ReceiverID: 2, Kind: KindSuper})
+		assert.NoError(t, err)
+	}
+
+	// Next super like should be over the quota
+	_, err = NewLike(1, BasicLike{ReceiverID: 3, Kind: KindSuper})
+	qErr, ok := err.(*SuperLikeQuotaError)
+	if assert.True(t, ok, "expected a SuperLikeQuotaError, got %v", err) {
+		assert.True(t, qErr.ResetAt.After(time.Now()))
+	}
+
+	// Normal likes should not be affected
+	_, err = NewLike(1, BasicLike{ReceiverID: 3, Kind: KindNormal})
+	assert.NoError(t, err)
+}
+
+func TestNewLikeSuperLikeQuotaConcurrently(t *testing.T) {
+
+	// Initialize the mock DB client
+	err := db.InitMockClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.DestoryMockClient()
+
+	// Populate the User mock data in DB
+	err = user.HelperPopulateMockData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Concurrent super likes should not go over the quota
+	var wg sync.WaitGroup
+	var succeeded int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(receiverID pk.ID) {
+			defer wg.Done()
+			if _, err := NewLike(1, BasicLike{ReceiverID: receiverID, Kind: KindSuper}); err == nil {
+				atomic.AddInt32(&succeeded, 1)
+			}
+		}(pk.ID(2 + i%2))
+	}
+	wg.Wait()
+	assert.EqualValues(t, SuperLikeDailyQuota, succeeded)
+}
+
+func TestNewLikeRateLimit(t *testing.T) {
+
+	// Initialize the mock DB client
+	err := db.InitMockClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.DestoryMockClient()
+
+	// Populate the User mock data in DB
+	err = user.HelperPopulateMockData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Lower the rate limit for the test
+	defer func(limit int) { LikeRateLimit = limit }(LikeRateLimit)
+	LikeRateLimit = 2
+
+	for i := 0; i < LikeRateLimit; i++ {
+		_, err = NewLike(2, BasicLike{ReceiverID: 3})
+		assert.NoError(t, err)
+	}
+
+	// The budget should be used up
+	q, err := GetQuotaByUserID(2)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, q.Remaining)
+	assert.True(t, q.ResetAt.After(time.Now()))
+
+	_, err = NewLike(2, BasicLike{ReceiverID: 1})
+	_, ok := err.(*RateLimitError)
+	assert.True(t, ok, "expected a RateLimitError, got %v", err)
+
+	// Other users should have their own budget
+	q, err = GetQuotaByUserID(3)
+	assert.NoError(t, err)
+	assert.Equal(t, LikeRateLimit, q.Remaining)
+
+	// The budget is only used up once the like has been saved, not when it is checked
+	assert.NoError(t, checkQuota(3, time.Now()))
+	q, err = GetQuotaByUserID(3)
+	assert.NoError(t, err)
+	assert.Equal(t, LikeRateLimit, q.Remaining)
+	assert.NoError(t, recordQuota(3, time.Now()))
+	q, err = GetQuotaByUserID(3)
+	assert.NoError(t, err)
+	assert.Equal(t, LikeRateLimit-1, q.Remaining)
+}
+
+func TestNewLikeBlocked(t *testing.T) {
+
+	// Initialize the mock DB client
+	err := db.InitMockClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.DestoryMockClient()
+
+	// Populate the User mock data in DB
+	err = user.HelperPopulateMockData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// User 2 likes user 1, and then user 1 blocks user 2
+	_, err = NewLike(2, BasicLike{ReceiverID: 1})
+	assert.NoError(t, err)
+	_, err = block.NewBlock(1, block.BasicBlock{BlockedID: 2})
+	assert.NoError(t, err)
+
+	// The like should no longer show up for user 1
+	likes, err := GetIncomingLikesByUserID(1)
+	assert.NoError(t, err)
+	assert.Empty(t, likes)
+
+	// Neither user should be able to like the other, and the error should not reveal the block
+	_, err = NewLike(2, BasicLike{ReceiverID: 1})
+	assert.Equal(t, ErrReceiverNotFound, err)
+	_, err = NewLike(1, BasicLike{ReceiverID: 2})
+	assert.Equal(t, ErrReceiverNotFound, err)
+	_, err = NewLike(1, BasicLike{ReceiverID: 199999})
+	assert.Equal(t, ErrReceiverNotFound, err)
+}
+
+func TestGetIncomingLikesSuspended(t *testing.T) {
+
+	// Initialize the mock DB client
+	err := db.InitMockClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.DestoryMockClient()
+
+	// Populate the User mock data in DB
+	err = user.HelperPopulateMockData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// User 2 likes user 1, and then user 2 gets suspended
+	_, err = NewLike(2, BasicLike{ReceiverID: 1})
+	assert.NoError(t, err)
+	giver, err := user.GetUserByID(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, giver.Suspend(time.Now().Add(time.Hour)))
+
+	// The like should be hidden while the suspension lasts
+	likes, err := GetIncomingLikesByUserID(1)
+	assert.NoError(t, err)
+	assert.Empty(t, likes)
+
+	// ... and show up again once it is lifted
+	assert.NoError(t, giver.Unsuspend())
+	likes, err = GetIncomingLikesByUserID(1)
+	assert.NoError(t, err)
+	assert.Len(t, likes, 1)
+}
+
+func TestNewLikeEvents(t *testing.T) {
+
+	// Initialize the mock DB client
+	err := db.InitMockClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.DestoryMockClient()
+
+	// Populate the User mock data in DB
+	err = user.HelperPopulateMockData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub1 := pubsub.Subscribe(1)
+	defer sub1.Close()
+	sub2 := pubsub.Subscribe(2)
+	defer sub2.Close()
+
+	// The receiver should be told about the like
+	_, err = NewLike(2, BasicLike{ReceiverID: 1})
+	assert.NoError(t, err)
+	e := <-sub1.C
+	assert.Equal(t, pubsub.EventLikeReceived, e.Type)
+	if assert.IsType(t, IncomingLike{}, e.Data) {
+		assert.Equal(t, pk.ID(2), e.Data.(IncomingLike).GiverID)
+	}
+	assert.Len(t, sub2.C, 0)
+
+	// Liking back makes a match, which both users should be told about
+	_, err = NewLike(1, BasicLike{ReceiverID: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, pubsub.EventLikeReceived, (<-sub2.C).Type)
+	assert.Equal(t, pubsub.EventMatch, (<-sub2.C).Type)
+	assert.Equal(t, pubsub.EventMatch, (<-sub1.C).Type)
+
+	// Liking again doesn't make the match again
+	_, err = NewLike(1, BasicLike{ReceiverID: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, pubsub.EventLikeReceived, (<-sub2.C).Type)
+	assert.Len(t, sub2.C, 0)
+	assert.Len(t, sub1.C, 0)
+}
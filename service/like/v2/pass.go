@@ -0,0 +1,99 @@
+package like
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/teejays/matchapi/db"
+	"github.com/teejays/matchapi/lib/pk"
+	"github.com/teejays/matchapi/service/user/v1"
+)
+
+// BasicPass represents the part of Pass struct that is generated by the user behavior
+type BasicPass struct {
+	ReceiverID pk.ID
+}
+
+// Pass represents the action of a user deciding not to like another user
+type Pass struct {
+	ID        pk.ID
+	GiverID   pk.ID
+	Datetime  time.Time
+	BasicPass `mapstructure:",squash"`
+}
+
+// Validate returns error if the data in the BasicPass is not valid
+func (b BasicPass) Validate() error {
+
+	// ReceiverID should be greater than zero
+	if b.ReceiverID < 1 {
+		return fmt.Errorf("invalid ReceiverID: should be greater than 0")
+	}
+
+	// ReceiverID should be a valid user
+	_, err := user.GetUserByID(b.ReceiverID)
+	if err != nil {
+		return fmt.Errorf("invalid ReceiverID: could not validate that a user exists with this userID: %v", err)
+	}
+
+	return nil
+}
+
+// NewPass registers a new pass in the database
+func NewPass(userID pk.ID, b BasicPass) (Pass, error) {
+
+	// Create a new Pass object
+	var p Pass
+	p.BasicPass = b
+	p.GiverID = userID
+	p.Datetime = time.Now()
+
+	// Validate that the data is okay
+	if err := b.Validate(); err != nil {
+		return p, fmt.Errorf("could not validate the data: %v", err)
+	}
+
+	// Save the object in the DB
+	id, err := db.SaveNewEntity(db.PassCollection, &p)
+	if err != nil {
+		return p, err
+	}
+
+	var pass Pass
+	err = db.GetEntityByID(db.PassCollection, id, &pass)
+	if err != nil {
+		return pass, err
+	}
+
+	return pass, nil
+}
+
+// GetActedOnUserIDs returns the IDs of all the users that the provided user has either liked or passed on
+func GetActedOnUserIDs(id pk.ID) (map[pk.ID]bool, error) {
+	var ids = make(map[pk.ID]bool)
+
+	likes, err := getLikesByGiverID(id)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range likes {
+		if !l.IsDeleted {
+			ids[l.ReceiverID] = true
+		}
+	}
+
+	result, err := db.Query(db.PassCollection, fmt.Sprintf("GiverID:%d", id))
+	if err != nil {
+		return nil, err
+	}
+	var passes []Pass
+	err = db.DecodeQueryResult(result, &passes)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range passes {
+		ids[p.ReceiverID] = true
+	}
+
+	return ids, nil
+}
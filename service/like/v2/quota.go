@@ -0,0 +1,140 @@
+package like
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/db"
+	"github.com/teejays/matchapi/lib/pk"
+)
+
+// LikeRateLimit is the maximum number of likes that a user can give within the LikeRateWindow
+var LikeRateLimit = 100
+
+// LikeRateWindow is the rolling window over which the LikeRateLimit is applied
+var LikeRateWindow = 24 * time.Hour
+
+// Quota represents the like budget of a user at a given point in time
+type Quota struct {
+	Limit     int
+	Remaining int
+	// ResetAt is the time at which the next like will be added back to the budget. It is equal
+	// to the current time if the budget is already full.
+	ResetAt time.Time
+}
+
+// RateLimitError is returned when a user has used up all the likes for the current window
+type RateLimitError struct {
+	Quota
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("like rate limit of %d per %s has been reached; next like is available at %s", e.Limit, LikeRateWindow, e.ResetAt.Format(time.RFC3339))
+}
+
+// likeCounter keeps track of the times at which a user has given likes. It is persisted in the database,
+// keyed by the ID of the user, so that the rate limit survives server restarts.
+type likeCounter struct {
+	ID         pk.ID
+	Timestamps []time.Time
+}
+
+// counterLock makes sure that the read-modify-write of the like counters happens atomically
+var counterLock sync.Mutex
+
+// GetQuotaByUserID returns the current like budget of the user
+func GetQuotaByUserID(userID pk.ID) (Quota, error) {
+	counterLock.Lock()
+	defer counterLock.Unlock()
+
+	now := time.Now()
+	c, err := getLikeCounter(userID, now)
+	if err != nil {
+		return Quota{}, err
+	}
+
+	return c.quota(now), nil
+}
+
+// checkQuota returns a RateLimitError if the user has already reached the rate limit at time t
+func checkQuota(userID pk.ID, t time.Time) error {
+	counterLock.Lock()
+	defer counterLock.Unlock()
+
+	c, err := getLikeCounter(userID, t)
+	if err != nil {
+		return err
+	}
+
+	q := c.quota(t)
+	if q.Remaining < 1 {
+		return &RateLimitError{q}
+	}
+
+	return nil
+}
+
+// recordQuota records a like given by the user at time t, once it has been saved
+func recordQuota(userID pk.ID, t time.Time) error {
+	counterLock.Lock()
+	defer counterLock.Unlock()
+
+	c, err := getLikeCounter(userID, t)
+	if err != nil {
+		return err
+	}
+
+	c.Timestamps = append(c.Timestamps, t)
+
+	return db.SaveEntityByID(db.LikeCounterCollection, userID, &c)
+}
+
+// getLikeCounter fetches the like counter of the user from the database and drops the timestamps
+// that fall outside of the rolling window ending at t
+func getLikeCounter(userID pk.ID, t time.Time) (likeCounter, error) {
+	var c likeCounter
+	err := db.GetEntityByID(db.LikeCounterCollection, userID, &c)
+	if db.IsNotExist(err) {
+		clog.Debugf("Like | getLikeCounter(): no counter found for user %d, starting a new one", userID)
+		return likeCounter{ID: userID}, nil
+	}
+	if err != nil {
+		return c, err
+	}
+
+	windowStart := t.Add(-LikeRateWindow)
+	var timestamps []time.Time
+	for _, ts := range c.Timestamps {
+		if ts.After(windowStart) {
+			timestamps = append(timestamps, ts)
+		}
+	}
+	c.Timestamps = timestamps
+
+	return c, nil
+}
+
+// quota calculates the like budget based on the counter at time t. It assumes that the
+// counter only contains the timestamps within the current window.
+func (c likeCounter) quota(t time.Time) Quota {
+	q := Quota{
+		Limit:     LikeRateLimit,
+		Remaining: LikeRateLimit - len(c.Timestamps),
+		ResetAt:   t,
+	}
+	if q.Remaining < 0 {
+		q.Remaining = 0
+	}
+
+	// The oldest like in the window is the next one to expire
+	for i, ts := range c.Timestamps {
+		if i == 0 || ts.Add(LikeRateWindow).Before(q.ResetAt) {
+			q.ResetAt = ts.Add(LikeRateWindow)
+		}
+	}
+
+	return q
+}
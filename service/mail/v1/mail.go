@@ -0,0 +1,187 @@
+package mail
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/db"
+	"github.com/teejays/matchapi/lib/mailer"
+	"github.com/teejays/matchapi/lib/pk"
+)
+
+// The statuses of an email in the outbox
+const (
+	StatusPending = "pending"
+	StatusSent    = "sent"
+	StatusFailed  = "failed"
+)
+
+// Sender is used to send the emails. Emails stay in the outbox until it is set.
+var Sender mailer.Sender
+
+// From is the address that the emails are sent from
+var From = "Match API <no-reply@matchapi.local>"
+
+// MaxAttempts is the number of times an email is tried before it is marked as failed
+var MaxAttempts = 5
+
+// BaseBackoff is how long to wait before retrying an email for the first time. The wait doubles with every
+// attempt, up to MaxBackoff.
+var BaseBackoff = 30 * time.Second
+
+// MaxBackoff is the longest wait between two attempts
+var MaxBackoff = time.Hour
+
+// Email is an email in the outbox
+type Email struct {
+	ID             pk.ID
+	mailer.Message `mapstructure:",squash"`
+	Template       string
+	Status         string
+	Attempts       int
+	// NextAttemptAt is when the email should be tried next, if it is pending
+	NextAttemptAt time.Time
+	LastError     string
+	Datetime      time.Time
+	SentAt        time.Time
+}
+
+// Enqueue renders the template with the data and saves the email in the outbox, to be sent by ProcessOutbox
+func Enqueue(to string, templateName string, data interface{}) (Email, error) {
+	var e Email
+
+	tmpl, exists := templates[templateName]
+	if !exists {
+		return e, fmt.Errorf("no email template registered with the name '%s'", templateName)
+	}
+
+	m, err := tmpl.Render(data)
+	if err != nil {
+		return e, fmt.Errorf("could not render the '%s' email template: %v", templateName, err)
+	}
+	m.From = From
+	m.To = to
+	if err := m.Validate(); err != nil {
+		return e, err
+	}
+
+	now := time.Now()
+	e = Email{
+		Message:       m,
+		Template:      templateName,
+		Status:        StatusPending,
+		NextAttemptAt: now,
+		Datetime:      now,
+	}
+	id, err := db.SaveNewEntity(db.OutboxCollection, &e)
+	if err != nil {
+		return e, err
+	}
+	e.ID = id
+
+	clog.Debugf("Mail | Enqueue(): queued a '%s' email %d", templateName, id)
+
+	return e, nil
+}
+
+// GetEmailByID returns the email from the outbox
+func GetEmailByID(id pk.ID) (Email, error) {
+	var e Email
+	err := db.GetEntityByID(db.OutboxCollection, id, &e)
+	return e, err
+}
+
+// processLock makes sure the outbox is not processed twice at the same time, so that no email is sent twice
+var processLock sync.Mutex
+
+// ProcessOutbox tries to send the pending emails that are due at t. It returns the number of emails sent.
+func ProcessOutbox(t time.Time) (int, error) {
+	processLock.Lock()
+	defer processLock.Unlock()
+
+	if Sender == nil {
+		return 0, fmt.Errorf("no mail sender has been configured")
+	}
+
+	result, err := db.Query(db.OutboxCollection, fmt.Sprintf("Status:%s", StatusPending))
+	if err != nil {
+		return 0, err
+	}
+	var emails []Email
+	err = db.DecodeQueryResult(result, &emails)
+	if err != nil {
+		return 0, err
+	}
+
+	var sent int
+	for _, e := range emails {
+		if e.NextAttemptAt.After(t) {
+			continue
+		}
+
+		e.Attempts++
+		err := Sender.Send(e.Message)
+		if err == nil {
+			e.Status = StatusSent
+			e.SentAt = t
+			e.LastError = ""
+			sent++
+		} else {
+			e.LastError = err.Error()
+			if e.Attempts >= MaxAttempts {
+				e.Status = StatusFailed
+				clog.Errorf("Mail | ProcessOutbox(): giving up on email %d after %d attempts: %v", e.ID, e.Attempts, err)
+			} else {
+				e.NextAttemptAt = t.Add(Backoff(e.Attempts))
+				clog.Warnf("Mail | ProcessOutbox(): could not send email %d, retrying at %s: %v", e.ID, e.NextAttemptAt, err)
+			}
+		}
+
+		err = db.SaveEntityByID(db.OutboxCollection, e.ID, e)
+		if err != nil {
+			return sent, err
+		}
+	}
+
+	return sent, nil
+}
+
+// Backoff returns how long to wait before retrying an email that has failed the provided number of attempts
+func Backoff(attempts int) time.Duration {
+	d := BaseBackoff
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= MaxBackoff {
+			return MaxBackoff
+		}
+	}
+	return d
+}
+
+// StartWorker processes the outbox every interval, in the background, until the returned function is called
+func StartWorker(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case t := <-ticker.C:
+				if _, err := ProcessOutbox(t); err != nil {
+					clog.Errorf("Mail | worker: %v", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
@@ -0,0 +1,98 @@
+package mail
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/db"
+	"github.com/teejays/matchapi/lib/mailer"
+)
+
+func init() {
+	clog.LogLevel = 7
+}
+
+func TestProcessOutbox(t *testing.T) {
+
+	// Initialize the mock DB client
+	err := db.InitMockClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.DestoryMockClient()
+
+	sender := &mailer.MemorySender{Err: fmt.Errorf("server is down")}
+	Sender = sender
+	defer func() { Sender = nil }()
+
+	_, err = Enqueue("jane@email.com", "unknown", nil)
+	assert.Error(t, err)
+
+	e, err := Enqueue("jane@email.com", TemplateVerification, LinkData{Name: "Jane", Link: "https://example.com/verify?token=abc"})
+	assert.NoError(t, err)
+	assert.Equal(t, StatusPending, e.Status)
+
+	// The email should be retried with a growing backoff while the server is down
+	now := time.Now()
+	sent, err := ProcessOutbox(now)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, sent)
+	e, err = GetEmailByID(e.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, e.Attempts)
+	assert.Equal(t, StatusPending, e.Status)
+	assert.True(t, e.NextAttemptAt.Equal(now.Add(BaseBackoff)))
+
+	// It should not be retried before the backoff is over
+	sent, err = ProcessOutbox(now.Add(BaseBackoff / 2))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, sent)
+	e, err = GetEmailByID(e.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, e.Attempts)
+
+	// Once the server is back, the email should be sent
+	sender.Err = nil
+	sent, err = ProcessOutbox(now.Add(BaseBackoff))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	e, err = GetEmailByID(e.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusSent, e.Status)
+	if assert.Len(t, sender.Messages(), 1) {
+		m := sender.Messages()[0]
+		assert.Equal(t, "jane@email.com", m.To)
+		assert.Equal(t, From, m.From)
+		assert.Contains(t, m.Text, "https://example.com/verify?token=abc")
+	}
+
+	// Sent emails are not sent again
+	sent, err = ProcessOutbox(now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, sent)
+
+	// Emails are given up on after MaxAttempts
+	sender.Err = fmt.Errorf("server is down")
+	e, err = Enqueue("john@email.com", TemplateNotification, NotificationData{Name: "John", Title: "It's a match!", Body: "You and Jane have liked each other"})
+	assert.NoError(t, err)
+	for i := 0; i < MaxAttempts; i++ {
+		_, err = ProcessOutbox(now.Add(time.Duration(i+1) * MaxBackoff))
+		assert.NoError(t, err)
+	}
+	e, err = GetEmailByID(e.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusFailed, e.Status)
+	assert.Equal(t, MaxAttempts, e.Attempts)
+	assert.Equal(t, "server is down", e.LastError)
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, BaseBackoff, Backoff(1))
+	assert.Equal(t, 2*BaseBackoff, Backoff(2))
+	assert.Equal(t, 4*BaseBackoff, Backoff(3))
+	assert.Equal(t, MaxBackoff, Backoff(100))
+}
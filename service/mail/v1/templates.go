@@ -0,0 +1,60 @@
+package mail
+
+import (
+	"github.com/teejays/matchapi/lib/mailer"
+	"github.com/teejays/matchapi/service/notification/v1"
+	"github.com/teejays/matchapi/service/user/v1"
+)
+
+// The names of the email templates
+const (
+	TemplateVerification  = "verification"
+	TemplatePasswordReset = "password_reset"
+	TemplateNotification  = "notification"
+)
+
+// LinkData is the data for the emails that ask the user to follow a link, e.g. to verify their email
+type LinkData struct {
+	Name string
+	Link string
+}
+
+// NotificationData is the data for the notification emails
+type NotificationData struct {
+	Name  string
+	Title string
+	Body  string
+}
+
+// templates holds all the registered email templates by their names
+var templates = map[string]*mailer.Template{
+	TemplateVerification: mailer.MustNewTemplate(TemplateVerification,
+		`Please verify your email`,
+		"Hi {{.Name}},\n\nPlease verify your email by opening the link below:\n\n{{.Link}}\n",
+		`<p>Hi {{.Name}},</p><p>Please verify your email by opening the link below:</p><p><a href="{{.Link}}">Verify my email</a></p>`,
+	),
+	TemplatePasswordReset: mailer.MustNewTemplate(TemplatePasswordReset,
+		`Reset your password`,
+		"Hi {{.Name}},\n\nYou can reset your password by opening the link below. If you didn't ask for it, you can ignore this email.\n\n{{.Link}}\n",
+		`<p>Hi {{.Name}},</p><p>You can reset your password by opening the link below. If you didn't ask for it, you can ignore this email.</p><p><a href="{{.Link}}">Reset my password</a></p>`,
+	),
+	TemplateNotification: mailer.MustNewTemplate(TemplateNotification,
+		`{{.Title}}`,
+		"Hi {{.Name}},\n\n{{.Body}}\n",
+		`<p>Hi {{.Name}},</p><p>{{.Body}}</p>`,
+	),
+}
+
+// RegisterTemplate makes an email template available under the provided name
+func RegisterTemplate(name string, t *mailer.Template) {
+	templates[name] = t
+}
+
+// NotificationChannel is a notification.Channel that sends the notifications by email, through the outbox
+type NotificationChannel struct{}
+
+// Send implements the notification.Channel interface
+func (NotificationChannel) Send(u *user.User, n notification.Notification) error {
+	_, err := Enqueue(u.Email, TemplateNotification, NotificationData{Name: u.FirstName, Title: n.Title, Body: n.Body})
+	return err
+}
@@ -0,0 +1,475 @@
+package message
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/db"
+	"github.com/teejays/matchapi/lib/pk"
+	"github.com/teejays/matchapi/lib/pubsub"
+	"github.com/teejays/matchapi/service/block/v1"
+	"github.com/teejays/matchapi/service/like/v2"
+	"github.com/teejays/matchapi/service/user/v1"
+)
+
+// MaxMessageLength is the maximum number of characters in a message
+var MaxMessageLength = 2000
+
+// DefaultPageSize is the number of messages returned in a page when the request doesn't specify it
+var DefaultPageSize = 50
+
+// MaxPageSize is the maximum number of messages that can be requested in a single page
+var MaxPageSize = 200
+
+// Conversation represents the chat between two users that have liked each other. The users are
+// stored in order (UserAID < UserBID) so that a pair of users has a single conversation.
+type Conversation struct {
+	ID       pk.ID
+	UserAID  pk.ID
+	UserBID  pk.ID
+	Datetime time.Time
+	// ReadMarkers keep track of how far each of the users has read the conversation
+	ReadMarkers []ReadMarker
+	// LastMessageDatetime is the time of the latest message, or zero if no message has been sent yet
+	LastMessageDatetime time.Time
+}
+
+// ReadMarker represents the point in a conversation up to which a user has read the messages
+type ReadMarker struct {
+	UserID     pk.ID
+	LastReadAt time.Time
+}
+
+// BasicMessage represents the part of Message struct that is generated by the user behavior
+type BasicMessage struct {
+	Text string
+}
+
+// Message represents a message sent by a user in a conversation
+type Message struct {
+	ID             pk.ID
+	ConversationID pk.ID
+	SenderID       pk.ID
+	Datetime       time.Time
+	BasicMessage   `mapstructure:",squash"`
+}
+
+// ConversationSummary represents a Conversation along with the information that a participant needs
+// to list their conversations
+type ConversationSummary struct {
+	Conversation
+	With        user.ShareableProfileUser
+	LastMessage *Message
+	UnreadCount int
+}
+
+// Request represents the parameters used to fetch a page of messages
+type Request struct {
+	// Page is the 1-indexed page number. Page 1 has the most recent messages.
+	Page int
+	// PageSize is the number of messages in the page
+	PageSize int
+}
+
+// Page represents a page of the messages in a conversation, most recent first
+type Page struct {
+	Messages []Message
+	Page     int
+	PageSize int
+	HasMore  bool
+}
+
+// ErrConversationNotFound is returned when a conversation does not exist, or when the user is not a
+// participant of it
+var ErrConversationNotFound = fmt.Errorf("conversation not found")
+
+// ErrNotMatched is returned when the users have not liked each other, or have blocked each other
+var ErrNotMatched = fmt.Errorf("messages can only be sent between users that have liked each other")
+
+var ErrCannotMessageSelf = fmt.Errorf("invalid UserID: users cannot start a conversation with themselves")
+
+// Validate returns error if the data in the BasicMessage is not valid
+func (b BasicMessage) Validate() error {
+	if strings.TrimSpace(b.Text) == "" {
+		return fmt.Errorf("invalid Text: message cannot be empty")
+	}
+	if len([]rune(b.Text)) > MaxMessageLength {
+		return fmt.Errorf("invalid Text: message cannot be longer than %d characters", MaxMessageLength)
+	}
+	return nil
+}
+
+// Validate returns error if the Request is not valid
+func (req Request) Validate() error {
+	if req.Page < 1 {
+		return fmt.Errorf("invalid page: should be greater than 0")
+	}
+	if req.PageSize < 1 || req.PageSize > MaxPageSize {
+		return fmt.Errorf("invalid page size: should be between 1 and %d", MaxPageSize)
+	}
+	return nil
+}
+
+// HasParticipant returns true if the user is one of the two users of the conversation
+func (c Conversation) HasParticipant(userID pk.ID) bool {
+	return c.UserAID == userID || c.UserBID == userID
+}
+
+// OtherParticipant returns the ID of the user that the provided user is talking to
+func (c Conversation) OtherParticipant(userID pk.ID) pk.ID {
+	if c.UserAID == userID {
+		return c.UserBID
+	}
+	return c.UserAID
+}
+
+// LastReadAt returns the time up to which the user has read the conversation
+func (c Conversation) LastReadAt(userID pk.ID) time.Time {
+	for _, m := range c.ReadMarkers {
+		if m.UserID == userID {
+			return m.LastReadAt
+		}
+	}
+	return time.Time{}
+}
+
+// conversationLock makes sure that a pair of users only gets one conversation, and that the read-modify-write
+// of the conversations happens atomically
+var conversationLock sync.Mutex
+
+// GetOrCreateConversation returns the conversation between the two users, creating it if needed. The
+// users should have liked each other.
+func GetOrCreateConversation(userID, otherID pk.ID) (Conversation, error) {
+	if userID == otherID {
+		return Conversation{}, ErrCannotMessageSelf
+	}
+	if err := checkMatch(userID, otherID); err != nil {
+		return Conversation{}, err
+	}
+
+	a, b := userID, otherID
+	if b < a {
+		a, b = b, a
+	}
+
+	conversationLock.Lock()
+	defer conversationLock.Unlock()
+
+	// Return the existing conversation, if any
+	convs, err := getConversationsByQuery(fmt.Sprintf("UserAID:%d+UserBID:%d", a, b))
+	if err != nil {
+		return Conversation{}, err
+	}
+	if len(convs) > 0 {
+		return convs[0], nil
+	}
+
+	var c = Conversation{
+		UserAID:     a,
+		UserBID:     b,
+		Datetime:    time.Now(),
+		ReadMarkers: []ReadMarker{{UserID: a}, {UserID: b}},
+	}
+	id, err := db.SaveNewEntity(db.ConversationCollection, &c)
+	if err != nil {
+		return c, err
+	}
+
+	clog.Debugf("Message | GetOrCreateConversation(): created conversation %d between users %d and %d", id, a, b)
+
+	return GetConversation(userID, id)
+}
+
+// GetConversation returns the conversation with the provided ID if the user is one of its participants
+func GetConversation(userID pk.ID, id pk.ID) (Conversation, error) {
+	var c Conversation
+	err := db.GetEntityByID(db.ConversationCollection, id, &c)
+	if db.IsNotExist(err) {
+		return c, ErrConversationNotFound
+	}
+	if err != nil {
+		return c, err
+	}
+	if !c.HasParticipant(userID) {
+		return Conversation{}, ErrConversationNotFound
+	}
+	return c, nil
+}
+
+// GetConversationsByUserID returns the conversations of the user with the latest activity first. Conversations
+// with users that have blocked each other since are not included.
+func GetConversationsByUserID(userID pk.ID) ([]ConversationSummary, error) {
+	var summaries = []ConversationSummary{}
+
+	viewer, err := user.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	blockedIDs, err := block.GetBlockedUserIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var convs []Conversation
+	for _, field := range []string{"UserAID", "UserBID"} {
+		cs, err := getConversationsByQuery(fmt.Sprintf("%s:%d", field, userID))
+		if err != nil {
+			return nil, err
+		}
+		convs = append(convs, cs...)
+	}
+
+	for _, c := range convs {
+		otherID := c.OtherParticipant(userID)
+		if blockedIDs[otherID] {
+			continue
+		}
+		other, err := user.GetUserByID(otherID)
+		if err != nil {
+			clog.Warnf("There was an error trying GetUserByID(%d): %v", otherID, err)
+			continue
+		}
+
+		messages, err := getMessagesByConversationID(c.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		var s = ConversationSummary{
+			Conversation: c,
+			With:         other.ShareWith(viewer),
+			UnreadCount:  countUnread(c, userID, messages),
+		}
+		if len(messages) > 0 {
+			s.LastMessage = &messages[0]
+		}
+		summaries = append(summaries, s)
+	}
+
+	// Conversations with the most recent activity come first
+	sort.SliceStable(summaries, func(i, j int) bool {
+		return lastActivity(summaries[i].Conversation).After(lastActivity(summaries[j].Conversation))
+	})
+
+	return summaries, nil
+}
+
+// GetUnreadCount returns the number of messages the user has not read across all their conversations
+func GetUnreadCount(userID pk.ID) (int, error) {
+	summaries, err := GetConversationsByUserID(userID)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	for _, s := range summaries {
+		count += s.UnreadCount
+	}
+	return count, nil
+}
+
+// NewMessage sends a message from the user in the conversation. The participants should still be matched.
+func NewMessage(senderID pk.ID, conversationID pk.ID, b BasicMessage) (Message, error) {
+
+	var m Message
+	m.BasicMessage = b
+	m.ConversationID = conversationID
+	m.SenderID = senderID
+	m.Datetime = time.Now()
+
+	// Validate that the data is okay
+	if err := b.Validate(); err != nil {
+		return m, err
+	}
+
+	c, err := GetConversation(senderID, conversationID)
+	if err != nil {
+		return m, err
+	}
+
+	// The users could have unliked or blocked each other since the conversation started
+	if err := checkMatch(senderID, c.OtherParticipant(senderID)); err != nil {
+		return m, err
+	}
+
+	// Save the object in the DB
+	id, err := db.SaveNewEntity(db.MessageCollection, &m)
+	if err != nil {
+		return m, err
+	}
+
+	// Sending a message means that the sender has read the conversation up to it. The conversation is read
+	// again, since it could have changed since.
+	conversationLock.Lock()
+	err = db.GetEntityByID(db.ConversationCollection, c.ID, &c)
+	if err == nil {
+		if m.Datetime.After(c.LastMessageDatetime) {
+			c.LastMessageDatetime = m.Datetime
+		}
+		c.setLastReadAt(senderID, m.Datetime)
+		err = db.SaveEntityByID(db.ConversationCollection, c.ID, c)
+	}
+	conversationLock.Unlock()
+	if err != nil {
+		return m, err
+	}
+
+	var message Message
+	err = db.GetEntityByID(db.MessageCollection, id, &message)
+	if err != nil {
+		return message, err
+	}
+
+	pubsub.Publish(pubsub.Event{Type: pubsub.EventMessage, UserID: c.OtherParticipant(senderID), Data: message})
+
+	return message, nil
+}
+
+// GetMessages returns a page of the messages in the conversation, most recent first
+func GetMessages(userID pk.ID, conversationID pk.ID, req Request) (Page, error) {
+	var page = Page{
+		Messages: []Message{},
+		Page:     req.Page,
+		PageSize: req.PageSize,
+	}
+
+	if err := req.Validate(); err != nil {
+		return page, err
+	}
+
+	c, err := GetConversation(userID, conversationID)
+	if err != nil {
+		return page, err
+	}
+
+	messages, err := getMessagesByConversationID(c.ID)
+	if err != nil {
+		return page, err
+	}
+
+	// Paginate
+	start := (req.Page - 1) * req.PageSize
+	end := start + req.PageSize
+	if start > len(messages) {
+		start = len(messages)
+	}
+	if end > len(messages) {
+		end = len(messages)
+	}
+	page.Messages = append(page.Messages, messages[start:end]...)
+	page.HasMore = end < len(messages)
+
+	return page, nil
+}
+
+// MarkAsRead marks all the messages in the conversation as read by the user
+func MarkAsRead(userID pk.ID, conversationID pk.ID) (Conversation, error) {
+	conversationLock.Lock()
+	defer conversationLock.Unlock()
+
+	c, err := GetConversation(userID, conversationID)
+	if err != nil {
+		return c, err
+	}
+
+	c.setLastReadAt(userID, time.Now())
+	err = db.SaveEntityByID(db.ConversationCollection, c.ID, c)
+	return c, err
+}
+
+func (c *Conversation) setLastReadAt(userID pk.ID, t time.Time) {
+	for i := range c.ReadMarkers {
+		if c.ReadMarkers[i].UserID == userID {
+			if t.After(c.ReadMarkers[i].LastReadAt) {
+				c.ReadMarkers[i].LastReadAt = t
+			}
+			return
+		}
+	}
+	c.ReadMarkers = append(c.ReadMarkers, ReadMarker{UserID: userID, LastReadAt: t})
+}
+
+// checkMatch returns ErrNotMatched unless the users have liked each other and neither has blocked the other
+func checkMatch(a, b pk.ID) error {
+	matched, err := like.IsMatch(a, b)
+	if err != nil {
+		return err
+	}
+	if !matched {
+		return ErrNotMatched
+	}
+	blocked, err := block.IsBlocked(a, b)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return ErrNotMatched
+	}
+	return nil
+}
+
+// countUnread returns the number of messages sent by the other participant after the user last read the conversation
+func countUnread(c Conversation, userID pk.ID, messages []Message) int {
+	lastReadAt := c.LastReadAt(userID)
+	var count int
+	for _, m := range messages {
+		if m.SenderID != userID && m.Datetime.After(lastReadAt) {
+			count++
+		}
+	}
+	return count
+}
+
+func lastActivity(c Conversation) time.Time {
+	if c.LastMessageDatetime.IsZero() {
+		return c.Datetime
+	}
+	return c.LastMessageDatetime
+}
+
+// getMessagesByConversationID returns all the messages in the conversation, most recent first
+func getMessagesByConversationID(id pk.ID) ([]Message, error) {
+
+	// Run the query
+	result, err := db.Query(db.MessageCollection, fmt.Sprintf("ConversationID:%d", id))
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert the result into messages
+	var messages []Message
+	err = db.DecodeQueryResult(result, &messages)
+	if err != nil {
+		return nil, err
+	}
+
+	// IDs are random, so the messages are ordered by time
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Datetime.After(messages[j].Datetime)
+	})
+
+	return messages, nil
+}
+
+func getConversationsByQuery(query string) ([]Conversation, error) {
+
+	// Run the query
+	result, err := db.Query(db.ConversationCollection, query)
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert the result into conversations
+	var convs []Conversation
+	err = db.DecodeQueryResult(result, &convs)
+	if err != nil {
+		return nil, err
+	}
+
+	return convs, nil
+}
@@ -0,0 +1,173 @@
+package message
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/db"
+	"github.com/teejays/matchapi/lib/pk"
+	"github.com/teejays/matchapi/service/block/v1"
+	"github.com/teejays/matchapi/service/like/v2"
+	"github.com/teejays/matchapi/service/user/v1"
+)
+
+func init() {
+	clog.LogLevel = 7
+}
+
+func TestMessage(t *testing.T) {
+
+	// Initialize the mock DB client
+	err := db.InitMockClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.DestoryMockClient()
+
+	// Populate the User mock data in DB
+	err = user.HelperPopulateMockData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Users need to like each other before they can talk
+	_, err = like.NewLike(1, like.BasicLike{ReceiverID: 2})
+	assert.NoError(t, err)
+	_, err = GetOrCreateConversation(1, 2)
+	assert.Equal(t, ErrNotMatched, err)
+	_, err = like.NewLike(2, like.BasicLike{ReceiverID: 1})
+	assert.NoError(t, err)
+
+	_, err = GetOrCreateConversation(1, 1)
+	assert.Equal(t, ErrCannotMessageSelf, err)
+
+	// Both users should get the same conversation
+	c, err := GetOrCreateConversation(2, 1)
+	assert.NoError(t, err)
+	c2, err := GetOrCreateConversation(1, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, c.ID, c2.ID)
+
+	// Only the participants can see and post in the conversation
+	_, err = GetConversation(3, c.ID)
+	assert.Equal(t, ErrConversationNotFound, err)
+	_, err = NewMessage(3, c.ID, BasicMessage{Text: "Hi"})
+	assert.Equal(t, ErrConversationNotFound, err)
+
+	// Empty messages are not allowed
+	_, err = NewMessage(1, c.ID, BasicMessage{Text: "  "})
+	assert.Error(t, err)
+
+	for _, text := range []string{"Hi!", "How are you?", "Want to grab a coffee?"} {
+		_, err = NewMessage(1, c.ID, BasicMessage{Text: text})
+		assert.NoError(t, err)
+	}
+
+	// The recipient should have unread messages, but not the sender
+	count, err := GetUnreadCount(2)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+	count, err = GetUnreadCount(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	convs, err := GetConversationsByUserID(2)
+	assert.NoError(t, err)
+	if assert.Len(t, convs, 1) {
+		assert.Equal(t, pk.ID(1), convs[0].With.ID)
+		if assert.NotNil(t, convs[0].LastMessage) {
+			assert.Equal(t, "Want to grab a coffee?", convs[0].LastMessage.Text)
+		}
+	}
+
+	// History is paginated with the most recent messages first
+	page, err := GetMessages(2, c.ID, Request{Page: 1, PageSize: 2})
+	assert.NoError(t, err)
+	assert.True(t, page.HasMore)
+	if assert.Len(t, page.Messages, 2) {
+		assert.Equal(t, "Want to grab a coffee?", page.Messages[0].Text)
+	}
+	page, err = GetMessages(2, c.ID, Request{Page: 2, PageSize: 2})
+	assert.NoError(t, err)
+	assert.False(t, page.HasMore)
+	if assert.Len(t, page.Messages, 1) {
+		assert.Equal(t, "Hi!", page.Messages[0].Text)
+	}
+
+	// Reading the conversation clears the unread count
+	_, err = MarkAsRead(2, c.ID)
+	assert.NoError(t, err)
+	count, err = GetUnreadCount(2)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	// Blocked users cannot message each other anymore
+	_, err = block.NewBlock(2, block.BasicBlock{BlockedID: 1})
+	assert.NoError(t, err)
+	_, err = NewMessage(1, c.ID, BasicMessage{Text: "Hello?"})
+	assert.Equal(t, ErrNotMatched, err)
+	convs, err = GetConversationsByUserID(1)
+	assert.NoError(t, err)
+	assert.Empty(t, convs)
+}
+
+func TestGetOrCreateConversationConcurrently(t *testing.T) {
+
+	// Initialize the mock DB client
+	err := db.InitMockClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.DestoryMockClient()
+
+	// Populate the User mock data in DB
+	err = user.HelperPopulateMockData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = like.NewLike(1, like.BasicLike{ReceiverID: 2})
+	assert.NoError(t, err)
+	_, err = like.NewLike(2, like.BasicLike{ReceiverID: 1})
+	assert.NoError(t, err)
+
+	// Concurrent calls should all get the same conversation
+	var wg sync.WaitGroup
+	var ids = make([]pk.ID, 10)
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c, err := GetOrCreateConversation(pk.ID(1+i%2), pk.ID(2-i%2))
+			assert.NoError(t, err)
+			ids[i] = c.ID
+		}(i)
+	}
+	wg.Wait()
+	for _, id := range ids {
+		assert.Equal(t, ids[0], id)
+	}
+
+	// Concurrent messages and reads shouldn't undo each other
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, err := NewMessage(1, ids[0], BasicMessage{Text: "Hi!"})
+			assert.NoError(t, err)
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := MarkAsRead(2, ids[0])
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	c, err := GetConversation(1, ids[0])
+	assert.NoError(t, err)
+	assert.False(t, c.LastMessageDatetime.IsZero())
+	assert.False(t, c.LastReadAt(1).Before(c.LastMessageDatetime))
+}
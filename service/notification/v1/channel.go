@@ -0,0 +1,94 @@
+package notification
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/service/user/v1"
+)
+
+// The names of the channels that notifications can be sent through, other than the inbox
+const (
+	ChannelEmail = "email"
+	ChannelPush  = "push"
+)
+
+// Channel delivers notifications to the users outside of the app, e.g. by email
+type Channel interface {
+	Send(u *user.User, n Notification) error
+}
+
+// channels holds all the registered channels by their names. Notifications are not sent through the
+// channels that have no implementation registered.
+var channels = map[string]Channel{}
+
+// RegisterChannel makes a channel available under the provided name
+func RegisterChannel(name string, c Channel) {
+	channels[name] = c
+}
+
+// NewSink returns a Channel meant for development, which writes the notifications to the log (`log`)
+// or appends them to a file (`file`) instead of delivering them
+func NewSink(kind string, name string, path string) (Channel, error) {
+	switch kind {
+	case "log":
+		return LogChannel{Name: name}, nil
+	case "file":
+		if path == "" {
+			return nil, fmt.Errorf("a path is needed for the file notification sink")
+		}
+		return &FileChannel{Name: name, Path: path}, nil
+	}
+	return nil, fmt.Errorf("unknown notification sink '%s': should be log or file", kind)
+}
+
+// LogChannel is a Channel that writes the notifications to the log
+type LogChannel struct {
+	Name string
+}
+
+// Send implements the Channel interface
+func (c LogChannel) Send(u *user.User, n Notification) error {
+	clog.Infof("Notification | %s: to %s (user %d): %s: %s", c.Name, u.Email, u.ID, n.Title, n.Body)
+	return nil
+}
+
+// FileChannel is a Channel that appends the notifications to a file, one JSON object per line
+type FileChannel struct {
+	Name string
+	Path string
+	lock sync.Mutex
+}
+
+// sentNotification is what the FileChannel writes for every notification
+type sentNotification struct {
+	Channel      string
+	To           string
+	Notification Notification
+	Datetime     time.Time
+}
+
+// Send implements the Channel interface
+func (c *FileChannel) Send(u *user.User, n Notification) error {
+	line, err := json.Marshal(sentNotification{Channel: c.Name, To: u.Email, Notification: n, Datetime: time.Now()})
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	f, err := os.OpenFile(c.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,206 @@
+package notification
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/db"
+	"github.com/teejays/matchapi/lib/pk"
+	"github.com/teejays/matchapi/lib/pubsub"
+	"github.com/teejays/matchapi/service/like/v2"
+	"github.com/teejays/matchapi/service/user/v1"
+)
+
+// The types of notifications
+const (
+	TypeLike  = "like"
+	TypeMatch = "match"
+)
+
+// Notification is a message for a user about something that happened, e.g. someone liked them
+type Notification struct {
+	ID     pk.ID
+	UserID pk.ID
+	Type   string
+	// ActorID is the user whose action caused the notification
+	ActorID  pk.ID
+	Title    string
+	Body     string
+	IsRead   bool
+	Datetime time.Time
+}
+
+// Inbox represents the notifications of a user, most recent first
+type Inbox struct {
+	Notifications []Notification
+	UnreadCount   int
+}
+
+// ErrNotificationNotFound is returned when a notification does not exist, or belongs to another user
+var ErrNotificationNotFound = fmt.Errorf("notification not found")
+
+// HandleEvent turns the events published by the other services into notifications. It is meant to be
+// registered as a pubsub.Handler.
+func HandleEvent(e pubsub.Event) {
+	var n = Notification{UserID: e.UserID, Datetime: e.Datetime}
+
+	switch e.Type {
+	case pubsub.EventLikeReceived:
+		l, ok := e.Data.(like.IncomingLike)
+		if !ok {
+			clog.Warnf("Notification | HandleEvent(): unexpected data for a %s event: %T", e.Type, e.Data)
+			return
+		}
+		n.Type = TypeLike
+		n.ActorID = l.GiverID
+		n.Title = "New like"
+		n.Body = fmt.Sprintf("%s liked you", l.Giver.FirstName)
+		if l.IsSuperLike {
+			n.Title = "New super like"
+			n.Body = fmt.Sprintf("%s super liked you!", l.Giver.FirstName)
+		}
+
+	case pubsub.EventMatch:
+		other, ok := e.Data.(user.ShareableProfileUser)
+		if !ok {
+			clog.Warnf("Notification | HandleEvent(): unexpected data for a %s event: %T", e.Type, e.Data)
+			return
+		}
+		n.Type = TypeMatch
+		n.ActorID = other.ID
+		n.Title = "It's a match!"
+		n.Body = fmt.Sprintf("You and %s have liked each other", other.FirstName)
+
+	default:
+		return
+	}
+
+	if err := Notify(n); err != nil {
+		clog.Errorf("Notification | HandleEvent(): could not notify user %d of a %s event: %v", e.UserID, e.Type, err)
+	}
+}
+
+// Notify saves the notification in the user's inbox and sends it through the channels that the user has
+// enabled for its type. Failing to send through a channel does not fail the notification.
+func Notify(n Notification) error {
+	if n.Datetime.IsZero() {
+		n.Datetime = time.Now()
+	}
+
+	u, err := user.GetUserByID(n.UserID)
+	if err != nil {
+		return err
+	}
+
+	prefs, err := GetPreferences(n.UserID)
+	if err != nil {
+		return err
+	}
+	cp := prefs.For(n.Type)
+
+	if cp.Inbox {
+		id, err := db.SaveNewEntity(db.NotificationCollection, &n)
+		if err != nil {
+			return err
+		}
+		n.ID = id
+	}
+
+	for _, name := range cp.Channels() {
+		c, exists := channels[name]
+		if !exists {
+			continue
+		}
+		if err := c.Send(u, n); err != nil {
+			clog.Warnf("Notification | Notify(): could not send a %s notification to user %d through %s: %v", n.Type, n.UserID, name, err)
+		}
+	}
+
+	return nil
+}
+
+// GetInbox returns the notifications of the user, most recent first. If unreadOnly is true, the
+// notifications that have been read are left out.
+func GetInbox(userID pk.ID, unreadOnly bool) (Inbox, error) {
+	var inbox = Inbox{Notifications: []Notification{}}
+
+	notifications, err := getNotificationsByUserID(userID)
+	if err != nil {
+		return inbox, err
+	}
+
+	for _, n := range notifications {
+		if !n.IsRead {
+			inbox.UnreadCount++
+		} else if unreadOnly {
+			continue
+		}
+		inbox.Notifications = append(inbox.Notifications, n)
+	}
+
+	return inbox, nil
+}
+
+// MarkAsRead marks the notification as read
+func MarkAsRead(userID pk.ID, id pk.ID) (Notification, error) {
+	var n Notification
+	err := db.GetEntityByID(db.NotificationCollection, id, &n)
+	if db.IsNotExist(err) {
+		return n, ErrNotificationNotFound
+	}
+	if err != nil {
+		return n, err
+	}
+	if n.UserID != userID {
+		return Notification{}, ErrNotificationNotFound
+	}
+
+	n.IsRead = true
+	err = db.SaveEntityByID(db.NotificationCollection, n.ID, n)
+	return n, err
+}
+
+// MarkAllAsRead marks all the notifications of the user as read
+func MarkAllAsRead(userID pk.ID) error {
+	notifications, err := getNotificationsByUserID(userID)
+	if err != nil {
+		return err
+	}
+	for _, n := range notifications {
+		if n.IsRead {
+			continue
+		}
+		n.IsRead = true
+		err = db.SaveEntityByID(db.NotificationCollection, n.ID, n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getNotificationsByUserID returns all the notifications of the user, most recent first
+func getNotificationsByUserID(userID pk.ID) ([]Notification, error) {
+
+	// Run the query
+	result, err := db.Query(db.NotificationCollection, fmt.Sprintf("UserID:%d", userID))
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert the result into notifications
+	var notifications []Notification
+	err = db.DecodeQueryResult(result, &notifications)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(notifications, func(i, j int) bool {
+		return notifications[i].Datetime.After(notifications[j].Datetime)
+	})
+
+	return notifications, nil
+}
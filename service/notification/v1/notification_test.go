@@ -0,0 +1,98 @@
+package notification
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/db"
+	"github.com/teejays/matchapi/lib/pk"
+	"github.com/teejays/matchapi/lib/pubsub"
+	"github.com/teejays/matchapi/service/like/v2"
+	"github.com/teejays/matchapi/service/user/v1"
+)
+
+func init() {
+	clog.LogLevel = 7
+	pubsub.AddHandler(HandleEvent)
+}
+
+// recordingChannel is a Channel that keeps the notifications it is asked to send
+type recordingChannel struct {
+	sent []Notification
+}
+
+func (c *recordingChannel) Send(u *user.User, n Notification) error {
+	c.sent = append(c.sent, n)
+	return nil
+}
+
+func TestNotifications(t *testing.T) {
+
+	// Initialize the mock DB client
+	err := db.InitMockClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.DestoryMockClient()
+
+	// Populate the User mock data in DB
+	err = user.HelperPopulateMockData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	email := &recordingChannel{}
+	RegisterChannel(ChannelEmail, email)
+	defer delete(channels, ChannelEmail)
+
+	// User 1 doesn't want emails about likes
+	prefs := DefaultPreferences
+	prefs.Likes.Email = false
+	_, err = UpdatePreferences(1, prefs)
+	assert.NoError(t, err)
+
+	// User 2 likes user 1, and user 1 likes back
+	_, err = like.NewLike(2, like.BasicLike{ReceiverID: 1})
+	assert.NoError(t, err)
+	_, err = like.NewLike(1, like.BasicLike{ReceiverID: 2})
+	assert.NoError(t, err)
+
+	// User 1 should have been notified of the like and the match
+	inbox, err := GetInbox(1, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, inbox.UnreadCount)
+	if assert.Len(t, inbox.Notifications, 2) {
+		assert.Equal(t, TypeMatch, inbox.Notifications[0].Type)
+		assert.Equal(t, TypeLike, inbox.Notifications[1].Type)
+		assert.Equal(t, pk.ID(2), inbox.Notifications[1].ActorID)
+	}
+
+	// User 2 should have been notified of the like and the match, and user 1 only of the match by email
+	inbox2, err := GetInbox(2, false)
+	assert.NoError(t, err)
+	assert.Len(t, inbox2.Notifications, 2)
+	var emailed = map[pk.ID][]string{}
+	for _, n := range email.sent {
+		emailed[n.UserID] = append(emailed[n.UserID], n.Type)
+	}
+	assert.Equal(t, []string{TypeMatch}, emailed[1])
+	assert.ElementsMatch(t, []string{TypeLike, TypeMatch}, emailed[2])
+
+	// Users can only mark their own notifications as read
+	_, err = MarkAsRead(2, inbox.Notifications[0].ID)
+	assert.Equal(t, ErrNotificationNotFound, err)
+	_, err = MarkAsRead(1, inbox.Notifications[0].ID)
+	assert.NoError(t, err)
+	inbox, err = GetInbox(1, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, inbox.UnreadCount)
+	assert.Len(t, inbox.Notifications, 1)
+
+	assert.NoError(t, MarkAllAsRead(1))
+	inbox, err = GetInbox(1, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, inbox.UnreadCount)
+	assert.Len(t, inbox.Notifications, 2)
+}
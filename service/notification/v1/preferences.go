@@ -0,0 +1,76 @@
+package notification
+
+import (
+	"github.com/teejays/matchapi/db"
+	"github.com/teejays/matchapi/lib/pk"
+)
+
+// Preferences represent how a user wants to be notified about each type of notification
+type Preferences struct {
+	Likes   ChannelPreferences
+	Matches ChannelPreferences
+}
+
+// ChannelPreferences represent where a type of notification should be delivered
+type ChannelPreferences struct {
+	Inbox bool
+	Email bool
+	Push  bool
+}
+
+// DefaultPreferences are used for the users who haven't set their preferences
+var DefaultPreferences = Preferences{
+	Likes:   ChannelPreferences{Inbox: true, Email: true, Push: true},
+	Matches: ChannelPreferences{Inbox: true, Email: true, Push: true},
+}
+
+// preferences is how the Preferences are stored, by the ID of the user
+type preferences struct {
+	ID pk.ID
+	Preferences
+}
+
+// For returns the channel preferences for the type of notification
+func (p Preferences) For(notificationType string) ChannelPreferences {
+	switch notificationType {
+	case TypeLike:
+		return p.Likes
+	case TypeMatch:
+		return p.Matches
+	}
+	return ChannelPreferences{}
+}
+
+// Channels returns the names of the channels, other than the inbox, that are enabled
+func (cp ChannelPreferences) Channels() []string {
+	var names []string
+	if cp.Email {
+		names = append(names, ChannelEmail)
+	}
+	if cp.Push {
+		names = append(names, ChannelPush)
+	}
+	return names
+}
+
+// GetPreferences returns the notification preferences of the user
+func GetPreferences(userID pk.ID) (Preferences, error) {
+	var p preferences
+	err := db.GetEntityByID(db.NotificationPreferenceCollection, userID, &p)
+	if db.IsNotExist(err) {
+		return DefaultPreferences, nil
+	}
+	if err != nil {
+		return Preferences{}, err
+	}
+	return p.Preferences, nil
+}
+
+// UpdatePreferences replaces the notification preferences of the user
+func UpdatePreferences(userID pk.ID, p Preferences) (Preferences, error) {
+	err := db.SaveEntityByID(db.NotificationPreferenceCollection, userID, preferences{ID: userID, Preferences: p})
+	if err != nil {
+		return Preferences{}, err
+	}
+	return GetPreferences(userID)
+}
@@ -0,0 +1,338 @@
+package photo
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"time"
+
+	// Register the decoders for the image formats that we accept
+	_ "image/gif"
+	_ "image/png"
+
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/db"
+	"github.com/teejays/matchapi/lib/blob"
+	"github.com/teejays/matchapi/lib/pk"
+	"github.com/teejays/matchapi/service/user/v1"
+)
+
+// MaxImageSize is the maximum size, in bytes, of an uploaded image
+var MaxImageSize int64 = 5 << 20
+
+// MaxImagesPerUser is the maximum number of images that a user can have on their profile
+var MaxImagesPerUser = 6
+
+// maxImagePixels is the maximum number of pixels in an uploaded image. It protects us from images
+// that are small in size but decode into huge bitmaps.
+const maxImagePixels = 40000000
+
+// ThumbnailSize is the length, in pixels, of the longer side of the generated thumbnails
+var ThumbnailSize = 256
+
+// AllowedContentTypes are the sniffed content types of images that can be uploaded
+var AllowedContentTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// Store is where the images and their thumbnails are saved. It needs to be set before images can be uploaded.
+var Store blob.Store
+
+var ErrStoreNotInitialized = fmt.Errorf("photo store has not been initialized")
+var ErrImageTooLarge = fmt.Errorf("image is too large")
+var ErrUnsupportedContentType = fmt.Errorf("image type is not supported; supported types are jpeg, png and gif")
+var ErrTooManyImages = fmt.Errorf("user already has the maximum number of images")
+var ErrInvalidOrder = fmt.Errorf("the new order should include each of the user's images exactly once")
+
+// Photo represents an image uploaded by a user
+type Photo struct {
+	ID           pk.ID
+	UserID       pk.ID
+	Key          string
+	ThumbnailKey string
+	ContentType  string
+	Size         int
+	Width        int
+	Height       int
+	Datetime     time.Time
+	IsDeleted    bool
+}
+
+// Upload validates the image, generates a thumbnail for it, saves both of them in the Store and adds
+// the image to the end of the user's profile images
+func Upload(userID pk.ID, data []byte) (Photo, error) {
+	var p Photo
+
+	if Store == nil {
+		return p, ErrStoreNotInitialized
+	}
+
+	// Fail early if the user has no room for the image. It is checked again when the image is added,
+	// since other images may be uploaded in the meantime.
+	u, err := user.GetUserByID(userID)
+	if err != nil {
+		return p, err
+	}
+	if len(u.Images) >= MaxImagesPerUser {
+		return p, ErrTooManyImages
+	}
+
+	// Validate the image: we don't trust the content type sent by the client, so we sniff it
+	if int64(len(data)) > MaxImageSize {
+		return p, ErrImageTooLarge
+	}
+	contentType := http.DetectContentType(data)
+	if !AllowedContentTypes[contentType] {
+		return p, ErrUnsupportedContentType
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return p, fmt.Errorf("could not decode the image: %v", err)
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return p, ErrImageTooLarge
+	}
+
+	// Generate the thumbnail
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return p, fmt.Errorf("could not decode the image: %v", err)
+	}
+	var thumb bytes.Buffer
+	err = jpeg.Encode(&thumb, Thumbnail(img, ThumbnailSize), &jpeg.Options{Quality: 80})
+	if err != nil {
+		return p, fmt.Errorf("could not encode the thumbnail: %v", err)
+	}
+
+	// Save the image and the thumbnail
+	key, err := newKey()
+	if err != nil {
+		return p, err
+	}
+	p.UserID = userID
+	p.Key = key
+	p.ThumbnailKey = user.ThumbnailKey(key)
+	p.ContentType = contentType
+	p.Size = len(data)
+	p.Width = cfg.Width
+	p.Height = cfg.Height
+	p.Datetime = time.Now()
+
+	err = Store.Put(p.Key, bytes.NewReader(data))
+	if err != nil {
+		return p, err
+	}
+	err = Store.Put(p.ThumbnailKey, &thumb)
+	if err != nil {
+		deleteFiles(p.Key)
+		return p, err
+	}
+
+	id, err := db.SaveNewEntity(db.PhotoCollection, &p)
+	if err != nil {
+		deleteFiles(p.Key)
+		return p, err
+	}
+	p.ID = id
+
+	// Add the image to the user's profile, if there is still room for it
+	err = user.UpdateImages(userID, func(images []string) ([]string, error) {
+		if len(images) >= MaxImagesPerUser {
+			return nil, ErrTooManyImages
+		}
+		return append(images, p.Key), nil
+	})
+	if err != nil {
+		p.IsDeleted = true
+		if err := db.SaveEntityByID(db.PhotoCollection, p.ID, p); err != nil {
+			clog.Warnf("Photo | Upload(): could not delete the photo %d: %v", p.ID, err)
+		}
+		deleteFiles(p.Key)
+		return p, err
+	}
+
+	clog.Debugf("Photo | Upload(): user %d uploaded the image %s", userID, p.Key)
+
+	return p, nil
+}
+
+// Reorder changes the order of the user's profile images. The keys should include each of the user's
+// images exactly once.
+func Reorder(userID pk.ID, keys []string) error {
+	return user.UpdateImages(userID, func(images []string) ([]string, error) {
+		if len(keys) != len(images) {
+			return nil, ErrInvalidOrder
+		}
+		var existing = make(map[string]bool)
+		for _, k := range images {
+			existing[k] = true
+		}
+		for _, k := range keys {
+			if !existing[k] {
+				return nil, ErrInvalidOrder
+			}
+			// Make sure that keys are not repeated
+			delete(existing, k)
+		}
+		return keys, nil
+	})
+}
+
+// Delete removes the image from the user's profile, deletes the photo, and deletes the image and its
+// thumbnail from the Store
+func Delete(userID pk.ID, key string) error {
+	if Store == nil {
+		return ErrStoreNotInitialized
+	}
+
+	err := user.UpdateImages(userID, func(images []string) ([]string, error) {
+		var found bool
+		var remaining []string
+		for _, k := range images {
+			if k == key {
+				found = true
+				continue
+			}
+			remaining = append(remaining, k)
+		}
+		if !found {
+			return nil, user.ErrEntityDoesNotExist
+		}
+		return remaining, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// The image is no longer a part of the profile, so failing to delete the photo or the files is not fatal
+	photos, err := getPhotosByQuery(fmt.Sprintf("UserID:%d", userID))
+	if err != nil {
+		clog.Warnf("Photo | Delete(): could not get the photos of user %d: %v", userID, err)
+	}
+	for _, p := range photos {
+		if p.Key != key || p.IsDeleted {
+			continue
+		}
+		p.IsDeleted = true
+		if err := db.SaveEntityByID(db.PhotoCollection, p.ID, p); err != nil {
+			clog.Warnf("Photo | Delete(): could not delete the photo %d: %v", p.ID, err)
+		}
+	}
+	deleteFiles(key)
+
+	return nil
+}
+
+// deleteFiles deletes the image and its thumbnail from the Store. Failures are only logged, since by then
+// the image is not used anymore.
+func deleteFiles(key string) {
+	if err := Store.Delete(key); err != nil {
+		clog.Warnf("Photo | deleteFiles(): could not delete the image %s: %v", key, err)
+	}
+	if err := Store.Delete(user.ThumbnailKey(key)); err != nil {
+		clog.Warnf("Photo | deleteFiles(): could not delete the thumbnail of image %s: %v", key, err)
+	}
+}
+
+func getPhotosByQuery(query string) ([]Photo, error) {
+
+	// Run the query
+	result, err := db.Query(db.PhotoCollection, query)
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert the result into photos
+	var photos []Photo
+	err = db.DecodeQueryResult(result, &photos)
+	if err != nil {
+		return nil, err
+	}
+
+	return photos, nil
+}
+
+// Open returns a reader for the image or thumbnail saved under the key
+func Open(key string) (io.ReadCloser, error) {
+	if Store == nil {
+		return nil, ErrStoreNotInitialized
+	}
+	return Store.Get(key)
+}
+
+// Thumbnail scales the image down, preserving the aspect ratio, so that its longer side is at most size pixels.
+// It uses a simple box filter which averages the pixels of the original image that fall in each thumbnail pixel.
+func Thumbnail(src image.Image, size int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= size && h <= size {
+		size = w
+		if h > w {
+			size = h
+		}
+	}
+
+	tw, th := size, size
+	if w > h {
+		th = h * size / w
+	} else {
+		tw = w * size / h
+	}
+	if tw < 1 {
+		tw = 1
+	}
+	if th < 1 {
+		th = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for ty := 0; ty < th; ty++ {
+		y0 := b.Min.Y + ty*h/th
+		y1 := b.Min.Y + (ty+1)*h/th
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for tx := 0; tx < tw; tx++ {
+			x0 := b.Min.X + tx*w/tw
+			x1 := b.Min.X + (tx+1)*w/tw
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, bl, a, n uint64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					cr, cg, cb, ca := src.At(x, y).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+
+			i := dst.PixOffset(tx, ty)
+			dst.Pix[i+0] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(bl / n >> 8)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+
+	return dst
+}
+
+// newKey generates a random key for an image
+func newKey() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,202 @@
+package photo
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/db"
+	"github.com/teejays/matchapi/lib/blob"
+	"github.com/teejays/matchapi/service/user/v1"
+)
+
+func init() {
+	clog.LogLevel = 7
+}
+
+func mockPNG(t *testing.T, w, h int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 100, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestUpload(t *testing.T) {
+
+	// Initialize the mock DB client
+	err := db.InitMockClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.DestoryMockClient()
+
+	// Populate the User mock data in DB
+	err = user.HelperPopulateMockData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Use a temporary directory as the image store
+	dir, err := ioutil.TempDir("", "photo_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	Store, err = blob.NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { Store = nil }()
+
+	// Non images should be rejected
+	_, err = Upload(1, []byte("definitely not an image"))
+	assert.Equal(t, ErrUnsupportedContentType, err)
+
+	// Upload two images
+	p1, err := Upload(1, mockPNG(t, 600, 300))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "image/png", p1.ContentType)
+	p2, err := Upload(1, mockPNG(t, 50, 80))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// The thumbnail should be scaled down, preserving the aspect ratio
+	rc, err := Store.Get(p1.ThumbnailKey)
+	if assert.NoError(t, err) {
+		cfg, format, err := image.DecodeConfig(rc)
+		rc.Close()
+		assert.NoError(t, err)
+		assert.Equal(t, "jpeg", format)
+		assert.Equal(t, ThumbnailSize, cfg.Width)
+		assert.Equal(t, ThumbnailSize/2, cfg.Height)
+	}
+
+	u, err := user.GetUserByID(1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{p1.Key, p2.Key}, u.Images)
+
+	// Reorder
+	assert.Equal(t, ErrInvalidOrder, Reorder(1, []string{p2.Key}))
+	assert.Equal(t, ErrInvalidOrder, Reorder(1, []string{p2.Key, p2.Key}))
+	assert.NoError(t, Reorder(1, []string{p2.Key, p1.Key}))
+	u, err = user.GetUserByID(1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{p2.Key, p1.Key}, u.Images)
+
+	// Delete
+	assert.Equal(t, user.ErrEntityDoesNotExist, Delete(2, p1.Key))
+	assert.NoError(t, Delete(1, p2.Key))
+	u, err = user.GetUserByID(1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{p1.Key}, u.Images)
+	_, err = Store.Get(p2.Key)
+	assert.Equal(t, blob.ErrNotExist, err)
+	_, err = Store.Get(p2.ThumbnailKey)
+	assert.Equal(t, blob.ErrNotExist, err)
+	photos, err := getPhotosByQuery("UserID:1")
+	assert.NoError(t, err)
+	for _, p := range photos {
+		assert.Equal(t, p.Key == p2.Key, p.IsDeleted)
+	}
+
+	// Changing the images doesn't undo what was saved on the user in the meantime
+	u, err = user.GetUserByID(1)
+	assert.NoError(t, err)
+	assert.NoError(t, u.Suspend(time.Now().Add(time.Hour)))
+	_, err = Upload(1, mockPNG(t, 20, 20))
+	assert.NoError(t, err)
+	u, err = user.GetUserByID(1)
+	assert.NoError(t, err)
+	assert.True(t, u.IsSuspended(time.Now()))
+}
+
+func TestUploadConcurrently(t *testing.T) {
+
+	// Initialize the mock DB client
+	err := db.InitMockClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.DestoryMockClient()
+
+	// Populate the User mock data in DB
+	err = user.HelperPopulateMockData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Use a temporary directory as the image store
+	dir, err := ioutil.TempDir("", "photo_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	Store, err = blob.NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { Store = nil }()
+
+	defer func(n int) { MaxImagesPerUser = n }(MaxImagesPerUser)
+	MaxImagesPerUser = 2
+
+	// Only as many uploads as the user has room for go through, and none of them is lost
+	var wg sync.WaitGroup
+	var uploaded = make(chan Photo, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p, err := Upload(2, mockPNG(t, 100, 100))
+			if err == nil {
+				uploaded <- p
+			} else {
+				assert.Equal(t, ErrTooManyImages, err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(uploaded)
+
+	var keys []string
+	for p := range uploaded {
+		keys = append(keys, p.Key)
+	}
+	u, err := user.GetUserByID(2)
+	assert.NoError(t, err)
+	assert.Len(t, u.Images, MaxImagesPerUser)
+	assert.ElementsMatch(t, keys, u.Images)
+
+	// The rejected uploads leave nothing behind
+	photos, err := getPhotosByQuery("UserID:2")
+	assert.NoError(t, err)
+	var active []string
+	for _, p := range photos {
+		if !p.IsDeleted {
+			active = append(active, p.Key)
+			continue
+		}
+		_, err = Store.Get(p.Key)
+		assert.Equal(t, blob.ErrNotExist, err)
+	}
+	assert.ElementsMatch(t, u.Images, active)
+}
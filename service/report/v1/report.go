@@ -0,0 +1,319 @@
+package report
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/db"
+	"github.com/teejays/matchapi/lib/pk"
+	"github.com/teejays/matchapi/service/user/v1"
+)
+
+// Reasons for which a user can be reported
+const (
+	ReasonSpam                 = "spam"
+	ReasonHarassment           = "harassment"
+	ReasonInappropriateContent = "inappropriate_content"
+	ReasonFakeProfile          = "fake_profile"
+	ReasonUnderage             = "underage"
+	ReasonOther                = "other"
+)
+
+var reasons = []string{ReasonSpam, ReasonHarassment, ReasonInappropriateContent, ReasonFakeProfile, ReasonUnderage, ReasonOther}
+
+// Statuses of a report
+const (
+	StatusOpen     = "open"
+	StatusResolved = "resolved"
+)
+
+// Actions that an admin can take on a report
+const (
+	ActionDismiss = "dismiss"
+	ActionWarn    = "warn"
+	ActionSuspend = "suspend"
+	ActionBan     = "ban"
+)
+
+// MaxDetailsLength is the maximum number of characters in the free-text details of a report
+var MaxDetailsLength = 1000
+
+// MaxSuspensionDays is the longest suspension that can be given; longer ones should be bans
+var MaxSuspensionDays = 365
+
+var ErrCannotReportSelf = fmt.Errorf("invalid ReportedID: users cannot report themselves")
+var ErrReportDoesNotExist = fmt.Errorf("the report does not exist")
+var ErrReportAlreadyResolved = fmt.Errorf("the report has already been resolved")
+var ErrReportedHasMoreAccess = fmt.Errorf("the reported user has roles that you don't have")
+
+// BasicReport represents the part of Report struct that is generated by the user behavior
+type BasicReport struct {
+	ReportedID pk.ID
+	// Reason is the category of the report; possible values are `spam`, `harassment`, `inappropriate_content`,
+	// `fake_profile`, `underage` and `other`
+	Reason string
+	// Details is an optional free-text description of what happened
+	Details string
+}
+
+// Report represents a user reporting another user to the moderators
+type Report struct {
+	ID          pk.ID
+	ReporterID  pk.ID
+	Datetime    time.Time
+	Status      string
+	BasicReport `mapstructure:",squash"`
+	Resolution  *Resolution
+}
+
+// Resolution represents the action that an admin took on a report
+type Resolution struct {
+	ActionRequest `mapstructure:",squash"`
+	AdminID       pk.ID
+	Datetime      time.Time
+}
+
+// ActionRequest is the action that an admin wants to take on a report
+type ActionRequest struct {
+	// Action is one of `dismiss`, `warn`, `suspend` and `ban`
+	Action string
+	// Days is the length of the suspension, for the `suspend` action
+	Days int
+	// Note is an explanation of the action. For the `warn` action, it is the message sent to the user.
+	Note string
+}
+
+// Details has everything an admin needs to act on a report
+type Details struct {
+	Report Report
+	// Reported is the profile and standing of the reported user
+	Reported user.AdminUser
+	// ReportsAgainstReported are all the reports against the reported user, including this one
+	ReportsAgainstReported []Report
+	// ReporterHistory are all the reports made by the reporter, including this one, so that the admin
+	// can tell if the reporter is abusing the feature
+	ReporterHistory []Report
+}
+
+// Validate returns error if the data in the BasicReport is not valid
+func (b BasicReport) Validate() error {
+
+	// ReportedID should be greater than zero
+	if b.ReportedID < 1 {
+		return fmt.Errorf("invalid ReportedID: should be greater than 0")
+	}
+
+	// Reason should be one of the known reasons
+	if !contains(reasons, b.Reason) {
+		return fmt.Errorf("invalid Reason: possible values are %s", strings.Join(reasons, ", "))
+	}
+
+	if utf8.RuneCountInString(b.Details) > MaxDetailsLength {
+		return fmt.Errorf("invalid Details: cannot be longer than %d characters", MaxDetailsLength)
+	}
+
+	// ReportedID should be a valid user
+	_, err := user.GetUserByID(b.ReportedID)
+	if err != nil {
+		return fmt.Errorf("invalid ReportedID: could not validate that a user exists with this userID: %v", err)
+	}
+
+	return nil
+}
+
+// Validate returns error if the ActionRequest is not valid
+func (a ActionRequest) Validate() error {
+	switch a.Action {
+	case ActionDismiss, ActionBan:
+	case ActionWarn:
+		if strings.TrimSpace(a.Note) == "" {
+			return fmt.Errorf("invalid Note: the warning message cannot be empty")
+		}
+	case ActionSuspend:
+		if a.Days < 1 || a.Days > MaxSuspensionDays {
+			return fmt.Errorf("invalid Days: should be between 1 and %d", MaxSuspensionDays)
+		}
+	default:
+		return fmt.Errorf("invalid Action: possible values are dismiss, warn, suspend and ban")
+	}
+	return nil
+}
+
+// NewReport registers a new report in the database
+func NewReport(reporterID pk.ID, b BasicReport) (Report, error) {
+
+	// Create a new Report object
+	var r Report
+	r.BasicReport = b
+	r.ReporterID = reporterID
+	r.Datetime = time.Now()
+	r.Status = StatusOpen
+
+	// Validate that the data is okay
+	if reporterID == b.ReportedID {
+		return r, ErrCannotReportSelf
+	}
+	if err := b.Validate(); err != nil {
+		return r, fmt.Errorf("could not validate the data: %v", err)
+	}
+
+	// Save the object in the DB
+	id, err := db.SaveNewEntity(db.ReportCollection, &r)
+	if err != nil {
+		return r, err
+	}
+
+	clog.Infof("Report | NewReport(): user %d reported user %d for %s", reporterID, b.ReportedID, b.Reason)
+
+	return GetReportByID(id)
+}
+
+// GetReportByID returns the report with the provided ID
+func GetReportByID(id pk.ID) (Report, error) {
+	var r Report
+	err := db.GetEntityByID(db.ReportCollection, id, &r)
+	if db.IsNotExist(err) {
+		return r, ErrReportDoesNotExist
+	}
+	return r, err
+}
+
+// GetOpenReports returns the reports that have not been resolved yet, oldest first
+func GetOpenReports() ([]Report, error) {
+	return getReportsByQuery(fmt.Sprintf("Status:%s", StatusOpen))
+}
+
+// GetReportsByReporterID returns the reports made by the provided user, oldest first
+func GetReportsByReporterID(id pk.ID) ([]Report, error) {
+	return getReportsByQuery(fmt.Sprintf("ReporterID:%d", id))
+}
+
+// GetReportsByReportedID returns the reports made against the provided user, oldest first
+func GetReportsByReportedID(id pk.ID) ([]Report, error) {
+	return getReportsByQuery(fmt.Sprintf("ReportedID:%d", id))
+}
+
+// GetDetails returns the report along with the reported user and the history of both the users
+func GetDetails(id pk.ID) (Details, error) {
+	var d Details
+	var err error
+
+	d.Report, err = GetReportByID(id)
+	if err != nil {
+		return d, err
+	}
+
+	reported, err := user.GetUserByID(d.Report.ReportedID)
+	if err != nil {
+		return d, err
+	}
+	d.Reported = reported.AdminView()
+
+	d.ReportsAgainstReported, err = GetReportsByReportedID(d.Report.ReportedID)
+	if err != nil {
+		return d, err
+	}
+
+	d.ReporterHistory, err = GetReportsByReporterID(d.Report.ReporterID)
+	if err != nil {
+		return d, err
+	}
+
+	return d, nil
+}
+
+// TakeAction applies the action to the reported user and resolves the report
+func TakeAction(reportID pk.ID, adminID pk.ID, a ActionRequest) (Report, error) {
+	if err := a.Validate(); err != nil {
+		return Report{}, err
+	}
+
+	r, err := GetReportByID(reportID)
+	if err != nil {
+		return r, err
+	}
+	if r.Status != StatusOpen {
+		return r, ErrReportAlreadyResolved
+	}
+
+	reported, err := user.GetUserByID(r.ReportedID)
+	if err != nil {
+		return r, err
+	}
+
+	// Only the users who have all the roles of the reported user can act against them
+	if a.Action != ActionDismiss {
+		admin, err := user.GetUserByID(adminID)
+		if err != nil {
+			return r, err
+		}
+		if !admin.CanActOn(reported) {
+			return r, ErrReportedHasMoreAccess
+		}
+	}
+
+	// Apply the action
+	switch a.Action {
+	case ActionWarn:
+		err = reported.Warn(a.Note)
+	case ActionSuspend:
+		err = reported.Suspend(time.Now().AddDate(0, 0, a.Days))
+	case ActionBan:
+		err = reported.Ban()
+	}
+	if err != nil {
+		return r, err
+	}
+
+	// Resolve the report
+	r.Status = StatusResolved
+	r.Resolution = &Resolution{
+		ActionRequest: a,
+		AdminID:       adminID,
+		Datetime:      time.Now(),
+	}
+	err = db.SaveEntityByID(db.ReportCollection, r.ID, r)
+	if err != nil {
+		return r, err
+	}
+
+	clog.Infof("Report | TakeAction(): admin %d resolved report %d with action %s", adminID, r.ID, a.Action)
+
+	return r, nil
+}
+
+func getReportsByQuery(query string) ([]Report, error) {
+
+	// Run the query
+	result, err := db.Query(db.ReportCollection, query)
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert the result into reports
+	var reports []Report
+	err = db.DecodeQueryResult(result, &reports)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].Datetime.Before(reports[j].Datetime)
+	})
+
+	return reports, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
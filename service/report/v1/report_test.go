@@ -0,0 +1,155 @@
+package report
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/db"
+	"github.com/teejays/matchapi/lib/auth"
+	"github.com/teejays/matchapi/lib/pk"
+	"github.com/teejays/matchapi/service/user/v1"
+)
+
+func init() {
+	clog.LogLevel = 7
+}
+
+func TestNewReport(t *testing.T) {
+
+	// Initialize the mock DB client
+	err := db.InitMockClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.DestoryMockClient()
+
+	// Populate the User mock data in DB
+	err = user.HelperPopulateMockData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		name      string
+		reporter  pk.ID
+		report    BasicReport
+		shouldErr bool
+	}{
+		{
+			name:      "reporting yourself should give an error",
+			reporter:  1,
+			report:    BasicReport{ReportedID: 1, Reason: ReasonSpam},
+			shouldErr: true,
+		},
+		{
+			name:      "unknown reason should give an error",
+			reporter:  1,
+			report:    BasicReport{ReportedID: 2, Reason: "rude"},
+			shouldErr: true,
+		},
+		{
+			name:      "unknown user should give an error",
+			reporter:  1,
+			report:    BasicReport{ReportedID: 199999, Reason: ReasonSpam},
+			shouldErr: true,
+		},
+		{
+			name:      "valid report should not give an error",
+			reporter:  1,
+			report:    BasicReport{ReportedID: 2, Reason: ReasonHarassment, Details: "Rude messages"},
+			shouldErr: false,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			r, err := NewReport(test.reporter, test.report)
+			assert.Equal(t, test.shouldErr, err != nil)
+			if !test.shouldErr {
+				assert.Equal(t, StatusOpen, r.Status)
+				assert.Equal(t, test.report, r.BasicReport)
+			}
+		})
+	}
+}
+
+func TestTakeAction(t *testing.T) {
+
+	// Initialize the mock DB client
+	err := db.InitMockClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.DestoryMockClient()
+
+	// Populate the User mock data in DB
+	err = user.HelperPopulateMockData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r1, err := NewReport(1, BasicReport{ReportedID: 2, Reason: ReasonSpam})
+	assert.NoError(t, err)
+	r2, err := NewReport(3, BasicReport{ReportedID: 2, Reason: ReasonFakeProfile})
+	assert.NoError(t, err)
+
+	open, err := GetOpenReports()
+	assert.NoError(t, err)
+	assert.Len(t, open, 2)
+
+	// Invalid actions should be rejected
+	_, err = TakeAction(r1.ID, 3, ActionRequest{Action: ActionSuspend})
+	assert.Error(t, err)
+	_, err = TakeAction(r1.ID, 3, ActionRequest{Action: ActionWarn})
+	assert.Error(t, err)
+
+	// Moderators cannot act against the admins
+	moderator, err := user.GetUserByID(3)
+	assert.NoError(t, err)
+	assert.NoError(t, moderator.SetRoles([]string{auth.RoleModerator}))
+	reported, err := user.GetUserByID(2)
+	assert.NoError(t, err)
+	assert.NoError(t, reported.SetRoles([]string{auth.RoleAdmin}))
+	_, err = TakeAction(r1.ID, 3, ActionRequest{Action: ActionBan})
+	assert.Equal(t, ErrReportedHasMoreAccess, err)
+	assert.NoError(t, reported.SetRoles(nil))
+
+	// Suspend the reported user
+	resolved, err := TakeAction(r1.ID, 3, ActionRequest{Action: ActionSuspend, Days: 7, Note: "Spam"})
+	assert.NoError(t, err)
+	assert.Equal(t, StatusResolved, resolved.Status)
+	if assert.NotNil(t, resolved.Resolution) {
+		assert.Equal(t, pk.ID(3), resolved.Resolution.AdminID)
+	}
+
+	u, err := user.GetUserByID(2)
+	assert.NoError(t, err)
+	assert.True(t, u.IsSuspended(time.Now()))
+	assert.True(t, user.IsAccessError(u.CheckAccess(time.Now())))
+	assert.NoError(t, u.CheckAccess(time.Now().AddDate(0, 0, 8)))
+
+	// A report can only be resolved once
+	_, err = TakeAction(r1.ID, 3, ActionRequest{Action: ActionBan})
+	assert.Equal(t, ErrReportAlreadyResolved, err)
+
+	// The details should have the history of both users
+	details, err := GetDetails(r2.ID)
+	assert.NoError(t, err)
+	assert.Len(t, details.ReportsAgainstReported, 2)
+	assert.Len(t, details.ReporterHistory, 1)
+	assert.Equal(t, pk.ID(2), details.Reported.ID)
+
+	// Ban the reported user
+	_, err = TakeAction(r2.ID, 3, ActionRequest{Action: ActionBan})
+	assert.NoError(t, err)
+	u, err = user.GetUserByID(2)
+	assert.NoError(t, err)
+	assert.Equal(t, &user.AccessError{Banned: true}, u.CheckAccess(time.Now().AddDate(1, 0, 0)))
+
+	open, err = GetOpenReports()
+	assert.NoError(t, err)
+	assert.Empty(t, open)
+}
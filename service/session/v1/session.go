@@ -0,0 +1,188 @@
+package session
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/db"
+	"github.com/teejays/matchapi/lib/auth"
+	"github.com/teejays/matchapi/lib/pk"
+)
+
+// MaxDeviceLabelLength is the maximum length of the device label, and of the user agent that is kept
+const MaxDeviceLabelLength = 100
+
+// LastSeenResolution is how often the last seen time of a session is updated, so that every request
+// doesn't write to the database
+var LastSeenResolution = time.Minute
+
+var ErrSessionNotFound = fmt.Errorf("the session does not exist")
+
+// Device describes where the user is logging in from
+type Device struct {
+	// Label is a name for the device, chosen by the client, e.g. "Jane's iPhone"
+	Label     string
+	UserAgent string
+	IP        string
+}
+
+// Session is a device that a user is logged in on. Each token belongs to a session.
+type Session struct {
+	ID     pk.ID
+	UserID pk.ID
+	Device `mapstructure:",squash"`
+	// IsRevoked is set when the user logs out of the device. The tokens of revoked sessions are rejected.
+	IsRevoked  bool
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	// ExpiresAt is when the token of the session expires. Expired sessions are not shown to the user.
+	ExpiresAt time.Time
+}
+
+// SessionView is a session as shown to its user
+type SessionView struct {
+	Session
+	// IsCurrent is true for the session of the request
+	IsCurrent bool
+}
+
+// NewSession records a new session for the user on the device, at t
+func NewSession(userID pk.ID, d Device, t time.Time) (Session, error) {
+	s := Session{
+		UserID: userID,
+		Device: Device{
+			Label:     truncate(strings.TrimSpace(d.Label), MaxDeviceLabelLength),
+			UserAgent: truncate(d.UserAgent, MaxDeviceLabelLength),
+			IP:        d.IP,
+		},
+		CreatedAt:  t,
+		LastSeenAt: t,
+		ExpiresAt:  t.Add(auth.TokenLifespan),
+	}
+
+	id, err := db.SaveNewEntity(db.SessionCollection, &s)
+	if err != nil {
+		return s, err
+	}
+	s.ID = id
+
+	clog.Debugf("Session | NewSession(): created session %d for user %d", id, userID)
+
+	return s, nil
+}
+
+// GetSessionByID returns the session
+func GetSessionByID(id pk.ID) (Session, error) {
+	var s Session
+	err := db.GetEntityByID(db.SessionCollection, id, &s)
+	if db.IsNotExist(err) {
+		return s, ErrSessionNotFound
+	}
+	return s, err
+}
+
+// IsActive returns true if the session can be used at t
+func (s Session) IsActive(t time.Time) bool {
+	return !s.IsRevoked && t.Before(s.ExpiresAt)
+}
+
+// GetSessionsByUserID returns the active sessions of the user, the most recently used first. The current
+// session is marked.
+func GetSessionsByUserID(userID pk.ID, currentID pk.ID) ([]SessionView, error) {
+
+	// Run the query
+	result, err := db.Query(db.SessionCollection, fmt.Sprintf("UserID:%d", userID))
+	if err != nil {
+		return nil, err
+	}
+	var sessions []Session
+	err = db.DecodeQueryResult(result, &sessions)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var views = []SessionView{}
+	for _, s := range sessions {
+		if !s.IsActive(now) {
+			continue
+		}
+		views = append(views, SessionView{Session: s, IsCurrent: s.ID == currentID})
+	}
+
+	sort.Slice(views, func(i, j int) bool {
+		return views[i].LastSeenAt.After(views[j].LastSeenAt)
+	})
+
+	return views, nil
+}
+
+// touchLock makes sure the read-modify-write of the last seen time doesn't undo a revocation
+var touchLock sync.Mutex
+
+// Revoke logs the user out of the session. The tokens of the session stop working straight away.
+func Revoke(userID pk.ID, id pk.ID) error {
+	touchLock.Lock()
+	defer touchLock.Unlock()
+
+	s, err := GetSessionByID(id)
+	if err != nil {
+		return err
+	}
+	// Users can only see their own sessions
+	if s.UserID != userID || !s.IsActive(time.Now()) {
+		return ErrSessionNotFound
+	}
+
+	s.IsRevoked = true
+
+	clog.Infof("Session | Revoke(): user %d has revoked session %d", userID, id)
+
+	return db.SaveEntityByID(db.SessionCollection, s.ID, s)
+}
+
+// ValidateTokenPayload rejects the tokens whose session has been revoked or doesn't exist, and keeps track
+// of when the session was last used. It is meant to be registered as an auth.PayloadValidator.
+func ValidateTokenPayload(payload auth.TokenPayload) error {
+	// API keys are not tied to a session
+	if payload.IsAPIKey() {
+		return nil
+	}
+
+	touchLock.Lock()
+	defer touchLock.Unlock()
+
+	s, err := GetSessionByID(payload.SessionID)
+	if err == ErrSessionNotFound {
+		return auth.ErrTokenRevoked
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if s.UserID != payload.UserID || !s.IsActive(now) {
+		return auth.ErrTokenRevoked
+	}
+
+	if now.Sub(s.LastSeenAt) < LastSeenResolution {
+		return nil
+	}
+	s.LastSeenAt = now
+
+	return db.SaveEntityByID(db.SessionCollection, s.ID, s)
+}
+
+// truncate shortens s to n characters
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n])
+}
@@ -0,0 +1,72 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/db"
+	"github.com/teejays/matchapi/lib/auth"
+)
+
+func init() {
+	clog.LogLevel = 7
+}
+
+func TestSessions(t *testing.T) {
+
+	// Initialize the mock DB client
+	err := db.InitMockClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.DestoryMockClient()
+
+	now := time.Now()
+	phone, err := NewSession(1, Device{Label: " Jon's phone ", UserAgent: "Mozilla/5.0 (iPhone)", IP: "10.0.0.1"}, now.Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, "Jon's phone", phone.Label)
+	laptop, err := NewSession(1, Device{Label: "Laptop"}, now)
+	assert.NoError(t, err)
+	other, err := NewSession(2, Device{Label: "Jane's phone"}, now)
+	assert.NoError(t, err)
+	_, err = NewSession(1, Device{Label: "Old"}, now.Add(-auth.TokenLifespan))
+	assert.NoError(t, err)
+
+	// Only the active sessions of the user should be listed, the most recent first
+	sessions, err := GetSessionsByUserID(1, phone.ID)
+	assert.NoError(t, err)
+	if assert.Len(t, sessions, 2) {
+		assert.Equal(t, laptop.ID, sessions[0].ID)
+		assert.False(t, sessions[0].IsCurrent)
+		assert.Equal(t, phone.ID, sessions[1].ID)
+		assert.True(t, sessions[1].IsCurrent)
+		assert.Equal(t, "10.0.0.1", sessions[1].IP)
+	}
+
+	// The tokens of the sessions should be accepted, and the last seen time updated
+	assert.NoError(t, ValidateTokenPayload(auth.TokenPayload{UserID: 1, SessionID: phone.ID}))
+	phone, err = GetSessionByID(phone.ID)
+	assert.NoError(t, err)
+	assert.True(t, phone.LastSeenAt.After(now.Add(-time.Minute)))
+
+	// Tokens without a session, or with someone else's, should be rejected
+	assert.Equal(t, auth.ErrTokenRevoked, ValidateTokenPayload(auth.TokenPayload{UserID: 1}))
+	assert.Equal(t, auth.ErrTokenRevoked, ValidateTokenPayload(auth.TokenPayload{UserID: 1, SessionID: other.ID}))
+
+	// Users can only revoke their own sessions
+	assert.Equal(t, ErrSessionNotFound, Revoke(2, phone.ID))
+	assert.Equal(t, ErrSessionNotFound, Revoke(1, 12345))
+	assert.NoError(t, Revoke(1, phone.ID))
+	assert.Equal(t, ErrSessionNotFound, Revoke(1, phone.ID))
+
+	// The token of a revoked session should stop working straight away
+	assert.Equal(t, auth.ErrTokenRevoked, ValidateTokenPayload(auth.TokenPayload{UserID: 1, SessionID: phone.ID}))
+	assert.NoError(t, ValidateTokenPayload(auth.TokenPayload{UserID: 1, SessionID: laptop.ID}))
+
+	sessions, err = GetSessionsByUserID(1, phone.ID)
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 1)
+}
@@ -0,0 +1,96 @@
+package stats
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/teejays/matchapi/db"
+	"github.com/teejays/matchapi/service/report/v1"
+	"github.com/teejays/matchapi/service/user/v1"
+)
+
+// Stats is an overview of the state of the app for the admins
+type Stats struct {
+	Users   UserStats
+	Reports ReportStats
+	// Datetime is when the stats were computed
+	Datetime time.Time
+}
+
+// UserStats are the stats about the users
+type UserStats struct {
+	// Total is the number of users that have ever signed up
+	Total   int
+	Active  int
+	Deleted int
+	// NewLastDay and NewLastWeek are the number of active users that signed up in the last 24 hours and 7 days
+	NewLastDay  int
+	NewLastWeek int
+	// Flagged is the number of users whose profiles are waiting to be reviewed by a moderator
+	Flagged   int
+	Suspended int
+	Banned    int
+}
+
+// ReportStats are the stats about the reports
+type ReportStats struct {
+	Open     int
+	Resolved int
+}
+
+// GetStats computes the current stats
+func GetStats() (Stats, error) {
+	var s Stats
+	s.Datetime = time.Now()
+
+	// Users
+	active, err := user.GetActiveUsers()
+	if err != nil {
+		return s, err
+	}
+	for _, u := range active {
+		if u.DatetimeCreated.After(s.Datetime.Add(-24 * time.Hour)) {
+			s.Users.NewLastDay++
+		}
+		if u.DatetimeCreated.After(s.Datetime.AddDate(0, 0, -7)) {
+			s.Users.NewLastWeek++
+		}
+		if u.IsFlagged() {
+			s.Users.Flagged++
+		}
+		switch {
+		case u.IsBanned:
+			s.Users.Banned++
+		case u.IsSuspended(s.Datetime):
+			s.Users.Suspended++
+		}
+	}
+	s.Users.Active = len(active)
+
+	s.Users.Deleted, err = count(db.UserCollection, "IsDeleted:true")
+	if err != nil {
+		return s, err
+	}
+	s.Users.Total = s.Users.Active + s.Users.Deleted
+
+	// Reports
+	s.Reports.Open, err = count(db.ReportCollection, fmt.Sprintf("Status:%s", report.StatusOpen))
+	if err != nil {
+		return s, err
+	}
+	s.Reports.Resolved, err = count(db.ReportCollection, fmt.Sprintf("Status:%s", report.StatusResolved))
+	if err != nil {
+		return s, err
+	}
+
+	return s, nil
+}
+
+// count returns the number of entities that match the query
+func count(collection string, query string) (int, error) {
+	result, err := db.Query(collection, query)
+	if err != nil {
+		return 0, err
+	}
+	return len(result), nil
+}
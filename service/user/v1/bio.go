@@ -0,0 +1,137 @@
+package user
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/db"
+	"github.com/teejays/matchapi/lib/moderation"
+	"github.com/teejays/matchapi/lib/pk"
+	"github.com/teejays/matchapi/lib/validate"
+)
+
+const (
+	ModerationStatusClear int = iota
+	ModerationStatusFlagged
+)
+
+// MaxBioLength is the maximum number of characters in the bio
+var MaxBioLength = 500
+
+// MaxPrompts is the maximum number of prompts that a user can answer on their profile
+var MaxPrompts = 3
+
+// MaxPromptQuestionLength and MaxPromptAnswerLength are the maximum number of characters in a prompt
+var MaxPromptQuestionLength = 150
+var MaxPromptAnswerLength = 300
+
+// Prompt is a question, along with the user's answer to it, that is shown on the profile
+type Prompt struct {
+	Question string
+	Answer   string
+}
+
+// validateText validates the length and the content of the free-text parts of the profile
+func (p Profile) validateText(v *validate.Validator) {
+	v.Check(utf8.RuneCountInString(p.Bio) <= MaxBioLength, "Bio", validate.CodeTooLong, "bio cannot be longer than %d characters", MaxBioLength)
+	v.Check(moderation.Check(p.Bio).Action != moderation.ActionReject, "Bio", validate.CodeNotAllowed, "bio contains language that is not allowed")
+
+	v.Check(len(p.Prompts) <= MaxPrompts, "Prompts", validate.CodeTooMany, "a profile cannot have more than %d prompts", MaxPrompts)
+	var seenQuestions = make(map[string]bool)
+	for i, prompt := range p.Prompts {
+		field := fmt.Sprintf("Prompts[%d]", i)
+		q := strings.TrimSpace(prompt.Question)
+		v.Required(field+".Question", q, fmt.Sprintf("prompt %d: question cannot be empty", i+1))
+		v.Check(utf8.RuneCountInString(prompt.Question) <= MaxPromptQuestionLength, field+".Question", validate.CodeTooLong, "prompt %d: question cannot be longer than %d characters", i+1, MaxPromptQuestionLength)
+		v.Check(!seenQuestions[strings.ToLower(q)], field+".Question", validate.CodeDuplicate, "prompt %d: question is repeated", i+1)
+		seenQuestions[strings.ToLower(q)] = true
+
+		v.Required(field+".Answer", prompt.Answer, fmt.Sprintf("prompt %d: answer cannot be empty", i+1))
+		v.Check(utf8.RuneCountInString(prompt.Answer) <= MaxPromptAnswerLength, field+".Answer", validate.CodeTooLong, "prompt %d: answer cannot be longer than %d characters", i+1, MaxPromptAnswerLength)
+		v.Check(moderation.Check(prompt.Question).Merge(moderation.Check(prompt.Answer)).Action != moderation.ActionReject, field, validate.CodeNotAllowed, "prompt %d: contains language that is not allowed", i+1)
+	}
+}
+
+// checkText runs all the free-text parts of the profile through the moderation filter
+func (p ShareableProfile) checkText() moderation.Result {
+	res := moderation.Check(p.Bio)
+	for _, prompt := range p.Prompts {
+		res = res.Merge(moderation.Check(prompt.Question))
+		res = res.Merge(moderation.Check(prompt.Answer))
+	}
+	return res
+}
+
+// text returns all the free-text parts of the profile, so we can tell when they change
+func (p ShareableProfile) text() string {
+	var parts = []string{p.Bio}
+	for _, prompt := range p.Prompts {
+		parts = append(parts, prompt.Question, prompt.Answer)
+	}
+	return strings.Join(parts, "\n")
+}
+
+// moderate sets the moderation status of the user based on the current profile text
+func (u *User) moderate() {
+	res := u.checkText()
+	if res.Action == moderation.ActionNone {
+		u.ModerationStatus = ModerationStatusClear
+		u.ModerationFlags = nil
+		return
+	}
+	clog.Infof("User | moderate(): profile of user %d has been flagged for: %v", u.ID, res.Matches)
+	u.ModerationStatus = ModerationStatusFlagged
+	u.ModerationFlags = res.Matches
+}
+
+// IsFlagged returns true if the profile of the user is waiting to be reviewed by a moderator
+func (u *User) IsFlagged() bool {
+	return u.ModerationStatus == ModerationStatusFlagged
+}
+
+// ReviewModeration clears the flag on the user's profile. If the profile is not approved, the bio and the
+// prompts are removed from it.
+func (u *User) ReviewModeration(approved bool) error {
+	if !approved {
+		u.Bio = ""
+		u.Prompts = nil
+	}
+	u.ModerationStatus = ModerationStatusClear
+	u.ModerationFlags = nil
+	u.DatetimeUpdated = time.Now()
+
+	err := db.SaveEntityByID(db.UserCollection, u.ID, u)
+	return err
+}
+
+// GetFlaggedUsers returns the users whose profiles are waiting to be reviewed by a moderator
+func GetFlaggedUsers() ([]User, error) {
+	results, err := db.Query(db.UserCollection, fmt.Sprintf("ModerationStatus:%d", ModerationStatusFlagged))
+	if err != nil {
+		return nil, err
+	}
+
+	var users []User
+	for _, _v := range results {
+		v, ok := _v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("error fetching flagged users: one of the response is not a map[string]interface{}")
+		}
+		id, ok := v["ID"].(float64)
+		if !ok {
+			return nil, fmt.Errorf("error fetching flagged users: one of the response ID is not a number")
+		}
+
+		usr, err := GetUserByID(pk.ID(id))
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *usr)
+	}
+
+	return users, nil
+}
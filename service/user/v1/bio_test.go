@@ -0,0 +1,68 @@
+package user
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/teejays/matchapi/db"
+)
+
+func TestModeration(t *testing.T) {
+
+	// Initialize the mock DB client
+	err := db.InitMockClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.DestoryMockClient()
+
+	// Populate the User mock data in DB
+	err = HelperPopulateMockData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := GetUserByID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Flagged language should be saved, but the profile should be flagged
+	profile := u.Profile
+	profile.Bio = "Send me a tip on Venmo"
+	assert.NoError(t, u.UpdateProfile(profile))
+
+	u, err = GetUserByID(1)
+	assert.NoError(t, err)
+	assert.True(t, u.IsFlagged())
+	assert.Equal(t, []string{"venmo"}, u.ModerationFlags)
+
+	flagged, err := GetFlaggedUsers()
+	assert.NoError(t, err)
+	if assert.Len(t, flagged, 1) {
+		assert.Equal(t, u.ID, flagged[0].ID)
+	}
+
+	// Once approved, the same text should not be flagged again
+	assert.NoError(t, u.ReviewModeration(true))
+	profile = u.Profile
+	profile.FirstName = "Jonathan"
+	assert.NoError(t, u.UpdateProfile(profile))
+	u, err = GetUserByID(1)
+	assert.NoError(t, err)
+	assert.False(t, u.IsFlagged())
+	assert.Equal(t, "Send me a tip on Venmo", u.Bio)
+
+	// If the review is not approved, the text should be removed
+	profile = u.Profile
+	profile.Prompts = []Prompt{{Question: "Find me on", Answer: "0nlyFans"}}
+	assert.NoError(t, u.UpdateProfile(profile))
+	assert.True(t, u.IsFlagged())
+	assert.NoError(t, u.ReviewModeration(false))
+	u, err = GetUserByID(1)
+	assert.NoError(t, err)
+	assert.False(t, u.IsFlagged())
+	assert.Empty(t, u.Bio)
+	assert.Empty(t, u.Prompts)
+}
@@ -0,0 +1,122 @@
+package user
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/teejays/matchapi/db"
+	"github.com/teejays/matchapi/lib/validate"
+)
+
+const (
+	DealbreakerGender   = "gender"
+	DealbreakerAge      = "age"
+	DealbreakerDistance = "distance"
+)
+
+// Preferences represents what a user is looking for in a match. Zero values mean that the user
+// doesn't have a preference, e.g. a MaxAge of 0 means there is no upper age limit.
+type Preferences struct {
+	// Genders is the list of genders that the user is interested in. An empty list means all genders.
+	Genders []int
+	// MinAge and MaxAge define the range of ages, in years, that the user is interested in
+	MinAge int
+	MaxAge int
+	// MaxDistance is the maximum distance, in kilometers, at which a match can be
+	MaxDistance int
+	// Dealbreakers is the list of preferences that are strict. A candidate whose information is not known
+	// for a dealbreaker (e.g. a candidate who hasn't shared their location) is not considered a match.
+	// Possible values are `gender`, `age` and `distance`.
+	Dealbreakers []string
+}
+
+// maxPreferredAge and maxPreferredDistance are sanity limits for the preferences
+const maxPreferredAge = 120
+const maxPreferredDistance = 20000
+
+// Validate validates the preferences before saving
+func (p Preferences) Validate() error {
+	var v validate.Validator
+
+	var seenGenders = make(map[int]bool)
+	for i, g := range p.Genders {
+		field := fmt.Sprintf("Genders[%d]", i)
+		v.Check(g >= 1 && g <= 3, field, validate.CodeInvalid, "preferred gender %d is invalid; possible values are 1 (male), 2 (female) and 3 (other)", g)
+		v.Check(!seenGenders[g], field, validate.CodeDuplicate, "preferred gender %d is repeated", g)
+		seenGenders[g] = true
+	}
+	v.Check(p.MinAge == 0 || (p.MinAge >= MinimumAge && p.MinAge <= maxPreferredAge), "MinAge", validate.CodeOutOfRange, "preferred minimum age should be between %d and %d", MinimumAge, maxPreferredAge)
+	v.Check(p.MaxAge >= 0 && p.MaxAge <= maxPreferredAge, "MaxAge", validate.CodeOutOfRange, "preferred maximum age should be between 0 and %d", maxPreferredAge)
+	v.Check(p.MaxAge <= 0 || p.MaxAge >= p.MinAge, "MaxAge", validate.CodeOutOfRange, "preferred maximum age cannot be less than the preferred minimum age")
+	v.Check(p.MaxDistance >= 0 && p.MaxDistance <= maxPreferredDistance, "MaxDistance", validate.CodeOutOfRange, "preferred maximum distance should be between 0 and %d kilometers", maxPreferredDistance)
+	for i, d := range p.Dealbreakers {
+		v.Check(d == DealbreakerGender || d == DealbreakerAge || d == DealbreakerDistance, fmt.Sprintf("Dealbreakers[%d]", i), validate.CodeInvalid, "dealbreaker '%s' is invalid; possible values are '%s', '%s' and '%s'", d, DealbreakerGender, DealbreakerAge, DealbreakerDistance)
+	}
+
+	return v.Err()
+}
+
+// IsDealbreaker returns true if the provided preference is marked as a dealbreaker
+func (p Preferences) IsDealbreaker(name string) bool {
+	for _, d := range p.Dealbreakers {
+		if d == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Accepts returns true if the candidate satisfies the preferences of the user
+func (u *User) Accepts(candidate *User) bool {
+	p := u.Preferences
+
+	if len(p.Genders) > 0 && (candidate.Gender != GenderInvalid || p.IsDealbreaker(DealbreakerGender)) {
+		var found bool
+		for _, g := range p.Genders {
+			if g == candidate.Gender {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	age, known := candidate.AgeAt(time.Now())
+	if known && !isAgeInRange(age, p.MinAge, p.MaxAge) {
+		return false
+	}
+	if !known && (p.MinAge > 0 || p.MaxAge > 0) && p.IsDealbreaker(DealbreakerAge) {
+		return false
+	}
+
+	if p.MaxDistance > 0 {
+		distance, known := u.DistanceTo(candidate)
+		if known && distance > float64(p.MaxDistance) {
+			return false
+		}
+		if !known && p.IsDealbreaker(DealbreakerDistance) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// IsMutualMatch returns true if both the users satisfy each other's preferences
+func IsMutualMatch(a, b *User) bool {
+	return a.Accepts(b) && b.Accepts(a)
+}
+
+// UpdatePreferences updates the match preferences of the user
+func (u *User) UpdatePreferences(p Preferences) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	u.Preferences = p
+	u.DatetimeUpdated = time.Now()
+
+	err := db.SaveEntityByID(db.UserCollection, u.ID, u)
+	return err
+}
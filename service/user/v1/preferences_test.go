@@ -0,0 +1,81 @@
+package user
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreferencesValidate(t *testing.T) {
+
+	// Define the table tests
+	tt := []struct {
+		name        string
+		preferences Preferences
+		shouldErr   bool
+	}{
+		{
+			name:        "empty preferences should not give an error",
+			preferences: Preferences{},
+			shouldErr:   false,
+		},
+		{
+			name:        "invalid gender should give an error",
+			preferences: Preferences{Genders: []int{GenderMale, 7}},
+			shouldErr:   true,
+		},
+		{
+			name:        "max age lower than min age should give an error",
+			preferences: Preferences{MinAge: 30, MaxAge: 25},
+			shouldErr:   true,
+		},
+		{
+			name:        "negative distance should give an error",
+			preferences: Preferences{MaxDistance: -1},
+			shouldErr:   true,
+		},
+		{
+			name:        "unknown dealbreaker should give an error",
+			preferences: Preferences{Dealbreakers: []string{"height"}},
+			shouldErr:   true,
+		},
+		{
+			name: "valid preferences should not give an error",
+			preferences: Preferences{
+				Genders:      []int{GenderFemale, GenderOther},
+				MinAge:       25,
+				MaxAge:       35,
+				MaxDistance:  50,
+				Dealbreakers: []string{DealbreakerAge},
+			},
+			shouldErr: false,
+		},
+	}
+
+	// Run the table tests
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.preferences.Validate()
+			assert.Equal(t, test.shouldErr, err != nil)
+		})
+	}
+}
+
+func TestIsMutualMatch(t *testing.T) {
+
+	var john = *MockUsers[1]
+	var jane = *MockUsers[2]
+
+	// No preferences on either side
+	assert.True(t, IsMutualMatch(&john, &jane))
+
+	// Preferences satisfied on both sides
+	john.Preferences = Preferences{Genders: []int{GenderFemale}}
+	jane.Preferences = Preferences{Genders: []int{GenderMale}}
+	assert.True(t, IsMutualMatch(&john, &jane))
+
+	// Preferences satisfied on only one side
+	jane.Preferences = Preferences{Genders: []int{GenderOther}}
+	assert.False(t, IsMutualMatch(&john, &jane))
+	assert.False(t, IsMutualMatch(&jane, &john))
+}
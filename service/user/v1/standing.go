@@ -0,0 +1,201 @@
+package user
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/db"
+	"github.com/teejays/matchapi/lib/auth"
+	"github.com/teejays/matchapi/lib/pk"
+)
+
+var ErrRolesChanged = fmt.Errorf("the roles of the account have changed; please log in again")
+
+// Standing represents the moderation history of a user, and whether they are allowed to use the app
+type Standing struct {
+	// Warnings are the warnings that the moderators have given to the user
+	Warnings []Warning
+	// SuspendedUntil is the time until which the user cannot use the app. It is zero if the user has
+	// never been suspended.
+	SuspendedUntil time.Time
+	// IsBanned is true if the user has been permanently banned
+	IsBanned bool
+}
+
+// AccessError is returned when a user is not allowed to use the app because their account has been
+// suspended or banned. Its message is meant to be shown to the user.
+type AccessError struct {
+	// Banned is true if the account has been permanently banned
+	Banned bool
+	// Until is when the suspension ends. It is zero for banned accounts.
+	Until time.Time
+}
+
+func (e *AccessError) Error() string {
+	if e.Banned {
+		return "the account has been banned"
+	}
+	return fmt.Sprintf("the account has been suspended until %s", e.Until.UTC().Format(time.RFC3339))
+}
+
+// IsAccessError returns true if the error is an *AccessError
+func IsAccessError(err error) bool {
+	_, ok := err.(*AccessError)
+	return ok
+}
+
+// Warning is a message that a moderator has sent to a user about their behavior
+type Warning struct {
+	Message  string
+	Datetime time.Time
+}
+
+// AdminUser is the view of a user that is shown to the admins. It has everything except the credentials.
+type AdminUser struct {
+	ID pk.ID
+	Profile
+	IsDeleted        bool
+	IsEmailVerified  bool
+	Roles            []string
+	ModerationStatus int
+	ModerationFlags  []string
+	Standing
+	DatetimeCreated time.Time
+	DatetimeUpdated time.Time
+}
+
+// AdminView returns the view of the user that can be shown to the admins
+func (u *User) AdminView() AdminUser {
+	return AdminUser{
+		ID:               u.ID,
+		Profile:          u.Profile.WithImageURLs(),
+		IsDeleted:        u.IsDeleted,
+		IsEmailVerified:  u.IsEmailVerified,
+		Roles:            u.Roles,
+		ModerationStatus: u.ModerationStatus,
+		ModerationFlags:  u.ModerationFlags,
+		Standing:         u.Standing,
+		DatetimeCreated:  u.DatetimeCreated,
+		DatetimeUpdated:  u.DatetimeUpdated,
+	}
+}
+
+// IsSuspended returns true if the user is suspended at time t. It does not take bans into account.
+func (u *User) IsSuspended(t time.Time) bool {
+	return t.Before(u.SuspendedUntil)
+}
+
+// CheckAccess returns an *AccessError if the user is not allowed to use the app at time t because
+// they are banned or suspended
+func (u *User) CheckAccess(t time.Time) error {
+	if u.IsBanned {
+		return &AccessError{Banned: true}
+	}
+	if u.IsSuspended(t) {
+		return &AccessError{Until: u.SuspendedUntil}
+	}
+	return nil
+}
+
+// ValidateTokenPayload makes sure that the user of an auth token is still allowed to use the app, and
+// that the token doesn't grant any roles that the user no longer has. It is meant to be registered
+// as an auth.PayloadValidator.
+func ValidateTokenPayload(payload auth.TokenPayload) error {
+	u, err := GetUserByID(payload.UserID)
+	if db.IsNotExist(err) {
+		return ErrEntityDoesNotExist
+	}
+	if err != nil {
+		return err
+	}
+	if err := u.CheckAccess(time.Now()); err != nil {
+		return err
+	}
+	for _, role := range payload.Roles {
+		if !u.HasRole(role) {
+			return ErrRolesChanged
+		}
+	}
+	return nil
+}
+
+// HasRole returns true if the user has been granted the role
+func (u *User) HasRole(role string) bool {
+	return auth.HasRole(u.Roles, role)
+}
+
+// CanActOn returns true if the user has every role of the other user, so that a moderator can't take
+// actions against an admin
+func (u *User) CanActOn(other *User) bool {
+	return auth.HasAllRoles(u.Roles, other.Roles)
+}
+
+// SetRoles replaces the roles of the user
+func (u *User) SetRoles(roles []string) error {
+	if err := auth.ValidateRoles(roles); err != nil {
+		return err
+	}
+	u.Roles = roles
+	u.DatetimeUpdated = time.Now()
+
+	clog.Infof("User | SetRoles(): user %d now has the roles %v", u.ID, roles)
+
+	err := db.SaveEntityByID(db.UserCollection, u.ID, u)
+	return err
+}
+
+// Unsuspend lifts the suspension of the user, if any
+func (u *User) Unsuspend() error {
+	u.SuspendedUntil = time.Time{}
+	u.DatetimeUpdated = time.Now()
+
+	clog.Infof("User | Unsuspend(): suspension of user %d has been lifted", u.ID)
+
+	err := db.SaveEntityByID(db.UserCollection, u.ID, u)
+	return err
+}
+
+// Warn adds a warning to the user's record
+func (u *User) Warn(message string) error {
+	if strings.TrimSpace(message) == "" {
+		return fmt.Errorf("warning message cannot be empty")
+	}
+	u.Warnings = append(u.Warnings, Warning{Message: message, Datetime: time.Now()})
+	u.DatetimeUpdated = time.Now()
+
+	clog.Infof("User | Warn(): user %d has been warned", u.ID)
+
+	err := db.SaveEntityByID(db.UserCollection, u.ID, u)
+	return err
+}
+
+// Suspend stops the user from using the app until the provided time. A suspension never shortens an
+// existing one.
+func (u *User) Suspend(until time.Time) error {
+	if !until.After(time.Now()) {
+		return fmt.Errorf("suspension should end in the future")
+	}
+	if until.After(u.SuspendedUntil) {
+		u.SuspendedUntil = until
+	}
+	u.DatetimeUpdated = time.Now()
+
+	clog.Infof("User | Suspend(): user %d has been suspended until %s", u.ID, u.SuspendedUntil)
+
+	err := db.SaveEntityByID(db.UserCollection, u.ID, u)
+	return err
+}
+
+// Ban permanently stops the user from using the app
+func (u *User) Ban() error {
+	u.IsBanned = true
+	u.DatetimeUpdated = time.Now()
+
+	clog.Infof("User | Ban(): user %d has been banned", u.ID)
+
+	err := db.SaveEntityByID(db.UserCollection, u.ID, u)
+	return err
+}
@@ -0,0 +1,56 @@
+package user
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/teejays/matchapi/db"
+	"github.com/teejays/matchapi/lib/auth"
+)
+
+func TestValidateTokenPayload(t *testing.T) {
+
+	// Initialize the mock DB client
+	err := db.InitMockClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.DestoryMockClient()
+
+	// Populate the User mock data in DB
+	err = HelperPopulateMockData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := GetUserByID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := auth.TokenPayload{UserID: 1, Email: u.Email, Roles: []string{auth.RoleModerator}}
+
+	// The token grants a role that the user doesn't have
+	assert.Equal(t, ErrRolesChanged, ValidateTokenPayload(payload))
+
+	// Admins have every role
+	assert.Error(t, u.SetRoles([]string{"superuser"}))
+	assert.NoError(t, u.SetRoles([]string{auth.RoleAdmin}))
+	assert.NoError(t, ValidateTokenPayload(payload))
+
+	// Suspended users should be denied until the suspension ends
+	until := time.Now().Add(time.Hour)
+	assert.NoError(t, u.Suspend(until))
+	err = ValidateTokenPayload(payload)
+	if assert.IsType(t, &AccessError{}, err) {
+		assert.False(t, err.(*AccessError).Banned)
+		assert.True(t, until.Equal(err.(*AccessError).Until))
+		assert.Contains(t, err.Error(), until.UTC().Format(time.RFC3339))
+	}
+	assert.NoError(t, u.Unsuspend())
+	assert.NoError(t, ValidateTokenPayload(payload))
+
+	// Unknown users should be denied
+	assert.Equal(t, ErrEntityDoesNotExist, ValidateTokenPayload(auth.TokenPayload{UserID: 199999}))
+}
@@ -3,13 +3,19 @@ package user
 import (
 	"errors"
 	"fmt"
+	"math"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/teejays/clog"
 
 	"github.com/teejays/matchapi/db"
+	"github.com/teejays/matchapi/lib/blob"
+	"github.com/teejays/matchapi/lib/geo"
 	"github.com/teejays/matchapi/lib/pk"
+	"github.com/teejays/matchapi/lib/pubsub"
+	"github.com/teejays/matchapi/lib/validate"
 )
 
 const (
@@ -19,6 +25,20 @@ const (
 	GenderOther
 )
 
+// MinimumAge is the minimum age, in years, that a user needs to be in order to sign up
+var MinimumAge = 18
+
+// LocationDecimals is the number of decimal places that the coordinates of a user's location are rounded to
+// before they are saved, so that we never store precise locations
+var LocationDecimals = 2
+
+// GeohashPrecision is the precision of the geohash buckets that users are indexed by for radius lookups
+const GeohashPrecision = 4
+
+// maxIndexedRadius is the largest radius, in kilometers, for which the geohash index is used. For larger
+// radiuses it's cheaper to go through all the users.
+const maxIndexedRadius = 500
+
 // ErrEntityDoesNotExist is used when the requested entity does not exist in the system
 var ErrEntityDoesNotExist = errors.New("the requested entity does not exist")
 
@@ -28,6 +48,18 @@ type User struct {
 	Profile
 	PasswordHash []byte
 	IsDeleted    bool
+	// Geohash is the geohash bucket of the user's location, used as a spatial index. It is empty if the
+	// location of the user is not known.
+	Geohash string
+	// ModerationStatus is set to ModerationStatusFlagged when the profile text contains flagged terms.
+	// Flagged users are hidden from discovery until a moderator reviews them.
+	ModerationStatus int
+	// ModerationFlags are the terms that caused the profile to be flagged, to help the moderators
+	ModerationFlags []string
+	// Roles give the user access to the admin endpoints; possible values are `admin` and `moderator`
+	Roles []string
+	Standing
+	EmailVerification
 	meta
 }
 
@@ -48,6 +80,16 @@ type Profile struct {
 	ShareableProfile
 	LastName string
 	Email    string
+	// Location is the approximate location of the user. It is coarsened before saving, and is never shared
+	// with other users; they only see the distance.
+	Location *geo.Point
+	// Birthdate is the date of birth of the user. It is kept private, and only the computed age is shared with other users.
+	Birthdate time.Time
+	// TimeZone is the IANA name of the user's time zone (e.g. America/New_York). It is used to
+	// figure out when the user's day starts and ends. An empty value is treated as UTC.
+	TimeZone string
+	// Preferences are the match preferences of the user. They are updated separately using UpdatePreferences.
+	Preferences Preferences
 }
 
 // ShareableProfileUser is a part of the profile that can be shared with other users
@@ -60,7 +102,22 @@ type ShareableProfileUser struct {
 type ShareableProfile struct {
 	FirstName string
 	Gender    int
-	Images    []string
+	// Bio is a free-text description that the user writes about themselves
+	Bio string
+	// Prompts are the questions that the user has chosen to answer on their profile
+	Prompts []Prompt
+	// Images holds the keys of the user's images in the order they should be shown. The keys are
+	// converted into signed URLs by WithImageURLs before the profile leaves the server. They are
+	// managed by the photo service, so they cannot be changed through UpdateProfile.
+	Images []string
+	// Thumbnails are the signed URLs of the thumbnails of the Images, in the same order. They are only
+	// populated by WithImageURLs.
+	Thumbnails []string `json:",omitempty"`
+	// Age is computed from the Birthdate whenever the user is fetched; it is 0 if the Birthdate is not known
+	Age int
+	// Distance is the rounded distance in kilometers between the user and the viewer. It is only
+	// populated when the profile is shared with a viewer, and if both of their locations are known.
+	Distance *int `json:",omitempty"`
 }
 
 // NewUserRequest represents that request object that is used when
@@ -72,6 +129,12 @@ type NewUserRequest struct {
 
 var ErrEmailAlreadyExist = fmt.Errorf("Email is already taken")
 
+// emailLock makes sure that two users can't take the same email at the same time, between the check that
+// the email is free and the save
+var emailLock sync.Mutex
+
+var ErrBirthdateRequired = fmt.Errorf("birthdate is required")
+
 // NewUser creates a new instance of a user object and stores it in the database
 func NewUser(req NewUserRequest) (*ProfileUser, error) {
 
@@ -80,7 +143,15 @@ func NewUser(req NewUserRequest) (*ProfileUser, error) {
 		return nil, err
 	}
 
+	// Age needs to be known at sign up so we can keep out users under the MinimumAge. The check for
+	// the MinimumAge itself is a part of the profile validation.
+	if req.Birthdate.IsZero() {
+		return nil, ErrBirthdateRequired
+	}
+
 	// Make sure we don't have a user already with the email
+	emailLock.Lock()
+	defer emailLock.Unlock()
 	users, err := GetUserCredsByEmail(req.Email)
 	if err != nil {
 		return nil, err
@@ -91,6 +162,8 @@ func NewUser(req NewUserRequest) (*ProfileUser, error) {
 	// Create a new user object and populate it with data
 	var u User
 	u.Profile = req.Profile
+	u.setLocation(req.Location)
+	u.moderate()
 	u.PasswordHash = req.PasswordHash
 	u.DatetimeCreated = time.Now()
 	u.DatetimeUpdated = time.Now()
@@ -104,8 +177,7 @@ func NewUser(req NewUserRequest) (*ProfileUser, error) {
 	clog.Debugf("User | NewUser(): new ID generates: %d", id)
 
 	// Fetch the new entity from DB and return it
-	var user User
-	err = db.GetEntityByID(db.UserCollection, id, &user)
+	user, err := GetUserByID(id)
 	if err != nil {
 		return nil, err
 	}
@@ -127,9 +199,42 @@ func GetUserByID(id pk.ID) (*User, error) {
 		return nil, err
 	}
 
+	// Age is always computed fresh since it changes with time, and distance depends on the viewer
+	user.Age, _ = user.AgeAt(time.Now())
+	user.Distance = nil
+
 	return &user, nil
 }
 
+// GetActiveUsers returns all the users that have not been deleted
+func GetActiveUsers() ([]User, error) {
+	// Run the query to get all the users that are not deleted
+	results, err := db.Query(db.UserCollection, "IsDeleted:false")
+	if err != nil {
+		return nil, err
+	}
+
+	var users []User
+	for _, _v := range results {
+		v, ok := _v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("error fetching active users: one of the response is not a map[string]interface{}")
+		}
+		id, ok := v["ID"].(float64)
+		if !ok {
+			return nil, fmt.Errorf("error fetching active users: one of the response ID is not a number")
+		}
+
+		usr, err := GetUserByID(pk.ID(id))
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *usr)
+	}
+
+	return users, nil
+}
+
 type UserCred struct {
 	ID           pk.ID
 	Email        string
@@ -171,42 +276,286 @@ func GetUserCredsByEmail(email string) ([]UserCred, error) {
 	return creds, nil
 }
 
-// UpdateProfile ...
+// UpdateProfile replaces the profile of the user, except for the birthdate. If the email changes, it needs
+// to be verified again.
 func (u *User) UpdateProfile(profile Profile) error {
 	if err := profile.Validate(); err != nil {
 		return err
 	}
+	// Preferences are managed through UpdatePreferences, and images through the photo service,
+	// so they are carried over. The birthdate is set at sign up and cannot be changed, so that the
+	// age of the user stays known.
+	profile.Preferences = u.Preferences
+	profile.Images = u.Images
+	profile.Birthdate = u.Birthdate
+	profile.Thumbnails = nil
+	// A new email needs to be verified again, and can't belong to another user
+	emailChanged := !strings.EqualFold(profile.Email, u.Email)
+	if emailChanged {
+		emailLock.Lock()
+		defer emailLock.Unlock()
+		creds, err := GetUserCredsByEmail(profile.Email)
+		if err != nil {
+			return err
+		}
+		for _, c := range creds {
+			if c.ID != u.ID {
+				return ErrEmailAlreadyExist
+			}
+		}
+	}
+	// The text is only moderated again if it has changed, so that a profile that has been reviewed
+	// doesn't get flagged again for the same text
+	textChanged := profile.text() != u.text()
 	u.Profile = profile
+	u.setLocation(profile.Location)
+	if textChanged {
+		u.moderate()
+	}
+	if emailChanged {
+		u.IsEmailVerified = false
+		u.VerificationNonce = ""
+	}
 	u.DatetimeUpdated = time.Now()
 
 	err := db.SaveEntityByID(db.UserCollection, u.ID, u)
 	return err
 }
 
-// Validate validates a profile before saving
-func (p Profile) Validate() error {
-	var errs []error
+// imagesLock makes sure that concurrent changes to the images of a user don't overwrite each other
+var imagesLock sync.Mutex
+
+// UpdateImages replaces the ordered list of image keys of the user with the one returned by update, which
+// is given the current list. If update returns an error, nothing is saved. The user is read again under
+// the lock, so that only the images change, and anything saved in the meantime, e.g. a suspension, is kept.
+func UpdateImages(userID pk.ID, update func(images []string) ([]string, error)) error {
+	imagesLock.Lock()
+	defer imagesLock.Unlock()
+
+	u, err := GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	keys, err := update(u.Images)
+	if err != nil {
+		return err
+	}
+	u.Images = keys
+	u.DatetimeUpdated = time.Now()
+
+	err = db.SaveEntityByID(db.UserCollection, u.ID, u)
+	return err
+}
+
+// ThumbnailKey returns the key under which the thumbnail of an image is saved
+func ThumbnailKey(imageKey string) string {
+	return imageKey + "_thumb"
+}
+
+// WithImageURLs returns a copy of the profile in which the image keys are replaced by signed URLs
+func (p ShareableProfile) WithImageURLs() ShareableProfile {
+	if len(p.Images) == 0 {
+		return p
+	}
+	var images = make([]string, len(p.Images))
+	var thumbnails = make([]string, len(p.Images))
+	for i, key := range p.Images {
+		images[i] = blob.SignedURL(key)
+		thumbnails[i] = blob.SignedURL(ThumbnailKey(key))
+	}
+	p.Images = images
+	p.Thumbnails = thumbnails
+	return p
+}
+
+// WithImageURLs returns a copy of the profile in which the image keys are replaced by signed URLs
+func (p Profile) WithImageURLs() Profile {
+	p.ShareableProfile = p.ShareableProfile.WithImageURLs()
+	return p
+}
+
+// setLocation sets the coarsened location of the user along with the geohash bucket
+func (u *User) setLocation(p *geo.Point) {
+	if p == nil {
+		u.Location = nil
+		u.Geohash = ""
+		return
+	}
+	coarse := p.Coarsen(LocationDecimals)
+	u.Location = &coarse
+	u.Geohash = geo.Geohash(coarse, GeohashPrecision)
+}
+
+// DistanceTo returns the distance, in kilometers, between the two users. The second return value is false
+// if the location of either of the users is not known.
+func (u *User) DistanceTo(other *User) (float64, bool) {
+	if u.Location == nil || other.Location == nil {
+		return 0, false
+	}
+	return geo.Distance(*u.Location, *other.Location), true
+}
+
+// ShareWith returns the part of the user's profile that can be shared with the viewer, including
+// the distance between them
+func (u *User) ShareWith(viewer *User) ShareableProfileUser {
+	sp := ShareableProfileUser{
+		ID:               u.ID,
+		ShareableProfile: u.ShareableProfile.WithImageURLs(),
+	}
+	sp.Distance = nil
+	if viewer != nil {
+		if d, known := u.DistanceTo(viewer); known {
+			rounded := int(math.Round(d))
+			sp.Distance = &rounded
+		}
+	}
+	return sp
+}
+
+// RecordProfileView lets the user know that their profile has been viewed by the viewer
+func (u *User) RecordProfileView(viewer *User) {
+	if viewer == nil || viewer.ID == u.ID {
+		return
+	}
+	pubsub.Publish(pubsub.Event{Type: pubsub.EventProfileViewed, UserID: u.ID, Data: viewer.ShareWith(u)})
+}
+
+// GetUsersNearby returns the active users that are within the radius, in kilometers, of the center
+func GetUsersNearby(center geo.Point, radius float64) ([]User, error) {
+	var users []User
+
+	if radius > maxIndexedRadius {
+		all, err := GetActiveUsers()
+		if err != nil {
+			return nil, err
+		}
+		users = all
+	} else {
+		// Get the users in each of the geohash buckets that cover the circle
+		for _, h := range geo.CoveringGeohashes(center, radius, GeohashPrecision) {
+			results, err := db.Query(db.UserCollection, fmt.Sprintf("Geohash:%s", h))
+			if err != nil {
+				return nil, err
+			}
+			for _, _v := range results {
+				v, ok := _v.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("error fetching nearby users: one of the response is not a map[string]interface{}")
+				}
+				id, ok := v["ID"].(float64)
+				if !ok {
+					return nil, fmt.Errorf("error fetching nearby users: one of the response ID is not a number")
+				}
+				usr, err := GetUserByID(pk.ID(id))
+				if err != nil {
+					return nil, err
+				}
+				if !usr.IsDeleted {
+					users = append(users, *usr)
+				}
+			}
+		}
+	}
+
+	// The buckets cover more than the circle, so filter by the actual distance
+	var nearby []User
+	for _, u := range users {
+		if u.Location != nil && geo.Distance(center, *u.Location) <= radius {
+			nearby = append(nearby, u)
+		}
+	}
+
+	return nearby, nil
+}
 
-	if strings.TrimSpace(p.FirstName) == "" {
-		errs = append(errs, fmt.Errorf("first name cannot be empty"))
+// AgeAt returns the age of the user, in years, at time t. The second return value is false if
+// the Birthdate of the user is not known.
+func (u *User) AgeAt(t time.Time) (int, bool) {
+	return ageAt(u.Birthdate, t)
+}
+
+func ageAt(birthdate time.Time, t time.Time) (int, bool) {
+	if birthdate.IsZero() {
+		return 0, false
+	}
+
+	t = t.In(birthdate.Location())
+	age := t.Year() - birthdate.Year()
+	// Subtract a year if the birthday hasn't come yet this year
+	if t.Month() < birthdate.Month() || (t.Month() == birthdate.Month() && t.Day() < birthdate.Day()) {
+		age--
+	}
+
+	return age, true
+}
+
+// FilterByAge returns the users whose age falls within the provided range (inclusive). A min or max
+// of 0 means there is no limit on that side. Users whose age is not known are only included if the
+// includeUnknown flag is true.
+func FilterByAge(users []User, min, max int, includeUnknown bool) []User {
+	var filtered []User
+	now := time.Now()
+	for _, u := range users {
+		age, known := u.AgeAt(now)
+		if !known {
+			if includeUnknown {
+				filtered = append(filtered, u)
+			}
+			continue
+		}
+		if isAgeInRange(age, min, max) {
+			filtered = append(filtered, u)
+		}
 	}
-	if strings.TrimSpace(p.LastName) == "" {
-		errs = append(errs, fmt.Errorf("last name cannot be empty"))
+	return filtered
+}
+
+func isAgeInRange(age, min, max int) bool {
+	if min > 0 && age < min {
+		return false
 	}
-	if strings.TrimSpace(p.Email) == "" {
-		errs = append(errs, fmt.Errorf("email cannot be empty"))
+	if max > 0 && age > max {
+		return false
 	}
-	if p.Gender < 1 || p.Gender > 3 {
-		errs = append(errs, fmt.Errorf("gender is invalid; possible values are 1 (male), 2 (female) and 3 (other)"))
+	return true
+}
+
+// TimeLocation returns the time.Location of the user based on their TimeZone. It falls back to
+// UTC if the user has not set a time zone, or if the time zone cannot be loaded.
+func (u *User) TimeLocation() *time.Location {
+	loc, err := time.LoadLocation(u.TimeZone)
+	if err != nil {
+		clog.Warnf("User | TimeLocation(): could not load the time zone '%s' for user %d: %v", u.TimeZone, u.ID, err)
+		return time.UTC
 	}
+	return loc
+}
 
-	if len(errs) > 0 {
-		var errMsg string
-		for i, err := range errs {
-			errMsg = fmt.Sprintf("%s\n%d) %v", errMsg, i+1, err)
+// Validate validates a profile before saving
+func (p Profile) Validate() error {
+	var v validate.Validator
+
+	v.Required("FirstName", p.FirstName, "first name cannot be empty")
+	v.Required("LastName", p.LastName, "last name cannot be empty")
+	v.Required("Email", p.Email, "email cannot be empty")
+	v.Check(p.Gender >= 1 && p.Gender <= 3, "Gender", validate.CodeInvalid, "gender is invalid; possible values are 1 (male), 2 (female) and 3 (other)")
+	if !p.Birthdate.IsZero() {
+		age, _ := ageAt(p.Birthdate, time.Now())
+		if v.Check(!p.Birthdate.After(time.Now()), "Birthdate", validate.CodeInvalid, "birthdate cannot be in the future") {
+			v.Check(age >= MinimumAge, "Birthdate", validate.CodeOutOfRange, "users need to be at least %d years old", MinimumAge)
+		}
+	}
+	if p.Location != nil {
+		if err := p.Location.Validate(); err != nil {
+			v.Add("Location", validate.CodeInvalid, "location is invalid: %v", err)
 		}
-		return fmt.Errorf(errMsg)
 	}
+	if _, err := time.LoadLocation(p.TimeZone); err != nil {
+		v.Add("TimeZone", validate.CodeInvalid, "time zone is invalid; it should be a valid IANA time zone name e.g. America/New_York")
+	}
+
+	p.validateText(&v)
+	v.Merge("Preferences", p.Preferences.Validate())
 
-	return nil
+	return v.Err()
 }
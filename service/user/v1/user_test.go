@@ -1,13 +1,17 @@
 package user
 
 import (
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/teejays/clog"
 
 	"github.com/teejays/matchapi/db"
+	"github.com/teejays/matchapi/lib/geo"
 	"github.com/teejays/matchapi/lib/pk"
+	"github.com/teejays/matchapi/lib/validate"
 )
 
 func init() {
@@ -25,7 +29,7 @@ func TestValidate(t *testing.T) {
 		{
 			name: "empty first name should give an error",
 			profile: Profile{
-				ShareableProfile: ShareableProfile{"", GenderMale, []string{}},
+				ShareableProfile: ShareableProfile{FirstName: "", Gender: GenderMale, Images: []string{}},
 				LastName:         "Doe",
 				Email:            "jon.doe@email.com",
 			},
@@ -34,7 +38,7 @@ func TestValidate(t *testing.T) {
 		{
 			name: "empty last name should give an error",
 			profile: Profile{
-				ShareableProfile: ShareableProfile{"Jon", GenderMale, []string{}},
+				ShareableProfile: ShareableProfile{FirstName: "Jon", Gender: GenderMale, Images: []string{}},
 				LastName:         "",
 				Email:            "jon.doe@email.com",
 			},
@@ -43,7 +47,7 @@ func TestValidate(t *testing.T) {
 		{
 			name: "empty email should give an error",
 			profile: Profile{
-				ShareableProfile: ShareableProfile{"Jon", GenderMale, []string{}},
+				ShareableProfile: ShareableProfile{FirstName: "Jon", Gender: GenderMale, Images: []string{}},
 				LastName:         "Doe",
 				Email:            "",
 			},
@@ -52,16 +56,66 @@ func TestValidate(t *testing.T) {
 		{
 			name: "invalid gender should give an error",
 			profile: Profile{
-				ShareableProfile: ShareableProfile{"Jon", 7, []string{}},
+				ShareableProfile: ShareableProfile{FirstName: "Jon", Gender: 7, Images: []string{}},
 				LastName:         "Doe",
 				Email:            "jon.doe@email.com",
 			},
 			shouldErr: true,
 		},
+		{
+			name: "bio that is too long should give an error",
+			profile: Profile{
+				ShareableProfile: ShareableProfile{FirstName: "Jon", Gender: GenderMale, Bio: strings.Repeat("a", MaxBioLength+1)},
+				LastName:         "Doe",
+				Email:            "jon.doe@email.com",
+			},
+			shouldErr: true,
+		},
+		{
+			name: "bio with rejected language should give an error",
+			profile: Profile{
+				ShareableProfile: ShareableProfile{FirstName: "Jon", Gender: GenderMale, Bio: "If you swipe left, k1ll yourself"},
+				LastName:         "Doe",
+				Email:            "jon.doe@email.com",
+			},
+			shouldErr: true,
+		},
+		{
+			name: "bio with flagged language should not give an error",
+			profile: Profile{
+				ShareableProfile: ShareableProfile{FirstName: "Jon", Gender: GenderMale, Bio: "Only losers swipe left. Also, idiot."},
+				LastName:         "Doe",
+				Email:            "jon.doe@email.com",
+			},
+			shouldErr: false,
+		},
+		{
+			name: "prompt without an answer should give an error",
+			profile: Profile{
+				ShareableProfile: ShareableProfile{FirstName: "Jon", Gender: GenderMale, Prompts: []Prompt{{Question: "My ideal Sunday", Answer: " "}}},
+				LastName:         "Doe",
+				Email:            "jon.doe@email.com",
+			},
+			shouldErr: true,
+		},
+		{
+			name: "too many prompts should give an error",
+			profile: Profile{
+				ShareableProfile: ShareableProfile{FirstName: "Jon", Gender: GenderMale, Prompts: []Prompt{
+					{Question: "My ideal Sunday", Answer: "Brunch"},
+					{Question: "I geek out on", Answer: "Maps"},
+					{Question: "My most irrational fear", Answer: "Geese"},
+					{Question: "Two truths and a lie", Answer: "..."},
+				}},
+				LastName: "Doe",
+				Email:    "jon.doe@email.com",
+			},
+			shouldErr: true,
+		},
 		{
 			name: "valid profile should not give an error",
 			profile: Profile{
-				ShareableProfile: ShareableProfile{"Jon", GenderMale, []string{}},
+				ShareableProfile: ShareableProfile{FirstName: "Jon", Gender: GenderMale, Images: []string{}},
 				LastName:         "Doe",
 				Email:            "jon.doe@email.com",
 			},
@@ -78,6 +132,31 @@ func TestValidate(t *testing.T) {
 	}
 }
 
+func TestValidateFields(t *testing.T) {
+	profile := Profile{
+		ShareableProfile: ShareableProfile{
+			FirstName: "Jon",
+			Gender:    7,
+			Prompts:   []Prompt{{Question: "Favourite food?", Answer: "Pizza"}, {Question: "favourite food?"}},
+		},
+		Email:       "jon.doe@email.com",
+		Preferences: Preferences{MinAge: 10},
+	}
+
+	// Every field that is not valid is reported, with the path to nested fields
+	err := profile.Validate()
+	errs, ok := err.(validate.Errors)
+	if assert.True(t, ok) {
+		assert.Equal(t, validate.Errors{
+			{Field: "LastName", Code: validate.CodeRequired, Message: "last name cannot be empty"},
+			{Field: "Gender", Code: validate.CodeInvalid, Message: "gender is invalid; possible values are 1 (male), 2 (female) and 3 (other)"},
+			{Field: "Prompts[1].Question", Code: validate.CodeDuplicate, Message: "prompt 2: question is repeated"},
+			{Field: "Prompts[1].Answer", Code: validate.CodeRequired, Message: "prompt 2: answer cannot be empty"},
+			{Field: "Preferences.MinAge", Code: validate.CodeOutOfRange, Message: "preferred minimum age should be between 18 and 120"},
+		}, errs)
+	}
+}
+
 func TestNewUser(t *testing.T) {
 
 	// Define the table tests
@@ -89,18 +168,38 @@ func TestNewUser(t *testing.T) {
 		{
 			name: "invalid profile should give an error",
 			profile: Profile{
-				ShareableProfile: ShareableProfile{"", GenderInvalid, []string{}},
+				ShareableProfile: ShareableProfile{FirstName: "", Gender: GenderInvalid, Images: []string{}},
 				LastName:         "",
 				Email:            "",
 			},
 			shouldErr: true,
 		},
+		{
+			name: "profile without a birthdate should give an error",
+			profile: Profile{
+				ShareableProfile: ShareableProfile{FirstName: "Jon", Gender: GenderMale, Images: []string{}},
+				LastName:         "Doe",
+				Email:            "jon.doe@email.com",
+			},
+			shouldErr: true,
+		},
+		{
+			name: "profile under the minimum age should give an error",
+			profile: Profile{
+				ShareableProfile: ShareableProfile{FirstName: "Jon", Gender: GenderMale, Images: []string{}},
+				LastName:         "Doe",
+				Email:            "jon.doe@email.com",
+				Birthdate:        time.Now().AddDate(-MinimumAge, 0, 1),
+			},
+			shouldErr: true,
+		},
 		{
 			name: "valid profile should not give an error",
 			profile: Profile{
-				ShareableProfile: ShareableProfile{"Jon", GenderMale, []string{}},
+				ShareableProfile: ShareableProfile{FirstName: "Jon", Gender: GenderMale, Images: []string{}},
 				LastName:         "Doe",
 				Email:            "jon.doe@email.com",
+				Birthdate:        time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC),
 			},
 			shouldErr: false,
 		},
@@ -116,11 +215,13 @@ func TestNewUser(t *testing.T) {
 	// Run the table tests
 	for _, test := range tt {
 		t.Run(test.name, func(t *testing.T) {
-			user, err := NewUser(test.profile)
+			user, err := NewUser(NewUserRequest{Profile: test.profile})
 			assert.Equal(t, err != nil, test.shouldErr)
 			if !test.shouldErr {
 				assert.NotEqual(t, 0, user.ID)
-				assert.Equal(t, test.profile, user.Profile)
+				expectedProfile := test.profile
+				expectedProfile.Age, _ = ageAt(test.profile.Birthdate, time.Now())
+				assert.Equal(t, expectedProfile, user.Profile)
 			}
 		})
 	}
@@ -211,10 +312,24 @@ func TestUpdateProfile(t *testing.T) {
 			shouldErr: true,
 		},
 		{
-			name:       "updating to a valid profile should be okay",
-			id:         1,
-			newProfile: MockUsers[2].Profile,
-			shouldErr:  false,
+			name: "updating to the email of another user should give an error",
+			id:   1,
+			newProfile: Profile{
+				ShareableProfile: ShareableProfile{FirstName: "John", Gender: GenderMale},
+				LastName:         "Doe",
+				Email:            MockUsers[2].Email,
+			},
+			shouldErr: true,
+		},
+		{
+			name: "updating to a valid profile should be okay",
+			id:   1,
+			newProfile: Profile{
+				ShareableProfile: ShareableProfile{FirstName: "Johnny", Gender: GenderMale},
+				LastName:         "Doe",
+				Email:            "johnny.doe@email.com",
+			},
+			shouldErr: false,
 		},
 	}
 
@@ -241,4 +356,125 @@ func TestUpdateProfile(t *testing.T) {
 			}
 		})
 	}
+
+	// The birthdate cannot be removed or changed after sign up
+	u, err := GetUserByID(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	birthdate := time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC)
+	u.Birthdate = birthdate
+	err = db.SaveEntityByID(db.UserCollection, u.ID, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := u.Profile
+	profile.Birthdate = time.Time{}
+	assert.NoError(t, u.UpdateProfile(profile))
+	profile.Birthdate = time.Now().AddDate(-60, 0, 0)
+	assert.NoError(t, u.UpdateProfile(profile))
+	u, err = GetUserByID(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, birthdate.Equal(u.Birthdate))
+}
+
+func TestAgeAt(t *testing.T) {
+
+	birthdate := time.Date(1990, 6, 15, 0, 0, 0, 0, time.UTC)
+
+	tt := []struct {
+		name        string
+		birthdate   time.Time
+		at          time.Time
+		expectedAge int
+		known       bool
+	}{
+		{
+			name:  "unknown birthdate should give unknown age",
+			at:    time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+			known: false,
+		},
+		{
+			name:        "day before the birthday",
+			birthdate:   birthdate,
+			at:          time.Date(2020, 6, 14, 0, 0, 0, 0, time.UTC),
+			expectedAge: 29,
+			known:       true,
+		},
+		{
+			name:        "on the birthday",
+			birthdate:   birthdate,
+			at:          time.Date(2020, 6, 15, 0, 0, 0, 0, time.UTC),
+			expectedAge: 30,
+			known:       true,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			u := User{Profile: Profile{Birthdate: test.birthdate}}
+			age, known := u.AgeAt(test.at)
+			assert.Equal(t, test.known, known)
+			assert.Equal(t, test.expectedAge, age)
+		})
+	}
+}
+
+func TestGetUsersNearby(t *testing.T) {
+
+	// Initialize the mock DB client
+	err := db.InitMockClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.DestoryMockClient()
+
+	// Populate some data
+	err = HelperPopulateMockData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Give the users a location
+	locations := map[pk.ID]geo.Point{
+		1: {Lat: 40.7128, Lng: -74.0060}, // Manhattan
+		2: {Lat: 40.6782, Lng: -73.9442}, // Brooklyn
+		3: {Lat: 51.5074, Lng: -0.1278},  // London
+	}
+	for id, loc := range locations {
+		u, err := GetUserByID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		profile := u.Profile
+		profile.Location = &loc
+		err = u.UpdateProfile(profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	users, err := GetUsersNearby(locations[1], 50)
+	assert.NoError(t, err)
+	var ids []pk.ID
+	for _, u := range users {
+		ids = append(ids, u.ID)
+	}
+	assert.ElementsMatch(t, []pk.ID{1, 2}, ids)
+
+	// Sharing the profile should only reveal the rounded distance
+	john, err := GetUserByID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jane, err := GetUserByID(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared := jane.ShareWith(john)
+	if assert.NotNil(t, shared.Distance) {
+		assert.Equal(t, 7, *shared.Distance)
+	}
 }
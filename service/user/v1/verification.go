@@ -0,0 +1,182 @@
+package user
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/teejays/clog"
+
+	"github.com/teejays/matchapi/db"
+	"github.com/teejays/matchapi/lib/auth"
+	"github.com/teejays/matchapi/lib/pk"
+)
+
+// VerificationSecretKey is used to sign the email verification tokens. It is random unless it is configured,
+// so the links that have been sent stop working after a restart.
+var VerificationSecretKey = auth.NewSecretKey()
+
+// VerificationTokenTTL is how long an email verification token can be used for
+var VerificationTokenTTL = 48 * time.Hour
+
+// VerificationResendInterval is how long the users have to wait before they can ask for another verification
+// email, so that the resend endpoint can't be used to flood an inbox
+var VerificationResendInterval = 5 * time.Minute
+
+// VerificationURL is the link sent to the users to verify their email. The token is added as the `token`
+// query param; the page is expected to send it to `POST /v1/user/verify`.
+var VerificationURL = "http://localhost:8080/verify"
+
+// RequireVerifiedEmail, if true, stops the users from liking and discovering other users until they have
+// verified their email
+var RequireVerifiedEmail = false
+
+var ErrInvalidVerificationToken = fmt.Errorf("the verification token is invalid or has already been used")
+var ErrVerificationTokenExpired = fmt.Errorf("the verification token has expired; please ask for a new one")
+var ErrEmailAlreadyVerified = fmt.Errorf("the email has already been verified")
+var ErrEmailNotVerified = fmt.Errorf("the email needs to be verified first")
+var ErrVerificationRecentlySent = fmt.Errorf("a verification email has been sent recently; please check your inbox or try again later")
+
+// EmailVerification keeps track of whether the user has verified that they own their email
+type EmailVerification struct {
+	IsEmailVerified bool
+	// VerificationNonce is included in the latest verification token. It is cleared once the token is used,
+	// and replaced when a new token is issued, so that each token can only be used once.
+	VerificationNonce string
+	// VerificationSentAt is when the latest token was issued
+	VerificationSentAt time.Time
+}
+
+// CheckEmailVerified returns ErrEmailNotVerified if verified emails are required and the user hasn't
+// verified theirs
+func (u *User) CheckEmailVerified() error {
+	if RequireVerifiedEmail && !u.IsEmailVerified {
+		return ErrEmailNotVerified
+	}
+	return nil
+}
+
+// NewVerificationToken issues a signed token that verifies the user's current email. Issuing a token
+// invalidates the previous ones.
+func (u *User) NewVerificationToken() (string, error) {
+	if u.IsEmailVerified {
+		return "", ErrEmailAlreadyVerified
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	u.VerificationNonce = hex.EncodeToString(nonce)
+	u.VerificationSentAt = time.Now()
+
+	err := db.SaveEntityByID(db.UserCollection, u.ID, u)
+	if err != nil {
+		return "", err
+	}
+
+	expires := time.Now().Add(VerificationTokenTTL).Unix()
+	payload := fmt.Sprintf("%d:%s:%d", u.ID, u.VerificationNonce, expires)
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+
+	return encoded + "." + signVerification(encoded), nil
+}
+
+// resendLock makes sure that two requests for another verification email can't both get past the wait
+var resendLock sync.Mutex
+
+// ResendVerificationToken issues a new verification token for the user, when they ask for the email again. It
+// returns ErrVerificationRecentlySent if the previous token was issued less than VerificationResendInterval ago.
+func ResendVerificationToken(userID pk.ID) (*User, string, error) {
+	resendLock.Lock()
+	defer resendLock.Unlock()
+
+	u, err := GetUserByID(userID)
+	if err != nil {
+		return nil, "", err
+	}
+	if u.IsEmailVerified {
+		return nil, "", ErrEmailAlreadyVerified
+	}
+	if time.Now().Before(u.VerificationSentAt.Add(VerificationResendInterval)) {
+		return nil, "", ErrVerificationRecentlySent
+	}
+
+	token, err := u.NewVerificationToken()
+	if err != nil {
+		return nil, "", err
+	}
+	return u, token, nil
+}
+
+// VerificationLink returns the link that the user should open to verify their email with the token
+func VerificationLink(token string) string {
+	return VerificationURL + "?token=" + url.QueryEscape(token)
+}
+
+// VerifyEmail marks the email of the user that the token was issued for as verified
+func VerifyEmail(token string) (*User, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, ErrInvalidVerificationToken
+	}
+	if !hmac.Equal([]byte(parts[1]), []byte(signVerification(parts[0]))) {
+		return nil, ErrInvalidVerificationToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidVerificationToken
+	}
+	fields := strings.Split(string(payload), ":")
+	if len(fields) != 3 {
+		return nil, ErrInvalidVerificationToken
+	}
+	id, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return nil, ErrInvalidVerificationToken
+	}
+	expires, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	u, err := GetUserByID(pk.ID(id))
+	if db.IsNotExist(err) {
+		return nil, ErrInvalidVerificationToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if u.VerificationNonce == "" || !hmac.Equal([]byte(fields[1]), []byte(u.VerificationNonce)) {
+		return nil, ErrInvalidVerificationToken
+	}
+	if time.Now().Unix() > expires {
+		return nil, ErrVerificationTokenExpired
+	}
+
+	u.IsEmailVerified = true
+	u.VerificationNonce = ""
+	u.DatetimeUpdated = time.Now()
+
+	clog.Infof("User | VerifyEmail(): user %d has verified their email", u.ID)
+
+	err = db.SaveEntityByID(db.UserCollection, u.ID, u)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func signVerification(message string) string {
+	h := hmac.New(sha256.New, []byte(VerificationSecretKey))
+	h.Write([]byte(message))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
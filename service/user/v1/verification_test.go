@@ -0,0 +1,129 @@
+package user
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/teejays/matchapi/db"
+	"github.com/teejays/matchapi/lib/pk"
+)
+
+func TestVerifyEmail(t *testing.T) {
+
+	// Initialize the mock DB client
+	err := db.InitMockClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.DestoryMockClient()
+
+	// Populate the User mock data in DB
+	err = HelperPopulateMockData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := GetUserByID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, u.IsEmailVerified)
+
+	// Unverified users can do everything unless verification is required
+	assert.NoError(t, u.CheckEmailVerified())
+	RequireVerifiedEmail = true
+	defer func() { RequireVerifiedEmail = false }()
+	assert.Equal(t, ErrEmailNotVerified, u.CheckEmailVerified())
+
+	// Issuing a new token invalidates the previous one
+	oldToken, err := u.NewVerificationToken()
+	assert.NoError(t, err)
+	token, err := u.NewVerificationToken()
+	assert.NoError(t, err)
+	assert.Contains(t, VerificationLink(token), "?token=")
+
+	_, err = VerifyEmail(oldToken)
+	assert.Equal(t, ErrInvalidVerificationToken, err)
+
+	// Tampered tokens are rejected
+	_, err = VerifyEmail(token + "x")
+	assert.Equal(t, ErrInvalidVerificationToken, err)
+	_, err = VerifyEmail("garbage")
+	assert.Equal(t, ErrInvalidVerificationToken, err)
+
+	// The token can only be used once
+	u, err = VerifyEmail(token)
+	assert.NoError(t, err)
+	assert.True(t, u.IsEmailVerified)
+	assert.NoError(t, u.CheckEmailVerified())
+	_, err = VerifyEmail(token)
+	assert.Equal(t, ErrInvalidVerificationToken, err)
+	_, err = u.NewVerificationToken()
+	assert.Equal(t, ErrEmailAlreadyVerified, err)
+
+	// Changing the email needs another verification, but changing its case doesn't
+	u, err = GetUserByID(1)
+	assert.NoError(t, err)
+	profile := u.Profile
+	profile.Email = "JOHN.DOE@email.com"
+	assert.NoError(t, u.UpdateProfile(profile))
+	assert.True(t, u.IsEmailVerified)
+
+	profile.Email = "john.new@email.com"
+	assert.NoError(t, u.UpdateProfile(profile))
+	u, err = GetUserByID(1)
+	assert.NoError(t, err)
+	assert.False(t, u.IsEmailVerified)
+
+	// Expired tokens are rejected
+	VerificationTokenTTL = -time.Minute
+	defer func() { VerificationTokenTTL = 48 * time.Hour }()
+	token, err = u.NewVerificationToken()
+	assert.NoError(t, err)
+	_, err = VerifyEmail(token)
+	assert.Equal(t, ErrVerificationTokenExpired, err)
+}
+
+func TestResendVerificationToken(t *testing.T) {
+
+	// Initialize the mock DB client
+	err := db.InitMockClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.DestoryMockClient()
+
+	// Populate the User mock data in DB
+	err = HelperPopulateMockData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first email can be sent straight away, but not another one right after it
+	u, token, err := ResendVerificationToken(1)
+	assert.NoError(t, err)
+	assert.Equal(t, pk.ID(1), u.ID)
+	_, _, err = ResendVerificationToken(1)
+	assert.Equal(t, ErrVerificationRecentlySent, err)
+
+	// The wait applies to the emails sent at sign up or after an email change too
+	_, err = u.NewVerificationToken()
+	assert.NoError(t, err)
+	_, _, err = ResendVerificationToken(1)
+	assert.Equal(t, ErrVerificationRecentlySent, err)
+
+	// Once the wait is over, another email can be sent
+	defer func(d time.Duration) { VerificationResendInterval = d }(VerificationResendInterval)
+	VerificationResendInterval = 0
+	_, newToken, err := ResendVerificationToken(1)
+	assert.NoError(t, err)
+	_, err = VerifyEmail(token)
+	assert.Equal(t, ErrInvalidVerificationToken, err)
+	_, err = VerifyEmail(newToken)
+	assert.NoError(t, err)
+
+	_, _, err = ResendVerificationToken(1)
+	assert.Equal(t, ErrEmailAlreadyVerified, err)
+}